@@ -17,6 +17,7 @@ import (
 	"encoding/json"
 	"fmt"
 	"github.com/containerd/log"
+	units "github.com/docker/go-units"
 	"github.com/fatih/color"
 	"github.com/hogklint/tailfin/stern"
 	"github.com/mitchellh/go-homedir"
@@ -26,13 +27,14 @@ import (
 	"gopkg.in/yaml.v3"
 	"io"
 	"os"
+	"os/signal"
 	"regexp"
+	"slices"
 	"strconv"
 	"strings"
+	"syscall"
 	"text/template"
 	"time"
-
-	dockerclient "github.com/docker/docker/client"
 )
 
 // Use "~" to avoid exposing the user name in the help message
@@ -46,56 +48,92 @@ type IOStreams struct {
 type options struct {
 	IOStreams
 
-	color            string
-	completion       string
-	compose          []string
-	context          string
-	configFilePath   string
-	containerColors  []string
-	containerQuery   []string
-	exclude          []string
-	excludeContainer []string
-	highlight        []string
-	image            []string
-	include          []string
-	label            []string
-	maxLogRequests   int
-	namespaceColor   []string
-	noFollow         bool
-	onlyLogLines     bool
-	output           string
-	since            time.Duration
-	stdin            bool
-	tail             int64
-	template         string
-	templateFile     string
-	timestamps       string
-	timezone         string
-	verbosity        string
-	version          bool
-	//containerStates     []string
+	checkpointFile       string
+	color                string
+	completion           string
+	containerStates      []string
+	contexts             []string
+	compose              []string
+	allContexts          bool
+	configFilePath       string
+	containerColors      []string
+	containerQuery       []string
+	exclude              []string
+	eventTemplate        string
+	events               bool
+	excludeContainer     []string
+	files                []string
+	health               []string
+	highlight            []string
+	image                []string
+	include              []string
+	inputFormat          string
+	label                []string
+	level                string
+	maxLineSize          int
+	maxLogRequests       int
+	maxLogRequestsPolicy string
+	multilinePattern     string
+	multilineStart       string
+	multilineTimeout     time.Duration
+	namespaceColor       []string
+	network              []string
+	noFollow             bool
+	onlyLogLines         bool
+	output               string
+	outputCompress       bool
+	outputDir            string
+	outputMaxAge         time.Duration
+	outputMaxSize        string
+	outputTee            bool
+	publish              []string
+	service              []string
+	since                time.Duration
+	sinceTime            string
+	sinks                []string
+	sortByTime           bool
+	sortWindow           time.Duration
+	stack                []string
+	stdin                bool
+	stdinTimestampFormat string
+	stream               string
+	tail                 int64
+	template             string
+	templateFile         string
+	timestamps           string
+	timezone             string
+	until                time.Duration
+	untilTime            string
+	verbosity            string
+	version              bool
+	volume               []string
 	//selector            string
 
-	dockerClient *dockerclient.Client
+	dockerHosts []stern.DockerHost
 }
 
 func NewOptions(streams IOStreams) *options {
 	return &options{
 		IOStreams: streams,
 
-		color: "auto",
-		//containerStates:     []string{stern.ALL_STATES},
-		output:         "default",
-		since:          48 * time.Hour,
-		tail:           -1,
-		template:       "",
-		templateFile:   "",
-		timestamps:     "",
-		timezone:       "Local",
-		noFollow:       false,
-		verbosity:      "fatal",
-		maxLogRequests: -1,
-		configFilePath: defaultConfigFilePath,
+		color:                "auto",
+		inputFormat:          stern.InputFormatText,
+		output:               "default",
+		since:                48 * time.Hour,
+		sortWindow:           time.Second,
+		stream:               "all",
+		tail:                 -1,
+		template:             "",
+		templateFile:         "",
+		timestamps:           "",
+		timezone:             "Local",
+		noFollow:             false,
+		verbosity:            "fatal",
+		maxLogRequests:       -1,
+		maxLogRequestsPolicy: stern.MaxLogRequestsPolicyError,
+		maxLineSize:          1024 * 1024,
+		multilineTimeout:     time.Second,
+		configFilePath:       defaultConfigFilePath,
 	}
 }
 
@@ -111,8 +149,21 @@ func (o *options) Complete(args []string) {
 }
 
 func (o *options) Validate() error {
-	if len(o.containerQuery) == 0 && len(o.label) == 0 && len(o.image) == 0 && !o.stdin {
-		return errors.New("One of container-query, --label, --image, or --stdin is required")
+	if len(o.containerQuery) == 0 && len(o.label) == 0 && len(o.image) == 0 && len(o.service) == 0 &&
+		len(o.stack) == 0 && !o.stdin && len(o.files) == 0 {
+		return errors.New("One of container-query, --label, --image, --service, --stack, --stdin, or --file is required")
+	}
+
+	if o.allContexts && len(o.contexts) > 0 {
+		return errors.New("--context and --all-contexts cannot be used together")
+	}
+
+	if o.outputDir != "" && (o.stdin || len(o.files) > 0) {
+		return errors.New("--output-dir cannot be used with --stdin or --file")
+	}
+
+	if len(o.sinks) > 0 && (o.stdin || len(o.files) > 0) {
+		return errors.New("--sink cannot be used with --stdin or --file")
 	}
 
 	return nil
@@ -128,15 +179,18 @@ func (o *options) Run(cmd *cobra.Command) error {
 		return err
 	}
 
-	ctx, cancel := context.WithCancel(context.Background())
-	defer cancel()
+	// Interrupting tailfin cancels the context so that the checkpoints are saved, and the sinks and output files are
+	// flushed, before exiting. A second interrupt kills tailfin.
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
 
-	_, err = o.dockerClient.Ping(ctx)
+	hosts, err := reachableDockerHosts(ctx, o.dockerHosts, o.ErrOut)
 	if err != nil {
 		return err
 	}
 
-	return stern.RunDocker(ctx, o.dockerClient, config)
+	return stern.RunDocker(ctx, hosts, config)
 }
 
 func (o *options) tailfinConfig() (*stern.DockerConfig, error) {
@@ -165,6 +219,46 @@ func (o *options) tailfinConfig() (*stern.DockerConfig, error) {
 		return nil, errors.Wrap(err, "failed to compile regular expression for image filter")
 	}
 
+	service, err := compileREs(o.service)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compile regular expression for service filter")
+	}
+
+	stack, err := compileREs(o.stack)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compile regular expression for stack filter")
+	}
+
+	var label []stern.LabelSelector
+	for _, l := range o.label {
+		selector, err := stern.ParseLabelSelector(l)
+		if err != nil {
+			return nil, errors.Wrap(err, "failed to parse label selector")
+		}
+		label = append(label, selector)
+	}
+
+	network, err := compileREs(o.network)
+	if err != nil {
+		return nil, errors.Wrap(err, "failed to compile regular expression for network filter")
+	}
+
+	for _, state := range o.containerStates {
+		if !slices.Contains(flagChoices["state"], state) {
+			return nil, fmt.Errorf("state should be one of %s", quoteChoices(flagChoices["state"]))
+		}
+	}
+	for _, health := range o.health {
+		if !slices.Contains(flagChoices["health"], health) {
+			return nil, fmt.Errorf("health should be one of %s", quoteChoices(flagChoices["health"]))
+		}
+	}
+	for _, publish := range o.publish {
+		if err := validatePublish(publish); err != nil {
+			return nil, err
+		}
+	}
+
 	include, err := compileREs(o.include)
 	if err != nil {
 		return nil, errors.Wrap(err, "failed to compile regular expression for inclusion filter")
@@ -175,6 +269,18 @@ func (o *options) tailfinConfig() (*stern.DockerConfig, error) {
 		return nil, errors.Wrap(err, "failed to compile regular expression for highlight filter")
 	}
 
+	var multilinePattern, multilineStart *regexp.Regexp
+	if o.multilinePattern != "" {
+		if multilinePattern, err = regexp.Compile(o.multilinePattern); err != nil {
+			return nil, errors.Wrap(err, "failed to compile regular expression for multiline pattern")
+		}
+	}
+	if o.multilineStart != "" {
+		if multilineStart, err = regexp.Compile(o.multilineStart); err != nil {
+			return nil, errors.Wrap(err, "failed to compile regular expression for multiline start")
+		}
+	}
+
 	switch o.color {
 	case "always":
 		color.NoColor = false
@@ -190,6 +296,11 @@ func (o *options) tailfinConfig() (*stern.DockerConfig, error) {
 		return nil, err
 	}
 
+	eventTemplate, err := o.generateEventTemplate()
+	if err != nil {
+		return nil, err
+	}
+
 	var timestampFormat string
 	switch o.timestamps {
 	case "default":
@@ -201,12 +312,89 @@ func (o *options) tailfinConfig() (*stern.DockerConfig, error) {
 		return nil, errors.New("timestamps should be one of 'default', or 'short'")
 	}
 
+	if !slices.Contains(flagChoices["input-format"], o.inputFormat) {
+		return nil, fmt.Errorf("input-format should be one of %s", quoteChoices(flagChoices["input-format"]))
+	}
+
+	if err := stern.ValidateStdinTimestampFormat(o.stdinTimestampFormat); err != nil {
+		return nil, err
+	}
+
+	var minLevel int
+	if o.level != "" {
+		if minLevel, err = stern.ParseLevel(o.level); err != nil {
+			return nil, fmt.Errorf("level should be one of %s", quoteChoices(stern.LevelNames))
+		}
+	}
+
+	var stream string
+	switch o.stream {
+	case "stdout":
+		stream = stern.StreamStdout
+	case "stderr":
+		stream = stern.StreamStderr
+	case "all":
+	default:
+		return nil, errors.New("stream should be one of 'all', 'stdout', or 'stderr'")
+	}
+
 	// --timezone
 	location, err := time.LoadLocation(o.timezone)
 	if err != nil {
 		return nil, err
 	}
 
+	now := time.Now()
+	var sinceTime, untilTime time.Time
+	if o.sinceTime != "" {
+		if sinceTime, err = parseTimeExpression(o.sinceTime, location, now); err != nil {
+			return nil, errors.Wrap(err, "failed to parse --since-time")
+		}
+	}
+	if o.until != 0 && o.untilTime != "" {
+		return nil, errors.New("--until and --until-time cannot be used together")
+	}
+	if o.untilTime != "" {
+		if untilTime, err = parseTimeExpression(o.untilTime, location, now); err != nil {
+			return nil, errors.Wrap(err, "failed to parse --until-time")
+		}
+	}
+	if !sinceTime.IsZero() && !untilTime.IsZero() && !sinceTime.Before(untilTime) {
+		return nil, errors.New("--since-time must be before --until-time")
+	}
+
+	if o.maxLineSize < 0 {
+		return nil, errors.New("max-line-size must not be negative")
+	}
+
+	var outputMaxSize int64
+	if o.outputMaxSize != "" {
+		if outputMaxSize, err = units.RAMInBytes(o.outputMaxSize); err != nil || outputMaxSize < 0 {
+			return nil, fmt.Errorf("output-max-size should be a size like 100m, got %q", o.outputMaxSize)
+		}
+	}
+	if o.outputMaxAge < 0 {
+		return nil, errors.New("output-max-age must not be negative")
+	}
+
+	var sinks []stern.SinkConfig
+	for _, spec := range o.sinks {
+		sink, err := stern.ParseSink(spec)
+		if err != nil {
+			return nil, err
+		}
+		sinks = append(sinks, sink)
+	}
+
+	maxLogRequestsPolicy := o.maxLogRequestsPolicy
+	switch maxLogRequestsPolicy {
+	case stern.MaxLogRequestsPolicyError, stern.MaxLogRequestsPolicyQueue, stern.MaxLogRequestsPolicyDropOldest:
+	case stern.MaxLogRequestsPolicyDropIdlest:
+		maxLogRequestsPolicy = stern.MaxLogRequestsPolicyDropOldest
+	default:
+		return nil, errors.New("max-log-requests-policy should be one of 'error', 'queue', 'drop-oldest', or 'drop-idlest'")
+	}
+
 	maxLogRequests := o.maxLogRequests
 	if maxLogRequests == -1 {
 		if o.noFollow {
@@ -217,24 +405,54 @@ func (o *options) tailfinConfig() (*stern.DockerConfig, error) {
 	}
 
 	return &stern.DockerConfig{
+		CheckpointFile:        o.checkpointFile,
 		ComposeProjectQuery:   compose,
 		ContainerQuery:        container,
+		EventTemplate:         eventTemplate,
 		Exclude:               exclude,
 		ExcludeContainerQuery: excludeContainer,
+		Files:                 o.files,
 		Follow:                !o.noFollow,
+		HealthQuery:           o.health,
 		Highlight:             highlight,
 		ImageQuery:            image,
 		Include:               include,
-		Label:                 o.label,
+		InputFormat:           o.inputFormat,
+		Label:                 label,
 		Location:              location,
+		MinLevel:              minLevel,
+		MaxLineSize:           o.maxLineSize,
 		MaxLogRequests:        maxLogRequests,
+		MaxLogRequestsPolicy:  maxLogRequestsPolicy,
+		MultilinePattern:      multilinePattern,
+		MultilineStart:        multilineStart,
+		MultilineTimeout:      o.multilineTimeout,
+		NetworkQuery:          network,
 		OnlyLogLines:          o.onlyLogLines,
+		OutputCompress:        o.outputCompress,
+		OutputDir:             o.outputDir,
+		OutputMaxAge:          o.outputMaxAge,
+		OutputMaxSize:         outputMaxSize,
+		OutputTee:             o.outputTee,
+		PublishQuery:          o.publish,
+		ServiceQuery:          service,
 		Since:                 o.since,
+		SinceTime:             sinceTime,
+		Sinks:                 sinks,
+		SortByTime:            o.sortByTime,
+		SortWindow:            o.sortWindow,
+		StackQuery:            stack,
+		StateQuery:            o.containerStates,
 		Stdin:                 o.stdin,
+		StdinTimestampFormat:  o.stdinTimestampFormat,
+		Stream:                stream,
 		TailLines:             o.tail,
 		Template:              template,
 		TimestampFormat:       timestampFormat,
 		Timestamps:            timestampFormat != "",
+		Until:                 o.until,
+		UntilTime:             untilTime,
+		VolumeQuery:           o.volume,
 
 		Out:    o.Out,
 		ErrOut: o.ErrOut,
@@ -320,20 +538,48 @@ func (o *options) overrideFlagSetDefaultFromConfig(fs *pflag.FlagSet) error {
 
 // AddFlags adds all the flags used by tailfin.
 func (o *options) AddFlags(fs *pflag.FlagSet) {
+	fs.StringVar(&o.checkpointFile, "checkpoint-file", o.checkpointFile, "Path to a file where the position of each tailed container is saved periodically. A restarted tailfin resumes the containers from their saved positions.")
+	fs.BoolVar(&o.allContexts, "all-contexts", o.allContexts, "Tail all Docker contexts at once, prefixing the output with the context. Contexts that are unreachable at startup are skipped with a warning.")
 	fs.StringVar(&o.color, "color", o.color, "Force set color output. 'auto':  colorize if tty attached, 'always': always colorize, 'never': never colorize.")
 	fs.StringVar(&o.completion, "completion", o.completion, "Output tailfin command-line completion code for the specified shell. Can be 'bash', 'zsh' or 'fish'.")
 	fs.StringArrayVar(&o.compose, "compose", o.compose, "Compose project name to match (regular expression)")
+	fs.BoolVar(&o.events, "events", o.events, "Print container lifecycle events inline with the logs: exits with their exit code and OOM kills, health status changes, and restarts.")
+	fs.StringVar(&o.eventTemplate, "event-template", o.eventTemplate, "Template to use for container events, leave empty to use --output flag.")
 	fs.StringArrayVarP(&o.exclude, "exclude", "e", o.exclude, "Log lines to exclude. (regular expression)")
 	fs.StringArrayVarP(&o.excludeContainer, "exclude-container", "E", o.excludeContainer, "Container name to exclude. (regular expression)")
+	fs.StringArrayVar(&o.files, "file", o.files, "Log file to read instead of tailing containers, e.g. a copied <id>-json.log. Files written by the json-file and local logging drivers are parsed, other files are read as plain text. Repeat or use a quoted glob pattern for multiple files. The files are followed like tail -F unless --no-follow is set. All Docker related flags are ignored when it is set, and --since only applies when given explicitly.")
 	fs.BoolVar(&o.noFollow, "no-follow", o.noFollow, "Exit when all logs have been shown.")
 	fs.StringArrayVarP(&o.image, "image", "m", o.image, "Images to match (regular expression)")
+	fs.StringVar(&o.inputFormat, "input-format", o.inputFormat, "Format of the --stdin input. 'text': plain lines, 'compose': output of docker compose logs, where the service name of the container prefix is matched by the container query and --exclude-container and shown like when tailing containers.")
 	fs.StringArrayVarP(&o.include, "include", "i", o.include, "Log lines to include. (regular expression)")
-	fs.StringVar(&o.context, "context", o.context, "Docker context to use")
+	fs.StringArrayVar(&o.contexts, "context", o.contexts, "Docker context to use. Repeat to tail multiple hosts at once, prefixing the output with the context.")
+	fs.StringArrayVar(&o.network, "network", o.network, "Network name to match (regular expression). Containers are added and removed as they connect to and disconnect from networks.")
+	fs.StringArrayVar(&o.health, "health", o.health, "Container health status to match. One of 'starting', 'healthy', 'unhealthy', or 'none' per flag instance. Containers are added and removed as their health changes.")
 	fs.StringArrayVarP(&o.highlight, "highlight", "H", o.highlight, "Log lines to highlight. (regular expression)")
-	fs.StringArrayVarP(&o.label, "label", "l", o.label, "Label query to filter on. One `key` or `key=value` per flag instance.")
+	fs.StringArrayVarP(&o.label, "label", "l", o.label, "Label selector to filter on. One `key`, `!key`, `key=value`, `key!=value`, `key=~regex`, `key!~regex`, `key in (a,b)`, or `key notin (a,b)` per flag instance.")
+	fs.StringVar(&o.level, "level", o.level, "Minimum level of the log lines to show. One of 'trace', 'debug', 'info', 'warn', 'error', or 'fatal'. The level is read from the level, lvl, or severity field of JSON and logfmt lines, numeric levels as bunyan levels. Lines without a level are always shown.")
+	fs.IntVar(&o.maxLineSize, "max-line-size", o.maxLineSize, "Maximum size in bytes of a log line, not counting its timestamp. Lines split into 16KB partial messages by the log driver are joined up to this size and truncated beyond it. 0 means no limit.")
 	fs.IntVar(&o.maxLogRequests, "max-log-requests", o.maxLogRequests, "Maximum number of concurrent logs to request. Defaults to 50, but 5 when specifying --no-follow")
-	fs.StringVarP(&o.output, "output", "o", o.output, "Specify predefined template. Currently support: [default, raw, json, extjson, ppextjson]")
+	fs.StringVar(&o.maxLogRequestsPolicy, "max-log-requests-policy", o.maxLogRequestsPolicy, "What to do with new containers when --max-log-requests is reached without --no-follow. 'error': exit with an error, 'queue': wait for a free slot, 'drop-oldest' (or its alias 'drop-idlest'): stop tailing the container idle the longest to make room for the new one.")
+	fs.StringVar(&o.multilinePattern, "multiline-pattern", o.multilinePattern, "Log lines matching the pattern continue the previous line, e.g. '^\\s' for indented stack traces. (regular expression)")
+	fs.StringVar(&o.multilineStart, "multiline-start", o.multilineStart, "Log lines not matching the pattern continue the previous line, e.g. '^\\d{4}-' for lines starting with a date. (regular expression)")
+	fs.DurationVar(&o.multilineTimeout, "multiline-timeout", o.multilineTimeout, "Time to wait for continuation lines before printing a multiline event.")
+	fs.StringVarP(&o.output, "output", "o", o.output, "Specify predefined template. Currently support: [default, raw, json, logfmt, extjson, ppextjson]")
+	fs.BoolVar(&o.outputCompress, "output-compress", o.outputCompress, "Compress the rotated files of --output-dir with gzip.")
+	fs.StringVar(&o.outputDir, "output-dir", o.outputDir, "Directory to write the output of each container to, in a file named by the compose project, service and container number like shop/web-1.log. Files of a previous run are appended to, and colors are left out. Nothing is printed to the terminal unless --output-tee is set.")
+	fs.DurationVar(&o.outputMaxAge, "output-max-age", o.outputMaxAge, "Rotate the files of --output-dir on the first line written after they have been open for this duration, e.g. 1h. 0 means no rotation by age.")
+	fs.StringVar(&o.outputMaxSize, "output-max-size", o.outputMaxSize, "Rotate the files of --output-dir before they exceed this size, like 100m or 1g. Rotated files are renamed with the time of the rotation, like shop/web-1-2024-05-01T10-00-00.000.log. Empty means no rotation by size.")
+	fs.BoolVar(&o.outputTee, "output-tee", o.outputTee, "Print the output to the terminal as well when using --output-dir.")
+	fs.StringArrayVar(&o.publish, "publish", o.publish, "Published port to match, like 8080 or 8080/tcp.")
+	fs.StringArrayVar(&o.service, "service", o.service, "Swarm service name to match (regular expression). Tails Swarm services instead of containers.")
+	fs.StringVar(&o.sinceTime, "since-time", o.sinceTime, "Return logs after a specific time like 2024-05-01T10:30:00Z, '2024-05-01 10:30', or 10:30 (today) in --timezone. Overrides --since.")
+	fs.StringArrayVar(&o.sinks, "sink", o.sinks, "Forward the log lines to a log store in addition to printing them, like loki=http://localhost:3100, syslog=udp|tcp|tls://localhost:514 (RFC 5424), or gelf=udp|tcp://localhost:12201. Lines are sent with the namespace, service, container, and stream, and syslog and GELF messages also with the container labels not set by Docker. The syslog severity and GELF level are taken from the level of JSON and logfmt lines. Failed sends are retried with backoff, and lines are dropped when the store cannot keep up.")
+	fs.BoolVar(&o.sortByTime, "sort-by-time", o.sortByTime, "Print the logs of all containers ordered by time. With --no-follow all logs are merged before printing, otherwise lines are reordered within --sort-window.")
+	fs.DurationVar(&o.sortWindow, "sort-window", o.sortWindow, "Time to hold back log lines for reordering when using --sort-by-time without --no-follow.")
+	fs.StringArrayVar(&o.stack, "stack", o.stack, "Swarm stack name to match (regular expression). Tails Swarm services instead of containers.")
 	fs.DurationVarP(&o.since, "since", "s", o.since, "Return logs newer than a relative duration like 5s, 2m, or 3h.")
+	fs.StringArrayVar(&o.containerStates, "state", o.containerStates, "Container state to match. One of 'created', 'running', 'paused', 'restarting', 'removing', 'exited', or 'dead' per flag instance.")
+	fs.StringVar(&o.stream, "stream", o.stream, "Output stream to show. One of 'all', 'stdout', or 'stderr'.")
 	fs.Int64Var(&o.tail, "tail", o.tail, "The number of lines from the end of the logs to show. Defaults to -1, showing all logs.")
 	fs.StringVar(&o.template, "template", o.template, "Template to use for log lines, leave empty to use --output flag.")
 	fs.StringVarP(&o.templateFile, "template-file", "T", o.templateFile, "Path to template to use for log lines, leave empty to use --output flag. It overrides --template option.")
@@ -341,12 +587,15 @@ func (o *options) AddFlags(fs *pflag.FlagSet) {
 	fs.StringVar(&o.timezone, "timezone", o.timezone, "Set timestamps to specific timezone.")
 	fs.BoolVar(&o.onlyLogLines, "only-log-lines", o.onlyLogLines, "Print only log lines")
 	fs.StringVar(&o.configFilePath, "config", o.configFilePath, "Path to the tailfin config file")
+	fs.DurationVar(&o.until, "until", o.until, "Return logs older than a relative duration like 5s, 2m, or 3h.")
+	fs.StringVar(&o.untilTime, "until-time", o.untilTime, "Return logs before a specific time, in the same formats as --since-time.")
+	fs.StringArrayVar(&o.volume, "volume", o.volume, "Volume name or mount point to match.")
 	fs.StringVar(&o.verbosity, "verbosity", o.verbosity, "Log level. One of panic, fatal, error, warning, info, debug, or trace")
 	fs.BoolVarP(&o.version, "version", "v", o.version, "Print the version and exit.")
 	fs.BoolVar(&o.stdin, "stdin", o.stdin, "Parse logs from stdin. All Docker related flags are ignored when it is set.")
+	fs.StringVar(&o.stdinTimestampFormat, "stdin-timestamp-format", o.stdinTimestampFormat, "Timestamps of the --stdin input, enabling --since, --until, --timestamps and --sort-by-time. 'rfc3339': a leading RFC3339 timestamp like from docker logs -t, 'auto': a leading timestamp in a common date time format, 'json:<field>': the field of JSON lines. Leading timestamps are removed from the lines, and --since only applies when given explicitly.")
 	fs.StringSliceVar(&o.containerColors, "container-colors", o.containerColors, "Specifies the colors used to highlight container names. Use the same format as --namespace-colors. Defaults to the values of --namespace-colors if omitted, and must match its length.")
 	fs.StringSliceVar(&o.namespaceColor, "namespace-colors", o.namespaceColor, "Specifies the colors used to highlight namespace (compose project). Provide colors as a comma-separated list using SGR (Select Graphic Rendition) sequences, e.g., \"91,92,93,94,95,96\".")
-	// TODO: --context for docker context? Seems to be a `docker` thing, not a dockerd thing.
 	// TODO  --prompt??
 
 	fs.Lookup("timestamps").NoOptDefVal = "default"
@@ -364,22 +613,61 @@ func (o *options) generateTemplate() (*template.Template, error) {
 	if t == "" {
 		switch o.output {
 		case "default":
-			t = "{{if .Namespace}}{{color .NamespaceColor .Namespace}} {{end}}{{color .ContainerColor .ServiceName}} {{.Message}}"
+			t = "{{if .Context}}{{color .ContextColor .Context}} {{end}}{{if .Namespace}}{{color .NamespaceColor .Namespace}} {{end}}{{color .ContainerColor .ServiceName}} {{if eq .Stream \"stderr\"}}{{colorRed \"!\"}} {{end}}{{.Message}}"
 		case "raw":
 			t = "{{.Message}}"
 		case "json":
 			t = "{{json .}}"
+		case "logfmt":
+			t = "{{logfmt .}}"
 		case "extjson":
 			t = "{\"namespace\": \"{{if .Namespace}}{{color .NamespaceColor .Namespace}}{{end}}\", \"service\": \"{{color .ContainerColor .ServiceName}}\", \"message\": {{extjson .Message}}}"
 		case "ppextjson":
 			t = "{\n  \"namespace\": \"{{if .Namespace}}{{color .NamespaceColor .Namespace}}{{end}}\",\n  \"service\": \"{{color .ContainerColor .ServiceName}}\",\n  \"message\": {{extjson .Message}}\n}"
 		default:
-			return nil, errors.New("output should be one of 'default', 'raw', 'json', 'extjson', and 'ppextjson'")
+			return nil, errors.New("output should be one of 'default', 'raw', 'json', 'logfmt', 'extjson', and 'ppextjson'")
+		}
+		t += "\n"
+	}
+
+	template, err := template.New("log").Funcs(templateFuncs()).Parse(t)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse template")
+	}
+	return template, err
+}
+
+// generateEventTemplate returns the template for container events, or nil when events are not printed
+func (o *options) generateEventTemplate() (*template.Template, error) {
+	if !o.events {
+		return nil, nil
+	}
+	t := o.eventTemplate
+	if t == "" {
+		switch o.output {
+		case "default":
+			t = "{{if .Context}}{{color .ContextColor .Context}} {{end}}{{if .Namespace}}{{color .NamespaceColor .Namespace}} {{end}}{{color .ContainerColor .ServiceName}} {{colorYellow \"*\"}} {{.Message}}"
+		case "raw":
+			t = "{{.ContainerName}} {{.Message}}"
+		case "json", "extjson", "ppextjson":
+			t = "{{json .}}"
+		case "logfmt":
+			t = "{{logfmt .}}"
+		default:
+			return nil, errors.New("output should be one of 'default', 'raw', 'json', 'logfmt', 'extjson', and 'ppextjson'")
 		}
 		t += "\n"
 	}
 
-	funs := map[string]interface{}{
+	template, err := template.New("event").Funcs(templateFuncs()).Parse(t)
+	if err != nil {
+		return nil, errors.Wrap(err, "unable to parse event template")
+	}
+	return template, err
+}
+
+func templateFuncs() map[string]interface{} {
+	return map[string]interface{}{
 		"json": func(in interface{}) (string, error) {
 			b, err := json.Marshal(in)
 			if err != nil {
@@ -387,6 +675,7 @@ func (o *options) generateTemplate() (*template.Template, error) {
 			}
 			return string(b), nil
 		},
+		"logfmt": formatLogfmt,
 		"tryParseJSON": func(text string) map[string]interface{} {
 			decoder := json.NewDecoder(strings.NewReader(text))
 			decoder.UseNumber()
@@ -403,6 +692,20 @@ func (o *options) generateTemplate() (*template.Template, error) {
 			}
 			return obj, nil
 		},
+		"tryParseLogfmt": func(text string) map[string]interface{} {
+			pairs, err := stern.ParseLogfmt(text)
+			if err != nil {
+				return nil
+			}
+			return logfmtObject(pairs)
+		},
+		"parseLogfmt": func(text string) (map[string]interface{}, error) {
+			pairs, err := stern.ParseLogfmt(text)
+			if err != nil {
+				return make(map[string]interface{}), err
+			}
+			return logfmtObject(pairs), nil
+		},
 		"extractJSONParts": func(text string, part ...string) (string, error) {
 			obj := make(map[string]interface{})
 			if err := json.Unmarshal([]byte(text), &obj); err != nil {
@@ -429,6 +732,9 @@ func (o *options) generateTemplate() (*template.Template, error) {
 			if json.Valid([]byte(in)) {
 				return strings.TrimSuffix(in, "\n"), nil
 			}
+			if pairs, err := stern.ParseLogfmt(in); err == nil {
+				return logfmtJSON(pairs)
+			}
 			b, err := json.Marshal(in)
 			if err != nil {
 				return "", err
@@ -540,11 +846,6 @@ func (o *options) generateTemplate() (*template.Template, error) {
 			return levelColor.SprintFunc()(lv)
 		},
 	}
-	template, err := template.New("log").Funcs(funs).Parse(t)
-	if err != nil {
-		return nil, errors.Wrap(err, "unable to parse template")
-	}
-	return template, err
 }
 
 func NewTailfinCmd(streams IOStreams) (*cobra.Command, error) {
@@ -581,9 +882,17 @@ func NewTailfinCmd(streams IOStreams) (*cobra.Command, error) {
 
 			cmd.SilenceUsage = true
 
-			var err error
-			if o.dockerClient, err = getDockerClient(o.context); err != nil {
-				return err
+			// The default --since would hide the logs of files copied off a host, or captured, more than two days ago
+			if (len(o.files) > 0 || o.stdin) && !cmd.Flags().Changed("since") {
+				o.since = 0
+			}
+
+			// Logs read from stdin or files need no Docker host
+			if !o.stdin && len(o.files) == 0 {
+				var err error
+				if o.dockerHosts, err = getDockerHosts(o.contexts, o.allContexts); err != nil {
+					return err
+				}
 			}
 
 			return o.Run(cmd)
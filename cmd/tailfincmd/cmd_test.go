@@ -3,6 +3,7 @@ package tailfincmd
 import (
 	"bytes"
 	"io"
+	"net/url"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -56,6 +57,68 @@ func TestTailfinCommand(t *testing.T) {
 	}
 }
 
+func TestTailfinCommandWithoutDocker(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "x-json.log")
+	data := `{"log":"from file\n","stream":"stdout","time":"2024-05-01T10:00:00Z"}` + "\n"
+	if err := os.WriteFile(logFile, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdinFile := filepath.Join(dir, "stdin")
+	if err := os.WriteFile(stdinFile, []byte("from stdin\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	stdin, err := os.Open(stdinFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdin.Close()
+	origStdin := os.Stdin
+	os.Stdin = stdin
+	defer func() {
+		os.Stdin = origStdin
+	}()
+
+	tests := []struct {
+		name string
+		args []string
+		out  string
+	}{
+		{
+			"Read stdin without a Docker host",
+			[]string{"--stdin", "--template", "{{.Message}}{{\"\\n\"}}"},
+			"from stdin",
+		},
+		{
+			"Read a log file without a Docker host",
+			[]string{"--file", logFile, "--no-follow", "--template", "{{.Message}}{{\"\\n\"}}"},
+			"from file",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var out bytes.Buffer
+			var errout bytes.Buffer
+			streams := IOStreams{Out: &out, ErrOut: &errout}
+			stern, err := NewTailfinCmd(streams)
+			if err != nil {
+				t.Fatal(err)
+			}
+			stern.SetArgs(append([]string{"--config", "testdata/config-empty.yaml"}, tt.args...))
+
+			if err := stern.Execute(); err != nil {
+				t.Fatalf("unexpected error: %v, stderr: %s", err, errout.String())
+			}
+
+			if !strings.Contains(out.String(), tt.out) {
+				t.Errorf("expected to contain %s, but actual %s", tt.out, out.String())
+			}
+		})
+	}
+}
+
 func TestOptionsComplete(t *testing.T) {
 	var out bytes.Buffer
 	var errout bytes.Buffer
@@ -112,7 +175,7 @@ func TestOptionsValidate(t *testing.T) {
 		{
 			"No required options",
 			NewOptions(streams),
-			"One of container-query, --label, --image, or --stdin is required",
+			"One of container-query, --label, --image, --service, --stack, --stdin, or --file is required",
 		},
 		{
 			"Specify container-query",
@@ -134,6 +197,38 @@ func TestOptionsValidate(t *testing.T) {
 			}(),
 			"",
 		},
+		{
+			"Specify file",
+			func() *options {
+				o := NewOptions(streams)
+				o.files = []string{"*-json.log"}
+
+				return o
+			}(),
+			"",
+		},
+		{
+			"Specify output-dir and file",
+			func() *options {
+				o := NewOptions(streams)
+				o.files = []string{"*-json.log"}
+				o.outputDir = "logs"
+
+				return o
+			}(),
+			"--output-dir cannot be used with --stdin or --file",
+		},
+		{
+			"Specify sink and stdin",
+			func() *options {
+				o := NewOptions(streams)
+				o.stdin = true
+				o.sinks = []string{"loki=http://localhost:3100"}
+
+				return o
+			}(),
+			"--sink cannot be used with --stdin or --file",
+		},
 		{
 			"Specify image",
 			func() *options {
@@ -154,6 +249,38 @@ func TestOptionsValidate(t *testing.T) {
 			}(),
 			"",
 		},
+		{
+			"Specify service",
+			func() *options {
+				o := NewOptions(streams)
+				o.service = []string{"web"}
+
+				return o
+			}(),
+			"",
+		},
+		{
+			"Specify stack",
+			func() *options {
+				o := NewOptions(streams)
+				o.stack = []string{"shop"}
+
+				return o
+			}(),
+			"",
+		},
+		{
+			"Specify context and all-contexts",
+			func() *options {
+				o := NewOptions(streams)
+				o.containerQuery = []string{"."}
+				o.contexts = []string{"staging-1"}
+				o.allContexts = true
+
+				return o
+			}(),
+			"--context and --all-contexts cannot be used together",
+		},
 	}
 
 	for _, tt := range tests {
@@ -234,7 +361,21 @@ func TestOptionsGenerateTemplate(t *testing.T) {
 				return o
 			}(),
 			"json message",
-			`{"message":"json message","container":"container1","service":"service1","namespace":"compose1","number":"0"}
+			`{"message":"json message","container":"container1","service":"service1","namespace":"compose1","number":"0","stream":"stdout"}
+`,
+			false,
+			true,
+		},
+		{
+			"output=logfmt",
+			func() *options {
+				o := NewOptions(streams)
+				o.output = "logfmt"
+
+				return o
+			}(),
+			"logfmt message",
+			`message="logfmt message" container=container1 service=service1 namespace=compose1 number=0 stream=stdout
 `,
 			false,
 			true,
@@ -249,6 +390,34 @@ func TestOptionsGenerateTemplate(t *testing.T) {
 			}(),
 			`{"msg":"extjson message"}`,
 			`{"namespace": "compose1", "service": "service1", "message": {"msg":"extjson message"}}
+`,
+			false,
+			true,
+		},
+		{
+			"output=extjson with logfmt message",
+			func() *options {
+				o := NewOptions(streams)
+				o.output = "extjson"
+
+				return o
+			}(),
+			`level=info msg="extjson message"`,
+			`{"namespace": "compose1", "service": "service1", "message": {"level":"info","msg":"extjson message"}}
+`,
+			false,
+			true,
+		},
+		{
+			"output=extjson with text message",
+			func() *options {
+				o := NewOptions(streams)
+				o.output = "extjson"
+
+				return o
+			}(),
+			`extjson message`,
+			`{"namespace": "compose1", "service": "service1", "message": "extjson message"}
 `,
 			false,
 			true,
@@ -349,6 +518,30 @@ func TestOptionsGenerateTemplate(t *testing.T) {
 			false,
 			true,
 		},
+		{
+			"template-parse-logfmt",
+			func() *options {
+				o := NewOptions(streams)
+				o.template = `{{with $msg := .Message | parseLogfmt}}[{{$msg.level}}] {{$msg.msg}}{{end}}`
+				return o
+			}(),
+			`level=warn msg="disk almost full"`,
+			`[warn] disk almost full`,
+			false,
+			false,
+		},
+		{
+			"template-try-parse-logfmt",
+			func() *options {
+				o := NewOptions(streams)
+				o.template = `{{with $msg := .Message | tryParseLogfmt}}[{{$msg.level}}] {{$msg.msg}}{{else}}{{.Message}}{{end}}`
+				return o
+			}(),
+			`disk almost full`,
+			`disk almost full`,
+			false,
+			false,
+		},
 		{
 			"template-to-timestamp-with-timezone",
 			func() *options {
@@ -387,6 +580,7 @@ func TestOptionsGenerateTemplate(t *testing.T) {
 				log.Namespace = "compose1"
 				log.ServiceName = "service1"
 				log.ContainerNumber = "0"
+				log.Stream = stern.StreamStdout
 				log.NamespaceColor = color.New(color.FgBlue)
 			}
 			tmpl, err := tt.o.generateTemplate()
@@ -414,6 +608,163 @@ func TestOptionsGenerateTemplate(t *testing.T) {
 	}
 }
 
+func TestOptionsGenerateTemplateStream(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	o := NewOptions(IOStreams{Out: io.Discard, ErrOut: io.Discard})
+	tmpl, err := o.generateTemplate()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		stream string
+		want   string
+	}{
+		{stern.StreamStdout, "container1 message\n"},
+		{stern.StreamStderr, "container1 ! message\n"},
+		{"", "container1 message\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.stream, func(t *testing.T) {
+			log := stern.Log{
+				Message:        "message",
+				ContainerName:  "container1",
+				ServiceName:    "container1",
+				Stream:         tt.stream,
+				ContainerColor: color.New(color.FgBlue),
+			}
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, log); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want, got := tt.want, buf.String(); want != got {
+				t.Errorf("want %q, but got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestOptionsGenerateTemplateContext(t *testing.T) {
+	t.Setenv("NO_COLOR", "1")
+	o := NewOptions(IOStreams{Out: io.Discard, ErrOut: io.Discard})
+	tmpl, err := o.generateTemplate()
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	tests := []struct {
+		context string
+		want    string
+	}{
+		{"", "shop backend message\n"},
+		{"staging-1", "staging-1 shop backend message\n"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.context, func(t *testing.T) {
+			log := stern.Log{
+				Message:        "message",
+				ContainerName:  "shop-backend-1",
+				ServiceName:    "backend",
+				Namespace:      "shop",
+				Context:        tt.context,
+				ContextColor:   color.New(color.FgGreen),
+				NamespaceColor: color.New(color.FgRed),
+				ContainerColor: color.New(color.FgBlue),
+			}
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, log); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if want, got := tt.want, buf.String(); want != got {
+				t.Errorf("want %q, but got %q", want, got)
+			}
+		})
+	}
+}
+
+func TestOptionsGenerateEventTemplate(t *testing.T) {
+	event := &stern.ContainerEvent{
+		Event:         stern.ContainerEventDie,
+		Message:       "exited with code 137, OOM killed",
+		Time:          time.Date(2023, 2, 13, 21, 20, 30, 0, time.UTC),
+		ContainerName: "shop-backend-1",
+		ServiceName:   "backend",
+		Namespace:     "shop",
+		ExitCode:      137,
+		OOMKilled:     true,
+	}
+
+	tests := []struct {
+		name     string
+		o        *options
+		expected string
+	}{
+		{
+			"disabled",
+			func() *options {
+				o := NewOptions(IOStreams{})
+				o.eventTemplate = "{{.Message}}"
+				return o
+			}(),
+			"",
+		},
+		{
+			"raw",
+			func() *options {
+				o := NewOptions(IOStreams{})
+				o.events = true
+				o.output = "raw"
+				return o
+			}(),
+			"shop-backend-1 exited with code 137, OOM killed\n",
+		},
+		{
+			"json",
+			func() *options {
+				o := NewOptions(IOStreams{})
+				o.events = true
+				o.output = "json"
+				return o
+			}(),
+			`{"event":"die","message":"exited with code 137, OOM killed","time":"2023-02-13T21:20:30Z","container":"shop-backend-1","service":"backend","namespace":"shop","number":"","exitCode":137,"oomKilled":true,"restartCount":0}` + "\n",
+		},
+		{
+			"event-template",
+			func() *options {
+				o := NewOptions(IOStreams{})
+				o.events = true
+				o.eventTemplate = "{{.ServiceName}} {{.ExitCode}}"
+				return o
+			}(),
+			"backend 137",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tmpl, err := tt.o.generateEventTemplate()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if tmpl == nil {
+				if tt.expected != "" {
+					t.Errorf("expected %q, but actual nil template", tt.expected)
+				}
+				return
+			}
+			var buf bytes.Buffer
+			if err := tmpl.Execute(&buf, event); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if buf.String() != tt.expected {
+				t.Errorf("expected %q, but actual %q", tt.expected, buf.String())
+			}
+		})
+	}
+}
+
 func TestOptionsTailfinConfig(t *testing.T) {
 	var out bytes.Buffer
 	var errout bytes.Buffer
@@ -429,7 +780,7 @@ func TestOptionsTailfinConfig(t *testing.T) {
 			Timestamps:            false,
 			TimestampFormat:       "",
 			Location:              local,
-			Label:                 []string(nil),
+			Label:                 []stern.LabelSelector(nil),
 			ContainerQuery:        []*regexp.Regexp(nil),
 			ExcludeContainerQuery: nil,
 			ComposeProjectQuery:   nil,
@@ -443,7 +794,12 @@ func TestOptionsTailfinConfig(t *testing.T) {
 			Follow:                true,
 			OnlyLogLines:          false,
 			MaxLogRequests:        50,
+			MaxLogRequestsPolicy:  stern.MaxLogRequestsPolicyError,
+			MaxLineSize:           1024 * 1024,
+			MultilineTimeout:      time.Second,
 			Stdin:                 false,
+			InputFormat:           stern.InputFormatText,
+			SortWindow:            time.Second,
 
 			Out:    streams.Out,
 			ErrOut: streams.ErrOut,
@@ -481,6 +837,34 @@ func TestOptionsTailfinConfig(t *testing.T) {
 				o.noFollow = true // Follow = false
 				o.maxLogRequests = 30
 				o.onlyLogLines = true
+				o.service = []string{"service1"}
+				o.stack = []string{"stack1"}
+				o.stream = "stderr"
+				o.maxLineSize = 100
+				o.multilinePattern = "^\\s"
+				o.multilineStart = "^\\d"
+				o.multilineTimeout = time.Minute
+				o.sortByTime = true
+				o.sortWindow = time.Minute
+				o.sinceTime = "2024-05-01T10:00:00Z"
+				o.untilTime = "2024-05-01T11:00:00Z"
+				o.maxLogRequestsPolicy = "queue"
+				o.checkpointFile = "/var/lib/tailfin/checkpoints.json"
+				o.containerStates = []string{"running", "paused"}
+				o.health = []string{"unhealthy"}
+				o.network = []string{"^backend$"}
+				o.volume = []string{"data"}
+				o.publish = []string{"8080", "53/udp"}
+				o.files = []string{"logs/*-json.log"}
+				o.inputFormat = "compose"
+				o.stdinTimestampFormat = "json:ts"
+				o.level = "warn"
+				o.outputDir = "/tmp/loadtest"
+				o.outputMaxSize = "100m"
+				o.outputMaxAge = time.Hour
+				o.outputCompress = true
+				o.outputTee = true
+				o.sinks = []string{"loki=http://localhost:3100", "syslog=tls://logs.example.com", "gelf=udp://graylog"}
 
 				return o
 			}(),
@@ -497,11 +881,43 @@ func TestOptionsTailfinConfig(t *testing.T) {
 				c.Include = []*regexp.Regexp{re("in1"), re("in2")}
 				c.Highlight = []*regexp.Regexp{re("hi1"), re("hi2")}
 				c.Since = 1 * time.Hour
-				c.Label = []string{"app"}
+				c.Label = []stern.LabelSelector{mustParseLabelSelector("app")}
 				c.TailLines = 10
 				c.Follow = false
 				c.OnlyLogLines = true
 				c.MaxLogRequests = 30
+				c.ServiceQuery = []*regexp.Regexp{re("service1")}
+				c.StackQuery = []*regexp.Regexp{re("stack1")}
+				c.Stream = stern.StreamStderr
+				c.MaxLineSize = 100
+				c.MultilinePattern = re("^\\s")
+				c.MultilineStart = re("^\\d")
+				c.MultilineTimeout = time.Minute
+				c.SortByTime = true
+				c.SortWindow = time.Minute
+				c.SinceTime = time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+				c.UntilTime = time.Date(2024, 5, 1, 11, 0, 0, 0, time.UTC)
+				c.MaxLogRequestsPolicy = stern.MaxLogRequestsPolicyQueue
+				c.CheckpointFile = "/var/lib/tailfin/checkpoints.json"
+				c.StateQuery = []string{"running", "paused"}
+				c.HealthQuery = []string{"unhealthy"}
+				c.NetworkQuery = []*regexp.Regexp{re("^backend$")}
+				c.VolumeQuery = []string{"data"}
+				c.PublishQuery = []string{"8080", "53/udp"}
+				c.Files = []string{"logs/*-json.log"}
+				c.InputFormat = stern.InputFormatCompose
+				c.StdinTimestampFormat = "json:ts"
+				c.MinLevel = stern.LevelWarn
+				c.OutputDir = "/tmp/loadtest"
+				c.OutputMaxSize = 100 * 1024 * 1024
+				c.OutputMaxAge = time.Hour
+				c.OutputCompress = true
+				c.OutputTee = true
+				c.Sinks = []stern.SinkConfig{
+					{Type: stern.SinkLoki, URL: &url.URL{Scheme: "http", Host: "localhost:3100"}},
+					{Type: stern.SinkSyslog, URL: &url.URL{Scheme: "tls", Host: "logs.example.com:6514"}},
+					{Type: stern.SinkGELF, URL: &url.URL{Scheme: "udp", Host: "graylog:12201"}},
+				}
 
 				return c
 			}(),
@@ -559,6 +975,50 @@ func TestOptionsTailfinConfig(t *testing.T) {
 			}(),
 			false,
 		},
+		{
+			"error output-max-size",
+			func() *options {
+				o := NewOptions(streams)
+				o.outputMaxSize = "lots"
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error output-max-age",
+			func() *options {
+				o := NewOptions(streams)
+				o.outputMaxAge = -time.Hour
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error sink",
+			func() *options {
+				o := NewOptions(streams)
+				o.sinks = []string{"loki"}
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error syslog sink",
+			func() *options {
+				o := NewOptions(streams)
+				o.sinks = []string{"syslog=http://localhost:514"}
+
+				return o
+			}(),
+			nil,
+			true,
+		},
 		{
 			"error container-query",
 			func() *options {
@@ -614,6 +1074,116 @@ func TestOptionsTailfinConfig(t *testing.T) {
 			nil,
 			true,
 		},
+		{
+			"error service",
+			func() *options {
+				o := NewOptions(streams)
+				o.service = []string{"[invalid"}
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error stack",
+			func() *options {
+				o := NewOptions(streams)
+				o.stack = []string{"[invalid"}
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error state",
+			func() *options {
+				o := NewOptions(streams)
+				o.containerStates = []string{"running", "stopped"}
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error health",
+			func() *options {
+				o := NewOptions(streams)
+				o.health = []string{"sick"}
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error network",
+			func() *options {
+				o := NewOptions(streams)
+				o.network = []string{"[invalid"}
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error publish port",
+			func() *options {
+				o := NewOptions(streams)
+				o.publish = []string{"http"}
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error publish protocol",
+			func() *options {
+				o := NewOptions(streams)
+				o.publish = []string{"8080/icmp"}
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error label",
+			func() *options {
+				o := NewOptions(streams)
+				o.label = []string{"team=~[invalid"}
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error multiline-pattern",
+			func() *options {
+				o := NewOptions(streams)
+				o.multilinePattern = "[invalid"
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error multiline-start",
+			func() *options {
+				o := NewOptions(streams)
+				o.multilineStart = "[invalid"
+
+				return o
+			}(),
+			nil,
+			true,
+		},
 		{
 			"error color",
 			func() *options {
@@ -647,6 +1217,152 @@ func TestOptionsTailfinConfig(t *testing.T) {
 			nil,
 			true,
 		},
+		{
+			"error stdin-timestamp-format",
+			func() *options {
+				o := NewOptions(streams)
+				o.stdinTimestampFormat = "json:"
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error level",
+			func() *options {
+				o := NewOptions(streams)
+				o.level = "verbose"
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error input-format",
+			func() *options {
+				o := NewOptions(streams)
+				o.inputFormat = "invalid"
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error stream",
+			func() *options {
+				o := NewOptions(streams)
+				o.stream = "invalid"
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error max-line-size",
+			func() *options {
+				o := NewOptions(streams)
+				o.maxLineSize = -1
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"until",
+			func() *options {
+				o := NewOptions(streams)
+				o.until = time.Hour
+
+				return o
+			}(),
+			func() *stern.DockerConfig {
+				c := defaultConfig()
+				c.Until = time.Hour
+
+				return c
+			}(),
+			false,
+		},
+		{
+			"error since-time",
+			func() *options {
+				o := NewOptions(streams)
+				o.sinceTime = "invalid"
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error until-time",
+			func() *options {
+				o := NewOptions(streams)
+				o.untilTime = "invalid"
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error until and until-time",
+			func() *options {
+				o := NewOptions(streams)
+				o.until = time.Hour
+				o.untilTime = "2024-05-01T11:00:00Z"
+				o.maxLogRequestsPolicy = "queue"
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"error since-time after until-time",
+			func() *options {
+				o := NewOptions(streams)
+				o.sinceTime = "2024-05-01T12:00:00Z"
+				o.untilTime = "2024-05-01T11:00:00Z"
+				o.maxLogRequestsPolicy = "queue"
+
+				return o
+			}(),
+			nil,
+			true,
+		},
+		{
+			"drop-idlest is an alias of drop-oldest",
+			func() *options {
+				o := NewOptions(streams)
+				o.maxLogRequestsPolicy = "drop-idlest"
+
+				return o
+			}(),
+			func() *stern.DockerConfig {
+				c := defaultConfig()
+				c.MaxLogRequestsPolicy = stern.MaxLogRequestsPolicyDropOldest
+
+				return c
+			}(),
+			false,
+		},
+		{
+			"error max-log-requests-policy",
+			func() *options {
+				o := NewOptions(streams)
+				o.maxLogRequestsPolicy = "invalid"
+
+				return o
+			}(),
+			nil,
+			true,
+		},
 		{
 			"error timestamps",
 			func() *options {
@@ -678,6 +1394,7 @@ func TestOptionsTailfinConfig(t *testing.T) {
 			// We skip the template as it is difficult to check
 			// and is tested in TestOptionsGenerateTemplate().
 			got.Template = nil
+			got.EventTemplate = nil
 
 			if !reflect.DeepEqual(tt.want, got) {
 				t.Errorf("want %+v, but got %+v", tt.want, got)
@@ -828,3 +1545,11 @@ func TestOptionsOverrideFlagSetDefaultFromConfigArray(t *testing.T) {
 	}
 
 }
+
+func mustParseLabelSelector(selector string) stern.LabelSelector {
+	s, err := stern.ParseLabelSelector(selector)
+	if err != nil {
+		panic(err)
+	}
+	return s
+}
@@ -1,14 +1,25 @@
 package tailfincmd
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+
 	"github.com/containerd/log"
 	cliconfig "github.com/docker/cli/cli/config"
 	ctxdocker "github.com/docker/cli/cli/context/docker"
 	ctxstore "github.com/docker/cli/cli/context/store"
 	dockerclient "github.com/docker/docker/client"
 	sdkcontext "github.com/docker/go-sdk/context"
+	"github.com/hogklint/tailfin/stern"
 )
 
+// pingTimeout bounds the startup check of the hosts, so that an unreachable TCP endpoint cannot hang tailfin
+var pingTimeout = 10 * time.Second
+
 func getContextStore() *ctxstore.ContextStore {
 	storeConfigLazy := ctxstore.NewConfig(
 		func() interface{} { return &ctxdocker.EndpointMeta{} },
@@ -56,26 +67,94 @@ func getClientOpts(context string) ([]dockerclient.Opt, error) {
 	return clientOpts, nil
 }
 
-func getDockerClient(flagContext string) (*dockerclient.Client, error) {
+// getDockerHosts creates a client for each of the Docker contexts, for all contexts, or for the current context
+func getDockerHosts(flagContexts []string, allContexts bool) ([]stern.DockerHost, error) {
+	contexts := flagContexts
+	if allContexts {
+		stored, err := sdkcontext.List()
+		if err != nil {
+			return nil, err
+		}
+		contexts = append([]string{sdkcontext.DefaultContextName}, stored...)
+	}
+	if len(contexts) == 0 {
+		contexts = []string{""}
+	}
+
+	var hosts []stern.DockerHost
+	seen := make(map[string]bool)
+	for _, context := range contexts {
+		if seen[context] {
+			continue
+		}
+		seen[context] = true
+		host, err := getDockerHost(context)
+		if err != nil {
+			return nil, fmt.Errorf("docker context %s: %w", context, err)
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+func getDockerHost(flagContext string) (stern.DockerHost, error) {
 	context := flagContext
 	if context == "" {
 		var err error
 		context, err = sdkcontext.Current()
 		if err != nil {
-			return nil, err
+			return stern.DockerHost{}, err
 		}
 	}
 	log.L.Infof("Using docker context %s", context)
 
 	clientOpts, err := getClientOpts(context)
 	if err != nil {
-		return nil, err
+		return stern.DockerHost{}, err
 	}
 
 	client, err := dockerclient.NewClientWithOpts(clientOpts...)
 	if err != nil {
-		return nil, err
+		return stern.DockerHost{}, err
+	}
+
+	return stern.DockerHost{Context: context, Client: client}, nil
+}
+
+// reachableDockerHosts pings the hosts and returns the ones that respond. When tailing multiple hosts, unreachable
+// hosts are skipped with a warning, and it is only an error if none of them respond. Logs read from stdin or files
+// have no hosts to ping.
+func reachableDockerHosts(ctx context.Context, hosts []stern.DockerHost, errOut io.Writer) ([]stern.DockerHost, error) {
+	if len(hosts) == 0 {
+		return hosts, nil
 	}
+	ctx, cancel := context.WithTimeout(ctx, pingTimeout)
+	defer cancel()
 
-	return client, nil
+	errs := make([]error, len(hosts))
+	var wg sync.WaitGroup
+	for i, host := range hosts {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, errs[i] = host.Client.Ping(ctx)
+		}()
+	}
+	wg.Wait()
+
+	if len(hosts) == 1 {
+		return hosts, errs[0]
+	}
+	var reachable []stern.DockerHost
+	for i, host := range hosts {
+		if errs[i] != nil {
+			fmt.Fprintf(errOut, "skipping docker context %s: %v\n", host.Context, errs[i])
+			continue
+		}
+		reachable = append(reachable, host)
+	}
+	if len(reachable) == 0 {
+		return nil, errors.New("none of the docker contexts are reachable")
+	}
+	return reachable, nil
 }
@@ -0,0 +1,101 @@
+package tailfincmd
+
+import (
+	"bytes"
+	"context"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	dockerclient "github.com/docker/docker/client"
+	"github.com/hogklint/tailfin/stern"
+)
+
+func TestReachableDockerHosts(t *testing.T) {
+	orig := pingTimeout
+	pingTimeout = time.Second
+	defer func() {
+		pingTimeout = orig
+	}()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Api-Version", "1.47")
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+	// Nothing listens on the address of the closed listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closed := "tcp://" + l.Addr().String()
+	l.Close()
+	// Nothing responds on the address of the listener that is never accepted from
+	hanging, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hanging.Close()
+
+	newHost := func(context, host string) stern.DockerHost {
+		client, err := dockerclient.NewClientWithOpts(dockerclient.WithHost(host))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return stern.DockerHost{Context: context, Client: client}
+	}
+	up := newHost("up", "tcp://"+strings.TrimPrefix(server.URL, "http://"))
+	down := newHost("down", closed)
+	stale := newHost("stale", "tcp://"+hanging.Addr().String())
+
+	tests := []struct {
+		name     string
+		hosts    []stern.DockerHost
+		expected []string
+		warnings []string
+		wantErr  bool
+	}{
+		{"no hosts", nil, nil, nil, false},
+		{"single host", []stern.DockerHost{up}, []string{"up"}, nil, false},
+		{"single unreachable host", []stern.DockerHost{down}, nil, nil, true},
+		{"unreachable hosts skipped", []stern.DockerHost{down, up, stale}, []string{"up"}, []string{"down", "stale"}, false},
+		{"no reachable host", []stern.DockerHost{down, stale}, nil, []string{"down", "stale"}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			errOut := new(bytes.Buffer)
+			hosts, err := reachableDockerHosts(context.Background(), tt.hosts, errOut)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("expected an error")
+				}
+			} else if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			var contexts []string
+			if err == nil {
+				for _, host := range hosts {
+					contexts = append(contexts, host.Context)
+				}
+			}
+			if !reflect.DeepEqual(tt.expected, contexts) {
+				t.Errorf("expected %v, but actual %v", tt.expected, contexts)
+			}
+			var warnings []string
+			for _, line := range strings.Split(strings.TrimSuffix(errOut.String(), "\n"), "\n") {
+				if context, ok := strings.CutPrefix(line, "skipping docker context "); ok {
+					warnings = append(warnings, strings.SplitN(context, ":", 2)[0])
+				}
+			}
+			if !reflect.DeepEqual(tt.warnings, warnings) {
+				t.Errorf("expected warnings for %v, but actual %q", tt.warnings, errOut)
+			}
+		})
+	}
+}
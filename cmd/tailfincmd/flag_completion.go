@@ -21,11 +21,16 @@ import (
 )
 
 var flagChoices = map[string][]string{
-	"color":      {"always", "never", "auto"},
-	"completion": {"bash", "zsh", "fish"},
-	//"container-state": {stern.RUNNING, stern.WAITING, stern.TERMINATED, stern.ALL_STATES},
-	"output":     {"default", "raw", "json", "extjson", "ppextjson"},
-	"timestamps": {"default", "short"},
+	"color":                   {"always", "never", "auto"},
+	"completion":              {"bash", "zsh", "fish"},
+	"health":                  {"starting", "healthy", "unhealthy", "none"},
+	"input-format":            {"text", "compose"},
+	"level":                   {"trace", "debug", "info", "warn", "error", "fatal"},
+	"max-log-requests-policy": {"error", "queue", "drop-oldest", "drop-idlest"},
+	"output":                  {"default", "raw", "json", "logfmt", "extjson", "ppextjson"},
+	"state":                   {"created", "running", "paused", "restarting", "removing", "exited", "dead"},
+	"stream":                  {"all", "stdout", "stderr"},
+	"timestamps":              {"default", "short"},
 }
 
 func runCompletion(shell string, cmd *cobra.Command, out io.Writer) error {
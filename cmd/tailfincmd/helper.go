@@ -15,6 +15,7 @@ package tailfincmd
 import (
 	"encoding/json"
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 	"time"
@@ -94,3 +95,60 @@ func parseUnixTimeNanoString(num string) (time.Time, bool) {
 	}
 	return time.Unix(sec, nsec), true
 }
+
+var dateTimeLayouts = []string{
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02 15:04",
+	"2006-01-02",
+}
+
+var timeOfDayLayouts = []string{
+	"15:04:05",
+	"15:04",
+}
+
+// parseTimeExpression parses an absolute time given as RFC3339, as a date with an optional wall-clock time, or as a
+// wall-clock time of today. Times without a zone are in the given location.
+func parseTimeExpression(s string, loc *time.Location, now time.Time) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339Nano, s); err == nil {
+		return t, nil
+	}
+	for _, layout := range dateTimeLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, nil
+		}
+	}
+	for _, layout := range timeOfDayLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			year, month, day := now.In(loc).Date()
+			return time.Date(year, month, day, t.Hour(), t.Minute(), t.Second(), 0, loc), nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q, expected RFC3339, 'YYYY-MM-DD [hh:mm[:ss]]', or 'hh:mm[:ss]'", s)
+}
+
+// quoteChoices formats the choices like 'a', 'b', or 'c'
+func quoteChoices(choices []string) string {
+	quoted := make([]string, len(choices))
+	for i, c := range choices {
+		quoted[i] = "'" + c + "'"
+	}
+	if len(quoted) < 2 {
+		return strings.Join(quoted, "")
+	}
+	return strings.Join(quoted[:len(quoted)-1], ", ") + ", or " + quoted[len(quoted)-1]
+}
+
+// validatePublish validates a published port given as port or port/protocol
+func validatePublish(publish string) error {
+	port, proto, found := strings.Cut(publish, "/")
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid port %q in --publish", publish)
+	}
+	if found && proto != "tcp" && proto != "udp" && proto != "sctp" {
+		return fmt.Errorf("invalid protocol %q in --publish, should be one of 'tcp', 'udp', or 'sctp'", publish)
+	}
+	return nil
+}
@@ -65,3 +65,43 @@ func TestToTimeE(t *testing.T) {
 		})
 	}
 }
+
+func TestParseTimeExpression(t *testing.T) {
+	tokyo, _ := time.LoadLocation("Asia/Tokyo")
+	now := time.Date(2024, 5, 1, 20, 0, 0, 0, time.UTC) // 2024-05-02 05:00 in Tokyo
+
+	tests := []struct {
+		arg       string
+		expected  time.Time
+		wantError bool
+	}{
+		{"2024-05-01T10:30:00Z", time.Date(2024, 5, 1, 10, 30, 0, 0, time.UTC), false},
+		{"2024-05-01T10:30:00.5+02:00", time.Date(2024, 5, 1, 8, 30, 0, 5e8, time.UTC), false},
+		{"2024-05-01T10:30:00", time.Date(2024, 5, 1, 10, 30, 0, 0, tokyo), false},
+		{"2024-05-01 10:30:05", time.Date(2024, 5, 1, 10, 30, 5, 0, tokyo), false},
+		{"2024-05-01 10:30", time.Date(2024, 5, 1, 10, 30, 0, 0, tokyo), false},
+		{"2024-05-01", time.Date(2024, 5, 1, 0, 0, 0, 0, tokyo), false},
+		{"10:30:05", time.Date(2024, 5, 2, 10, 30, 5, 0, tokyo), false},
+		{"10:30", time.Date(2024, 5, 2, 10, 30, 0, 0, tokyo), false},
+		{"10", time.Time{}, true},
+		{"yesterday", time.Time{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.arg, func(t *testing.T) {
+			actual, err := parseTimeExpression(tt.arg, tokyo, now)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("expected error, but got no error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !tt.expected.Equal(actual) {
+				t.Errorf("expected %v, but actual %v", tt.expected, actual)
+			}
+		})
+	}
+}
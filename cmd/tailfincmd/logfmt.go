@@ -0,0 +1,124 @@
+package tailfincmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/hogklint/tailfin/stern"
+)
+
+// logfmtObject returns the pairs as a map for templates. The last value of a repeated key wins.
+func logfmtObject(pairs []stern.LogfmtPair) map[string]interface{} {
+	obj := make(map[string]interface{}, len(pairs))
+	for _, p := range pairs {
+		obj[p.Key] = p.Value
+	}
+	return obj
+}
+
+// logfmtJSON returns the pairs as a JSON object with the keys in the order of the line
+func logfmtJSON(pairs []stern.LogfmtPair) (string, error) {
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, p := range pairs {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		key, err := json.Marshal(p.Key)
+		if err != nil {
+			return "", err
+		}
+		value, err := json.Marshal(p.Value)
+		if err != nil {
+			return "", err
+		}
+		b.Write(key)
+		b.WriteByte(':')
+		b.Write(value)
+	}
+	b.WriteByte('}')
+	return b.String(), nil
+}
+
+// formatLogfmt formats the object as logfmt with the keys of its JSON encoding, in the same order. Nested objects are
+// flattened into dotted keys like labels.team=web, and arrays are kept as JSON.
+func formatLogfmt(in interface{}) (string, error) {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return "", err
+	}
+	if !bytes.HasPrefix(data, []byte("{")) {
+		return "", fmt.Errorf("logfmt requires an object, got %s", data)
+	}
+	var b strings.Builder
+	if err := appendLogfmt(&b, "", data); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+func appendLogfmt(b *strings.Builder, key string, data json.RawMessage) error {
+	if !bytes.HasPrefix(data, []byte("{")) {
+		value := string(data)
+		if bytes.HasPrefix(data, []byte(`"`)) {
+			if err := json.Unmarshal(data, &value); err != nil {
+				return err
+			}
+		}
+		if b.Len() > 0 {
+			b.WriteByte(' ')
+		}
+		b.WriteString(logfmtKey(key))
+		b.WriteByte('=')
+		b.WriteString(logfmtValue(value))
+		return nil
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	if _, err := decoder.Token(); err != nil {
+		return err
+	}
+	for decoder.More() {
+		token, err := decoder.Token()
+		if err != nil {
+			return err
+		}
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err != nil {
+			return err
+		}
+		name := token.(string)
+		if key != "" {
+			name = key + "." + name
+		}
+		if err := appendLogfmt(b, name, value); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// logfmtKey replaces the characters that may not be part of a key by underscores
+func logfmtKey(key string) string {
+	return strings.Map(func(r rune) rune {
+		if r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError || !unicode.IsPrint(r) {
+			return '_'
+		}
+		return r
+	}, key)
+}
+
+// logfmtValue quotes the value if it contains spaces, quotes, equal signs, or characters that are not printable
+func logfmtValue(value string) string {
+	if strings.IndexFunc(value, func(r rune) bool {
+		return r <= ' ' || r == '=' || r == '"' || r == utf8.RuneError || !unicode.IsPrint(r)
+	}) < 0 {
+		return value
+	}
+	return strconv.Quote(value)
+}
@@ -0,0 +1,42 @@
+package tailfincmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/hogklint/tailfin/stern"
+)
+
+func TestFormatLogfmt(t *testing.T) {
+	log := stern.Log{
+		Message:       "GET /health 200\n",
+		ContainerName: "web-1",
+		ServiceName:   "web",
+		Namespace:     "shop",
+		Stream:        stern.StreamStdout,
+		Timestamp:     time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+		Labels:        map[string]string{"team": "web", "a b": `x="y"`},
+	}
+	expected := `message="GET /health 200\n" container=web-1 service=web namespace=shop number= stream=stdout ` +
+		`timestamp=2024-05-01T10:00:00Z labels.a_b="x=\"y\"" labels.team=web`
+
+	actual, err := formatLogfmt(log)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if actual != expected {
+		t.Errorf("expected %q, but actual %q", expected, actual)
+	}
+
+	pairs, err := stern.ParseLogfmt(actual)
+	if err != nil {
+		t.Fatalf("unexpected error parsing %q: %v", actual, err)
+	}
+	if obj := logfmtObject(pairs); obj["message"] != log.Message || obj["labels.a_b"] != `x="y"` {
+		t.Errorf("expected the formatted values to be parsed again, but actual %v", obj)
+	}
+
+	if _, err := formatLogfmt("text"); err == nil {
+		t.Errorf("expected error for a value that is not an object")
+	}
+}
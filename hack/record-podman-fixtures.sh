@@ -0,0 +1,49 @@
+#!/usr/bin/env bash
+
+# Records the responses of the Docker compatible API of Podman used by stern/podman_test.go. A podman-compose project
+# is started, its web container turned unhealthy and killed, and the list, inspect and event responses are written to
+# stern/testdata/podman. Requires podman, podman-compose, curl and jq.
+
+set -eo pipefail; [[ -n "$DEBUG" ]] && set -ux
+
+ROOT_DIR="$(cd "$(dirname $0)" && pwd)/.."
+OUT_DIR="$ROOT_DIR/stern/testdata/podman"
+SOCKET="${PODMAN_SOCKET:-$XDG_RUNTIME_DIR/podman/podman.sock}"
+PROJECT=shop
+
+api() {
+  local path="$1"; shift
+  curl -fsS --unix-socket "$SOCKET" -G "$@" "http://podman/v1.41$path"
+}
+
+wait_for_health() {
+  for _ in $(seq 30); do
+    [[ "$(podman inspect -f '{{.State.Health.Status}}' "$1")" == "$2" ]] && return
+    sleep 1
+  done
+  echo "Error: container $1 did not become $2" >&2
+  exit 1
+}
+
+cd "$ROOT_DIR/hack/podman"
+since="$(date +%s)"
+podman-compose -p "$PROJECT" up -d
+trap 'podman-compose -p "$PROJECT" down -v >/dev/null 2>&1 ||:' EXIT
+
+id="$(podman ps -q --no-trunc --filter "label=com.docker.compose.project=$PROJECT" \
+  --filter "label=com.docker.compose.service=web")"
+wait_for_health "$id" healthy
+
+api /containers/json --data-urlencode "filters={\"id\":[\"$id\"]}" | jq . >"$OUT_DIR/containers_list.json"
+api "/containers/$id/json" | jq . >"$OUT_DIR/container_inspect.json"
+
+podman exec "$id" rm /tmp/healthy
+wait_for_health "$id" unhealthy
+podman kill "$id"
+podman-compose -p "$PROJECT" down -v
+trap - EXIT
+until="$(date +%s)"
+
+api /events --data-urlencode "since=$since" --data-urlencode "until=$until" \
+  --data-urlencode 'filters={"type":["container"],"event":["start","health_status","died","remove"]}' |
+  jq -c --arg id "$id" 'select(.Actor.ID == $id)' >"$OUT_DIR/events.jsonl"
@@ -0,0 +1,125 @@
+package stern
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// checkpointInterval is how often the checkpoint file is written
+const checkpointInterval = 5 * time.Second
+
+// checkpoints keeps the position of every tailed container so that a restarted tailfin resumes where it stopped. The
+// positions are periodically written to a JSON file mapping container IDs to resume requests.
+type checkpoints struct {
+	path      string
+	positions map[string]ResumeRequest
+	dirty     bool
+	mu        sync.Mutex
+}
+
+// loadCheckpoints reads the checkpoint file. A missing file is not an error, it is created on the first save.
+func loadCheckpoints(path string) (*checkpoints, error) {
+	c := &checkpoints{
+		path:      path,
+		positions: make(map[string]ResumeRequest),
+	}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if len(data) == 0 {
+		return c, nil
+	}
+	if err := json.Unmarshal(data, &c.positions); err != nil {
+		return nil, fmt.Errorf("invalid checkpoint file %s: %w", path, err)
+	}
+	return c, nil
+}
+
+// resumeRequests returns the loaded positions
+func (c *checkpoints) resumeRequests() map[string]*ResumeRequest {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	requests := make(map[string]*ResumeRequest, len(c.positions))
+	for id, position := range c.positions {
+		requests[id] = &position
+	}
+	return requests
+}
+
+func (c *checkpoints) update(containerId, timestamp string, lines int) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.positions[containerId] = ResumeRequest{Timestamp: timestamp, LinesToSkip: lines}
+	c.dirty = true
+}
+
+func (c *checkpoints) forget(containerId string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.positions[containerId]; ok {
+		delete(c.positions, containerId)
+		c.dirty = true
+	}
+}
+
+// save writes the positions to the checkpoint file if they changed since the last save. The file is replaced
+// atomically so that a crash never leaves a partially written file behind.
+func (c *checkpoints) save() error {
+	c.mu.Lock()
+	if !c.dirty {
+		c.mu.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(c.positions)
+	c.dirty = false
+	c.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(c.path), filepath.Base(c.path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), c.path)
+}
+
+// run saves the checkpoints periodically until the context is done
+func (c *checkpoints) run(ctx context.Context, errOut io.Writer) {
+	ticker := time.NewTicker(checkpointInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := c.save(); err != nil {
+				fmt.Fprintf(errOut, "failed to save checkpoints: %v\n", err)
+			}
+		case <-ctx.Done():
+			return
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package stern
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestCheckpoints(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+
+	c, err := loadCheckpoints(path)
+	if err != nil {
+		t.Fatalf("unexpected error loading missing file: %v", err)
+	}
+	if err := c.save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("expected no file without changes, but actual %v", err)
+	}
+
+	c.update("id1", "2023-02-13T21:20:30Z", 1)
+	c.update("id1", "2023-02-13T21:20:31Z", 2)
+	c.update("id2", "2023-02-13T21:20:30Z", 1)
+	c.update("id3", "2023-02-13T21:20:30Z", 3)
+	c.forget("id2")
+	if err := c.save(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	loaded, err := loadCheckpoints(path)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	expected := map[string]*ResumeRequest{
+		"id1": {Timestamp: "2023-02-13T21:20:31Z", LinesToSkip: 2},
+		"id3": {Timestamp: "2023-02-13T21:20:30Z", LinesToSkip: 3},
+	}
+	if actual := loaded.resumeRequests(); !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, but actual %v", expected, actual)
+	}
+
+	entries, _ := os.ReadDir(filepath.Dir(path))
+	if len(entries) != 1 {
+		t.Errorf("expected only the checkpoint file, but actual %v", entries)
+	}
+}
+
+func TestLoadCheckpointsInvalid(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "checkpoints.json")
+	if err := os.WriteFile(path, []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadCheckpoints(path); err == nil {
+		t.Error("expected an error, but actual nil")
+	}
+}
@@ -0,0 +1,135 @@
+package stern
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"syscall"
+	"time"
+)
+
+const (
+	sinkDialTimeout  = 10 * time.Second
+	sinkWriteTimeout = 10 * time.Second
+)
+
+// sinkRootCAs are the CAs verifying the servers of tls:// sinks, nil for the CAs of the host
+var sinkRootCAs *x509.CertPool
+
+// connSink writes each log line to a UDP, TCP, or TLS connection as the packets returned by format. The connection is
+// dialed on the first line and redialed with exponential backoff when writing fails. After a line could not be sent
+// in sinkMaxRetries, lines are dropped for sinkMaxBackoff before a single attempt is made, until sending succeeds.
+// Errors that retrying cannot fix, like a packet too large for UDP or an untrusted certificate, are not retried.
+type connSink struct {
+	*sinkQueue
+	network  string
+	address  string
+	tls      *tls.Config // nil unless the connection uses TLS
+	format   func(vm Log) ([][]byte, error)
+	conn     net.Conn
+	failedAt time.Time // zero unless the last line could not be sent
+}
+
+// newConnSink returns a started sink writing to the host of u. The scheme of u is the network, udp, tcp, or tls.
+func newConnSink(name string, u *url.URL, format func(vm Log) ([][]byte, error), errOut io.Writer) *connSink {
+	s := &connSink{
+		sinkQueue: newSinkQueue(name, errOut),
+		network:   u.Scheme,
+		address:   u.Host,
+		format:    format,
+	}
+	if u.Scheme == "tls" {
+		s.network = "tcp"
+		s.tls = &tls.Config{ServerName: u.Hostname(), RootCAs: sinkRootCAs}
+	}
+	go s.run()
+	return s
+}
+
+func (s *connSink) run() {
+	defer close(s.done)
+	defer func() {
+		if s.conn != nil {
+			s.conn.Close()
+		}
+	}()
+
+	for vm := range s.entries {
+		packets, err := s.format(vm)
+		if err != nil {
+			s.dropped.Add(1)
+			fmt.Fprintf(s.errOut, "%s: dropping a line of %s: %v\n", s.name, vm.ContainerName, err)
+			continue
+		}
+		if len(packets) > 0 {
+			s.write(vm, packets)
+		}
+	}
+}
+
+// write writes the packets of a line, dropping the line if they cannot be written. A failure is reported once until
+// a line is written again.
+func (s *connSink) write(vm Log, packets [][]byte) {
+	if !s.failedAt.IsZero() && (s.isClosing() || time.Since(s.failedAt) < sinkMaxBackoff) {
+		s.dropped.Add(1)
+		return
+	}
+
+	var err error
+	if s.failedAt.IsZero() {
+		err = s.retry(func() (bool, error) { return s.writeConn(packets) })
+	} else {
+		_, err = s.writeConn(packets)
+	}
+	if err == nil {
+		s.failedAt = time.Time{}
+		return
+	}
+	s.dropped.Add(1)
+	if errors.Is(err, syscall.EMSGSIZE) {
+		// Only the line cannot be sent
+		fmt.Fprintf(s.errOut, "%s: dropping a line of %s: %v\n", s.name, vm.ContainerName, err)
+		return
+	}
+	if s.failedAt.IsZero() {
+		fmt.Fprintf(s.errOut, "%s: failed to send to %s: %v\n", s.name, s.address, err)
+	}
+	s.failedAt = time.Now()
+}
+
+// writeConn writes the packets, dialing if there is no connection, and returns whether a failed write is to be retried.
+// The connection is closed if writing fails.
+func (s *connSink) writeConn(packets [][]byte) (bool, error) {
+	if s.conn == nil {
+		conn, err := s.dial()
+		if err != nil {
+			var certErr *tls.CertificateVerificationError
+			return !errors.As(err, &certErr), err
+		}
+		s.conn = conn
+	}
+	for _, p := range packets {
+		s.conn.SetWriteDeadline(time.Now().Add(sinkWriteTimeout))
+		if _, err := s.conn.Write(p); err != nil {
+			if errors.Is(err, syscall.EMSGSIZE) {
+				return false, err
+			}
+			s.conn.Close()
+			s.conn = nil
+			return true, err
+		}
+	}
+	return false, nil
+}
+
+func (s *connSink) dial() (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: sinkDialTimeout}
+	if s.tls != nil {
+		return tls.DialWithDialer(dialer, s.network, s.address, s.tls)
+	}
+	return dialer.Dial(s.network, s.address)
+}
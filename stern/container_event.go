@@ -0,0 +1,149 @@
+package stern
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	dockerclient "github.com/docker/docker/client"
+	"github.com/fatih/color"
+)
+
+// Container event names
+const (
+	ContainerEventDie     = "die"
+	ContainerEventHealth  = "health_status"
+	ContainerEventRestart = "restart"
+)
+
+// ContainerEvent is the object which will be used together with the event template to print container lifecycle
+// events inline with the logs.
+type ContainerEvent struct {
+	// Event is one of die, health_status, or restart
+	Event string `json:"event"`
+	// Message is a human readable description of the event
+	Message string    `json:"message"`
+	Time    time.Time `json:"time"`
+
+	ContainerName string `json:"container"`
+
+	ServiceName     string `json:"service"`
+	Namespace       string `json:"namespace"`
+	ContainerNumber string `json:"number"`
+
+	// Context is the Docker context of the host when tailing multiple hosts
+	Context string `json:"context,omitempty"`
+
+	ExitCode  int  `json:"exitCode"`
+	OOMKilled bool `json:"oomKilled"`
+	// Health is the new status of a health_status event
+	Health string `json:"health,omitempty"`
+	// RestartCount is the number of restarts by the restart policy
+	RestartCount int `json:"restartCount"`
+
+	ContextColor   *color.Color `json:"-"`
+	NamespaceColor *color.Color `json:"-"`
+	ContainerColor *color.Color `json:"-"`
+}
+
+// newContainerEvent creates an event with the container from the event attributes, which contain the container name
+// and labels
+func newContainerEvent(event string, e events.Message) *ContainerEvent {
+	attrs := e.Actor.Attributes
+	name := attrs["name"]
+	ce := &ContainerEvent{
+		Event:         event,
+		Time:          time.Unix(0, e.TimeNano),
+		ContainerName: name,
+		ServiceName:   name,
+	}
+	ce.Namespace, _ = composeLabel(attrs, "project")
+	ce.ContainerNumber, _ = composeLabel(attrs, "container-number")
+	if s, ok := composeLabel(attrs, "service"); ok {
+		ce.ServiceName = s
+	}
+	ce.NamespaceColor, ce.ContainerColor = determineDockerColor(name, ce.Namespace)
+	return ce
+}
+
+// newDieEvent creates a die event. The state of the inspected container is preferred as the event attributes lack the
+// OOM killed flag and restart count. inspected is nil if the container could not be inspected.
+func newDieEvent(e events.Message, inspected *container.InspectResponse) *ContainerEvent {
+	ce := newContainerEvent(ContainerEventDie, e)
+	ce.ExitCode, _ = strconv.Atoi(e.Actor.Attributes["exitCode"])
+	if inspected != nil && inspected.ContainerJSONBase != nil && inspected.State != nil {
+		ce.ExitCode = inspected.State.ExitCode
+		ce.OOMKilled = inspected.State.OOMKilled
+		ce.RestartCount = inspected.RestartCount
+	}
+	ce.Message = fmt.Sprintf("exited with code %d", ce.ExitCode)
+	if ce.OOMKilled {
+		ce.Message += ", OOM killed"
+	}
+	return ce
+}
+
+// newHealthEvent creates a health_status event from an event with an action like "health_status: unhealthy"
+func newHealthEvent(e events.Message) *ContainerEvent {
+	ce := newContainerEvent(ContainerEventHealth, e)
+	ce.Health = strings.TrimSpace(strings.TrimPrefix(string(e.Action), string(events.ActionHealthStatus)+":"))
+	ce.Message = "health status " + ce.Health
+	return ce
+}
+
+// newRestartEvent creates a restart event for a container started by its restart policy
+func newRestartEvent(e events.Message, restartCount int) *ContainerEvent {
+	ce := newContainerEvent(ContainerEventRestart, e)
+	ce.RestartCount = restartCount
+	ce.Message = fmt.Sprintf("restarted (restart count %d)", restartCount)
+	return ce
+}
+
+// eventPrinter prints container events using the event template. A nil eventPrinter prints nothing.
+type eventPrinter struct {
+	tmpl    *template.Template
+	context string
+	sorter  *timeSorter
+	out     io.Writer
+	errOut  io.Writer
+}
+
+func (p *eventPrinter) print(e *ContainerEvent) {
+	if p == nil {
+		return
+	}
+	e.Context = p.context
+	e.ContextColor = determineContextColor(p.context)
+	var buf bytes.Buffer
+	if err := p.tmpl.Execute(&buf, e); err != nil {
+		fmt.Fprintf(p.errOut, "expanding event template failed: %s\n", err)
+		return
+	}
+	if p.sorter != nil {
+		p.sorter.add(e.Time, buf.String())
+		return
+	}
+	fmt.Fprint(p.out, buf.String())
+}
+
+// printDie inspects the dead container and prints the die event
+func (p *eventPrinter) printDie(ctx context.Context, client *dockerclient.Client, e events.Message) {
+	if p == nil {
+		return
+	}
+	var inspected *container.InspectResponse
+	if c, err := client.ContainerInspect(ctx, e.Actor.ID); err != nil {
+		log.L.WithField("id", e.Actor.ID).Error(err, ": failed to inspect container")
+	} else {
+		inspected = &c
+	}
+	p.print(newDieEvent(e, inspected))
+}
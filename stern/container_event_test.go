@@ -0,0 +1,83 @@
+package stern
+
+import (
+	"bytes"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+)
+
+func TestContainerEvents(t *testing.T) {
+	ts := time.Date(2023, 2, 13, 21, 20, 30, 0, time.UTC)
+	message := func(action events.Action, attrs map[string]string) events.Message {
+		return events.Message{
+			Action:   action,
+			Actor:    events.Actor{ID: "id1", Attributes: attrs},
+			TimeNano: ts.UnixNano(),
+		}
+	}
+	compose := map[string]string{
+		"name":                                "shop-backend-1",
+		"exitCode":                            "137",
+		"com.docker.compose.project":          "shop",
+		"com.docker.compose.service":          "backend",
+		"com.docker.compose.container-number": "1",
+	}
+	inspected := &container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			State:        &container.State{ExitCode: 137, OOMKilled: true},
+			RestartCount: 2,
+		},
+	}
+
+	tests := []struct {
+		name     string
+		event    *ContainerEvent
+		expected string
+	}{
+		{
+			"die from attributes",
+			newDieEvent(message(events.ActionDie, map[string]string{"name": "c1", "exitCode": "1"}), nil),
+			"die c1 c1   exited with code 1 1 false  0",
+		},
+		{
+			"die from inspect",
+			newDieEvent(message(events.ActionDie, compose), inspected),
+			"die shop-backend-1 backend shop 1 exited with code 137, OOM killed 137 true  2",
+		},
+		{
+			"health status",
+			newHealthEvent(message(events.ActionHealthStatusUnhealthy, map[string]string{"name": "c1"})),
+			"health_status c1 c1   health status unhealthy 0 false unhealthy 0",
+		},
+		{
+			"restart",
+			newRestartEvent(message(events.ActionStart, map[string]string{"name": "c1"}), 3),
+			"restart c1 c1   restarted (restart count 3) 0 false  3",
+		},
+	}
+
+	tmpl := template.Must(template.New("").Parse(
+		"{{.Event}} {{.ContainerName}} {{.ServiceName}} {{.Namespace}} {{.ContainerNumber}} {{.Message}} " +
+			"{{.ExitCode}} {{.OOMKilled}} {{.Health}} {{.RestartCount}}"))
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !tt.event.Time.Equal(ts) {
+				t.Errorf("expected time %v, but actual %v", ts, tt.event.Time)
+			}
+			out := new(bytes.Buffer)
+			p := &eventPrinter{tmpl: tmpl, out: out, errOut: out}
+			p.print(tt.event)
+			if out.String() != tt.expected {
+				t.Errorf("expected %q, but actual %q", tt.expected, out.String())
+			}
+		})
+	}
+
+	// A nil printer prints nothing
+	var p *eventPrinter
+	p.print(newRestartEvent(message(events.ActionStart, compose), 1))
+}
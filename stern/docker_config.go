@@ -12,22 +12,57 @@ type DockerConfig struct {
 	Timestamps            bool
 	TimestampFormat       string
 	Location              *time.Location
-	Label                 []string
+	Label                 []LabelSelector
 	ContainerQuery        []*regexp.Regexp
 	ExcludeContainerQuery []*regexp.Regexp
 	ComposeProjectQuery   []*regexp.Regexp
 	Exclude               []*regexp.Regexp
 	ImageQuery            []*regexp.Regexp
+	ServiceQuery          []*regexp.Regexp
+	StackQuery            []*regexp.Regexp
+	StateQuery            []string
+	HealthQuery           []string
+	NetworkQuery          []*regexp.Regexp
+	VolumeQuery           []string
+	PublishQuery          []string
 	Include               []*regexp.Regexp
 	Highlight             []*regexp.Regexp
+	MinLevel              int // 0 for all levels
 	Since                 time.Duration
+	SinceTime             time.Time // overrides Since if set
+	Until                 time.Duration
+	UntilTime             time.Time // overrides Until if set
+	Stream                string
 	TailLines             int64
 	Template              *template.Template
+	EventTemplate         *template.Template // nil disables container events
 	Follow                bool
 	OnlyLogLines          bool
 	MaxLogRequests        int
+	MaxLogRequestsPolicy  string
+	MaxLineSize           int
+	MultilinePattern      *regexp.Regexp
+	MultilineStart        *regexp.Regexp
+	MultilineTimeout      time.Duration
 	Stdin                 bool
+	InputFormat           string   // format of the stdin input
+	StdinTimestampFormat  string   // format of the timestamps of the stdin input, empty when it has none
+	Files                 []string // log files or glob patterns, read instead of tailing containers
+	SortByTime            bool
+	SortWindow            time.Duration
+	CheckpointFile        string
+	OutputDir             string        // directory of the files with the output of each container, empty to disable
+	OutputMaxSize         int64         // size in bytes at which output files are rotated, 0 for no limit
+	OutputMaxAge          time.Duration // age at which output files are rotated, 0 for no limit
+	OutputCompress        bool          // compress rotated output files
+	OutputTee             bool          // also write to Out when OutputDir is set
+	Sinks                 []SinkConfig  // sinks the log lines are forwarded to
 
 	Out    io.Writer
 	ErrOut io.Writer
 }
+
+// Swarm returns true when Swarm services are tailed instead of containers
+func (c *DockerConfig) Swarm() bool {
+	return len(c.ServiceQuery) > 0 || len(c.StackQuery) > 0
+}
@@ -12,9 +12,7 @@ import (
 
 func ContainerGenerator(ctx context.Context, config *DockerConfig, client *dockerclient.Client) (iter.Seq[container.InspectResponse], error) {
 	args := filters.NewArgs()
-	for _, label := range config.Label {
-		args.Add("label", label)
-	}
+	addLabelFilters(args, config.Label)
 	opts := container.ListOptions{All: true, Filters: args}
 	containers, err := client.ContainerList(ctx, opts)
 	if err != nil {
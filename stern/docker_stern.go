@@ -3,9 +3,11 @@ package stern
 import (
 	"context"
 	"fmt"
+	"io"
+	"iter"
 	"os"
 	"strconv"
-	"sync/atomic"
+	"sync"
 	"time"
 
 	dockerclient "github.com/docker/docker/client"
@@ -13,23 +15,67 @@ import (
 	"golang.org/x/time/rate"
 )
 
-func RunDocker(ctx context.Context, client *dockerclient.Client, config *DockerConfig) error {
+// DockerHost is a dockerd endpoint named by its Docker context
+type DockerHost struct {
+	Context string
+	Client  *dockerclient.Client
+}
+
+// dockerHost is the state of a tailed host
+type dockerHost struct {
+	client *dockerclient.Client
+	filter *dockerTargetFilter
+}
+
+func RunDocker(ctx context.Context, hosts []DockerHost, config *DockerConfig) error {
+	untilTime := config.UntilTime
+	if untilTime.IsZero() && config.Until > 0 {
+		untilTime = time.Now().Add(-config.Until)
+	}
 	newTailOptions := func() *TailOptions {
+		sinceTime := config.SinceTime
+		if sinceTime.IsZero() {
+			sinceTime = time.Now().Add(-config.Since)
+		}
 		return &TailOptions{
-			Timestamps:      config.Timestamps,
-			TimestampFormat: config.TimestampFormat,
-			Location:        config.Location,
-			DockerSinceTime: time.Now().Add(-config.Since).Format(time.RFC3339),
-			Exclude:         config.Exclude,
-			Include:         config.Include,
-			Highlight:       config.Highlight,
-			DockerTailLines: strconv.FormatInt(config.TailLines, 10),
-			Follow:          config.Follow,
-			OnlyLogLines:    config.OnlyLogLines,
-		}
-	}
-	newTail := func(target *DockerTarget) *DockerTail {
-		return NewDockerTail(
+			Timestamps:       config.Timestamps,
+			TimestampFormat:  config.TimestampFormat,
+			Location:         config.Location,
+			DockerSinceTime:  sinceTime.Format(time.RFC3339),
+			UntilTime:        untilTime,
+			Exclude:          config.Exclude,
+			Include:          config.Include,
+			Highlight:        config.Highlight,
+			MinLevel:         config.MinLevel,
+			DockerTailLines:  strconv.FormatInt(config.TailLines, 10),
+			Stream:           config.Stream,
+			MaxLineSize:      config.MaxLineSize,
+			MultilinePattern: config.MultilinePattern,
+			MultilineStart:   config.MultilineStart,
+			MultilineTimeout: config.MultilineTimeout,
+			Follow:           config.Follow,
+			OnlyLogLines:     config.OnlyLogLines,
+		}
+	}
+	var sorter *timeSorter
+	if config.SortByTime {
+		window := config.SortWindow
+		if !config.Follow {
+			// Merge the complete streams when all tails are done
+			window = 0
+		}
+		sorter = newTimeSorter(config.Out, window)
+		defer sorter.flush()
+		// Started before reading stdin or files, which are followed too
+		sorterCtx, stopSorter := context.WithCancel(ctx)
+		defer stopSorter()
+		go sorter.run(sorterCtx)
+	}
+	var checkpoint *checkpoints
+	var outputs *outputDir
+	var logSinks sinks
+	newTail := func(client *dockerclient.Client, target *DockerTarget) *DockerTail {
+		tail := NewDockerTail(
 			client,
 			ContainerConfig{
 				target.Id,
@@ -38,68 +84,246 @@ func RunDocker(ctx context.Context, client *dockerclient.Client, config *DockerC
 				target.ComposeProject,
 				target.ContainerNumber,
 				target.Tty,
+				target.Swarm,
+				target.Context,
+				target.Image,
+				target.Labels,
 			},
 			config.Template,
 			config.Out,
 			config.ErrOut,
 			newTailOptions(),
 		)
+		tail.sorter = sorter
+		tail.checkpoints = checkpoint
+		tail.sinks = logSinks
+		if outputs != nil {
+			tail.file = outputs.file(target)
+			if !config.OutputTee {
+				tail.out = io.Discard
+				tail.sorter = nil
+			}
+		}
+		return tail
+	}
+
+	// Logs read from stdin or files are bounded by the since time instead of dockerd
+	newFileTailOptions := func() *TailOptions {
+		options := newTailOptions()
+		options.SinceTime = config.SinceTime
+		if options.SinceTime.IsZero() && config.Since > 0 {
+			options.SinceTime = time.Now().Add(-config.Since)
+		}
+		return options
 	}
 
 	if config.Stdin {
-		tail := NewFileTail(config.Template, os.Stdin, config.Out, config.ErrOut, newTailOptions())
+		tail := NewFileTail(config.Template, os.Stdin, config.Out, config.ErrOut, newFileTailOptions())
+		tail.inputFormat = config.InputFormat
+		tail.timestampFormat = config.StdinTimestampFormat
+		tail.sorter = sorter
+		tail.containerFilter = config.ContainerQuery
+		tail.containerExcludeFilter = config.ExcludeContainerQuery
 		return tail.Start()
 	}
 
-	filter := newDockerTargetFilter(
-		dockerTargetFilterConfig{
-			containerFilter:        config.ContainerQuery,
-			containerExcludeFilter: config.ExcludeContainerQuery,
-			composeProjectFilter:   config.ComposeProjectQuery,
-			imageFilter:            config.ImageQuery,
-		},
-		max(config.MaxLogRequests*2, 100),
-	)
+	if len(config.Files) > 0 {
+		paths, err := globLogFiles(config.Files)
+		if err != nil {
+			return err
+		}
+		tailFiles := func(ctx context.Context, paths []string) error {
+			for _, path := range paths {
+				tail := NewLogFileTail(path, config.Template, config.Out, config.ErrOut, newFileTailOptions())
+				tail.sorter = sorter
+				if err := tail.Start(ctx); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+		if !config.Follow {
+			return tailFiles(ctx, paths)
+		}
+		// Each file is followed concurrently after reading the files rotated from it
+		eg, ctx := errgroup.WithContext(ctx)
+		for _, rotations := range groupLogFiles(paths) {
+			eg.Go(func() error {
+				return tailFiles(ctx, rotations)
+			})
+		}
+		return eg.Wait()
+	}
+
+	if config.OutputDir != "" {
+		var err error
+		outputs, err = newOutputDir(config)
+		if err != nil {
+			return err
+		}
+		defer outputs.close()
+	}
 
-	if !config.Follow {
-		containers, err := FilteredContainerGenerator(ctx, config, client, filter)
+	for _, sinkConfig := range config.Sinks {
+		s, err := newSink(sinkConfig, config.ErrOut)
+		if err != nil {
+			logSinks.close()
+			return err
+		}
+		logSinks = append(logSinks, s)
+	}
+	defer logSinks.close()
+
+	var resumeRequests map[string]*ResumeRequest
+	if config.CheckpointFile != "" {
+		var err error
+		checkpoint, err = loadCheckpoints(config.CheckpointFile)
 		if err != nil {
 			return err
 		}
+		resumeRequests = checkpoint.resumeRequests()
+		defer func() {
+			if err := checkpoint.save(); err != nil {
+				fmt.Fprintf(config.ErrOut, "failed to save checkpoints: %v\n", err)
+			}
+		}()
+		go checkpoint.run(ctx, config.ErrOut)
+	}
 
+	// The targets of each host are kept apart by their Docker context, which is only shown when tailing multiple hosts
+	tailed := make(map[string]*dockerHost, len(hosts))
+	for _, host := range hosts {
+		filter := newDockerTargetFilter(
+			dockerTargetFilterConfig{
+				containerFilter:        config.ContainerQuery,
+				containerExcludeFilter: config.ExcludeContainerQuery,
+				composeProjectFilter:   config.ComposeProjectQuery,
+				imageFilter:            config.ImageQuery,
+				serviceFilter:          config.ServiceQuery,
+				stackFilter:            config.StackQuery,
+				stateFilter:            config.StateQuery,
+				healthFilter:           config.HealthQuery,
+				networkFilter:          config.NetworkQuery,
+				volumeFilter:           config.VolumeQuery,
+				publishFilter:          config.PublishQuery,
+				labelFilter:            config.Label,
+			},
+			max(config.MaxLogRequests*2, 100, len(resumeRequests)),
+		)
+		if len(hosts) > 1 {
+			filter.context = host.Context
+		}
+		// Containers tailed by a previous run are resumed from their checkpoints
+		for id, resumeRequest := range resumeRequests {
+			filter.setResumeRequest(id, resumeRequest)
+		}
+		filter.checkpoints = checkpoint
+		tailed[filter.context] = &dockerHost{client: host.Client, filter: filter}
+	}
+
+	if !config.Follow {
 		var eg errgroup.Group
 		eg.SetLimit(config.MaxLogRequests)
-		for target := range containers {
-			target := target
-			eg.Go(func() error {
-				tail := newTail(target)
-				defer tail.Close()
-				err := tail.Start(ctx)
-				if err != nil && filter.isActive(target) {
-					fmt.Fprintf(config.ErrOut, "failed to tail %s: %v\n", target.Name, err)
-					return err
-				}
-				return nil
-			})
+		for _, host := range tailed {
+			var containers iter.Seq[*DockerTarget]
+			var err error
+			if config.Swarm() {
+				containers, err = FilteredServiceGenerator(ctx, config, host.client, host.filter)
+			} else {
+				containers, err = FilteredContainerGenerator(ctx, config, host.client, host.filter)
+			}
+			if err != nil {
+				return err
+			}
+
+			for target := range containers {
+				target := target
+				eg.Go(func() error {
+					tail := newTail(host.client, target)
+					defer tail.Close()
+					var err error
+					if target.ResumeRequest == nil {
+						err = tail.Start(ctx)
+					} else {
+						err = tail.Resume(ctx, target.ResumeRequest)
+					}
+					if err != nil && host.filter.isActive(target) {
+						fmt.Fprintf(config.ErrOut, "failed to tail %s: %v\n", target.Name, err)
+						return err
+					}
+					return nil
+				})
+			}
 		}
 		return eg.Wait()
 	}
 
-	added, err := WatchDockers(ctx, config, filter, client)
-	if err != nil {
-		fmt.Fprintf(config.ErrOut, "failed to list containers: %v\n", err)
-		return err
+	// The tails are stopped and waited for before returning, so that their last lines are checkpointed, forwarded to the
+	// sinks and written to the output files before those are closed
+	var tails sync.WaitGroup
+	defer tails.Wait()
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	requests := newLogRequests(ctx, config.MaxLogRequests)
+
+	// Merge the targets of all hosts
+	added := make(chan *DockerTarget)
+	var wg sync.WaitGroup
+	for hostContext, host := range tailed {
+		host.filter.stopTail = func(containerId string) {
+			requests.stop(hostContext, containerId)
+		}
+
+		var hostAdded chan *DockerTarget
+		var err error
+		if config.Swarm() {
+			hostAdded, err = WatchServices(ctx, config, host.filter, host.client)
+		} else {
+			var printer *eventPrinter
+			if config.EventTemplate != nil {
+				printer = &eventPrinter{
+					tmpl:    config.EventTemplate,
+					context: hostContext,
+					sorter:  sorter,
+					out:     config.Out,
+					errOut:  config.ErrOut,
+				}
+			}
+			hostAdded, err = WatchDockers(ctx, config, host.filter, host.client, printer)
+		}
+		if err != nil {
+			fmt.Fprintf(config.ErrOut, "failed to list containers: %v\n", err)
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for target := range hostAdded {
+				added <- target
+			}
+		}()
 	}
+	go func() {
+		wg.Wait()
+		close(added)
+	}()
 
-	tailTarget := func(target *DockerTarget) {
+	tailTarget := func(ctx context.Context, target *DockerTarget, req *logRequest) {
+		host := tailed[target.Context]
+		host.filter.tailing(target.Id, true)
+		defer host.filter.tailing(target.Id, false)
 		limiter := rate.NewLimiter(rate.Every(time.Second*20), 2)
 		resumeRequest := target.ResumeRequest
 		for {
 			if err := limiter.Wait(ctx); err != nil {
-				fmt.Fprintf(config.ErrOut, "failed to retry: %v\n", err)
+				if ctx.Err() == nil {
+					fmt.Fprintf(config.ErrOut, "failed to retry: %v\n", err)
+				}
 				return
 			}
-			tail := newTail(target)
+			tail := newTail(host.client, target)
+			tail.activity = &req.activity
 			var err error
 			if resumeRequest == nil {
 				err = tail.Start(ctx)
@@ -109,11 +333,11 @@ func RunDocker(ctx context.Context, client *dockerclient.Client, config *DockerC
 			tail.Close()
 
 			if err == nil {
-				filter.setResumeRequest(target.Id, tail.GetResumeRequest())
+				host.filter.setResumeRequest(target.Id, tail.GetResumeRequest())
 				return
 			}
-			if !filter.isActive(target) {
-				filter.setResumeRequest(target.Id, tail.GetResumeRequest())
+			if !host.filter.isActive(target) {
+				host.filter.setResumeRequest(target.Id, tail.GetResumeRequest())
 				fmt.Fprintf(config.ErrOut, "failed to tail: %v\n", err)
 				return
 			}
@@ -124,18 +348,46 @@ func RunDocker(ctx context.Context, client *dockerclient.Client, config *DockerC
 		}
 	}
 
-	var numRequests atomic.Int64
+	var startTail func(req *logRequest)
+	startTail = func(req *logRequest) {
+		tails.Add(1)
+		go func() {
+			defer tails.Done()
+			tailTarget(req.ctx, req.target, req)
+			if next := requests.done(req); next != nil {
+				startTail(next)
+			}
+		}()
+	}
+
 	for target := range added {
-		numRequests.Add(1)
-		if numRequests.Load() > int64(config.MaxLogRequests) {
+		if req := requests.add(target); req != nil {
+			startTail(req)
+			continue
+		}
+		switch config.MaxLogRequestsPolicy {
+		case MaxLogRequestsPolicyQueue:
+			if req := requests.enqueue(target); req != nil {
+				startTail(req)
+				continue
+			}
+			fmt.Fprintf(config.ErrOut, "tailfin reached the maximum number of log requests (%d), queueing %s\n",
+				config.MaxLogRequests, target.Name)
+		case MaxLogRequestsPolicyDropOldest, MaxLogRequestsPolicyDropIdlest:
+			idlest := requests.displaceIdlest(target)
+			if idlest == nil {
+				// No tail to displace, e.g. with --max-log-requests 0
+				fmt.Fprintf(config.ErrOut, "tailfin reached the maximum number of log requests (%d), skipping %s\n",
+					config.MaxLogRequests, target.Name)
+				continue
+			}
+			fmt.Fprintf(config.ErrOut, "tailfin reached the maximum number of log requests (%d), stopped tailing %s\n",
+				config.MaxLogRequests, idlest.Name)
+		default:
 			return fmt.Errorf("tailfin reached the maximum number of log requests (%d),"+
 				" use --max-log-requests to increase the limit",
 				config.MaxLogRequests)
 		}
-		go func() {
-			tailTarget(target)
-			numRequests.Add(-1)
-		}()
 	}
 	return nil
 }
@@ -0,0 +1,515 @@
+package stern
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"text/template"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	dockerclient "github.com/docker/docker/client"
+)
+
+// fakeDockerd serves the part of the Docker API used to follow containers. The log stream of a container writes the
+// lines sent to its channel and ends when the channel is closed or the request is cancelled.
+type fakeDockerd struct {
+	containers map[string]container.InspectResponse
+	logs       map[string]chan string
+	events     chan events.Message
+	mu         sync.Mutex
+}
+
+func newFakeDockerd(t *testing.T) (*fakeDockerd, *dockerclient.Client) {
+	d := &fakeDockerd{
+		containers: make(map[string]container.InspectResponse),
+		logs:       make(map[string]chan string),
+		events:     make(chan events.Message),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1.47/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		var summaries []container.Summary
+		for id := range d.containers {
+			summaries = append(summaries, container.Summary{ID: id})
+		}
+		d.mu.Unlock()
+		json.NewEncoder(w).Encode(summaries)
+	})
+	mux.HandleFunc("GET /v1.47/containers/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		c, ok := d.containers[r.PathValue("id")]
+		d.mu.Unlock()
+		if !ok {
+			http.Error(w, `{"message":"no such container"}`, http.StatusNotFound)
+			return
+		}
+		json.NewEncoder(w).Encode(c)
+	})
+	mux.HandleFunc("GET /v1.47/containers/{id}/logs", func(w http.ResponseWriter, r *http.Request) {
+		d.mu.Lock()
+		lines := d.logs[r.PathValue("id")]
+		d.mu.Unlock()
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case line, ok := <-lines:
+				if !ok {
+					return
+				}
+				header := make([]byte, 8)
+				header[0] = 1
+				binary.BigEndian.PutUint32(header[4:], uint32(len(line)))
+				w.Write(append(header, line...))
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+	mux.HandleFunc("GET /v1.47/events", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+		w.(http.Flusher).Flush()
+		for {
+			select {
+			case e := <-d.events:
+				json.NewEncoder(w).Encode(e)
+				w.(http.Flusher).Flush()
+			case <-r.Context().Done():
+				return
+			}
+		}
+	})
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+
+	client, err := dockerclient.NewClientWithOpts(
+		dockerclient.WithHost("tcp://"+strings.TrimPrefix(server.URL, "http://")),
+		dockerclient.WithVersion("1.47"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { client.Close() })
+	return d, client
+}
+
+// addContainer adds a running container and returns the channel of its log lines
+func (d *fakeDockerd) addContainer(id, name string) chan string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.containers[id] = container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			ID:   id,
+			Name: "/" + name,
+			State: &container.State{
+				Status:    container.StateRunning,
+				Running:   true,
+				StartedAt: "2024-05-01T09:00:00Z",
+			},
+		},
+		Config: &container.Config{Image: "nginx"},
+	}
+	d.logs[id] = make(chan string, 10)
+	return d.logs[id]
+}
+
+// endLogs ends the log stream of the container and returns the channel of the lines of the next log stream
+func (d *fakeDockerd) endLogs(id string) chan string {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	close(d.logs[id])
+	d.logs[id] = make(chan string, 10)
+	return d.logs[id]
+}
+
+// setState changes the state of the container
+func (d *fakeDockerd) setState(id, status string) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	c := d.containers[id]
+	state := *c.State
+	state.Status = status
+	state.Running = status == container.StateRunning
+	c.ContainerJSONBase.State = &state
+	d.containers[id] = c
+}
+
+// sendEvent sends a container event to the event stream
+func (d *fakeDockerd) sendEvent(id string, action events.Action) {
+	d.events <- events.Message{
+		Type:     events.ContainerEventType,
+		Action:   action,
+		Actor:    events.Actor{ID: id},
+		TimeNano: time.Now().UnixNano(),
+	}
+}
+
+func TestRunDockerSortFollow(t *testing.T) {
+	orig := fileFollowInterval
+	fileFollowInterval = time.Millisecond
+	defer func() {
+		fileFollowInterval = orig
+	}()
+
+	tests := []struct {
+		name string
+		// start writes the line and returns a function ending the input
+		start func(t *testing.T, config *DockerConfig) func()
+	}{
+		{
+			"file",
+			func(t *testing.T, config *DockerConfig) func() {
+				path := filepath.Join(t.TempDir(), "web-json.log")
+				entry := `{"log":"line 1\n","stream":"stdout","time":"2024-05-01T10:00:00Z"}` + "\n"
+				if err := os.WriteFile(path, []byte(entry), 0o644); err != nil {
+					t.Fatal(err)
+				}
+				config.Files = []string{path}
+				return func() {}
+			},
+		},
+		{
+			"stdin",
+			func(t *testing.T, config *DockerConfig) func() {
+				r, w, err := os.Pipe()
+				if err != nil {
+					t.Fatal(err)
+				}
+				stdin := os.Stdin
+				os.Stdin = r
+				t.Cleanup(func() {
+					os.Stdin = stdin
+					r.Close()
+				})
+				if _, err := w.WriteString("2024-05-01T10:00:00Z line 1\n"); err != nil {
+					t.Fatal(err)
+				}
+				config.Stdin = true
+				config.StdinTimestampFormat = StdinTimestampFormatRFC3339
+				return func() { w.Close() }
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lines := make(lineWriter, 10)
+			config := &DockerConfig{
+				Template:   template.Must(template.New("").Parse("{{.Message}}\n")),
+				Follow:     true,
+				SortByTime: true,
+				SortWindow: 10 * time.Millisecond,
+				Out:        lines,
+				ErrOut:     io.Discard,
+			}
+			end := tt.start(t, config)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			done := make(chan error, 1)
+			go func() {
+				done <- RunDocker(ctx, nil, config)
+			}()
+
+			// The line is printed after the sort window while the input is still followed
+			select {
+			case line := <-lines:
+				if line != "line 1\n" {
+					t.Errorf("expected %q, but actual %q", "line 1\n", line)
+				}
+			case <-time.After(5 * time.Second):
+				t.Fatal("timed out waiting for the sorted line")
+			}
+
+			end()
+			cancel()
+			if err := <-done; err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+}
+
+// followUntilCancelled follows the containers of the client until the lines sent to logs are printed, then cancels
+// the run and waits for it to end
+func followUntilCancelled(t *testing.T, client *dockerclient.Client, config *DockerConfig, logs chan string, lines ...string) {
+	t.Helper()
+	printed := make(lineWriter, len(lines))
+	config.Template = template.Must(template.New("").Parse("{{.Message}}\n"))
+	config.Follow = true
+	config.MaxLogRequests = 10
+	config.TailLines = -1
+	config.Out = printed
+	config.ErrOut = io.Discard
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- RunDocker(ctx, []DockerHost{{Client: client}}, config)
+	}()
+
+	for _, line := range lines {
+		logs <- line
+	}
+	for range lines {
+		select {
+		case <-printed:
+		case <-time.After(5 * time.Second):
+			t.Fatal("timed out waiting for the lines")
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the run to end")
+	}
+}
+
+func TestRunDockerFollowSavesCheckpointsWhenCancelled(t *testing.T) {
+	dockerd, client := newFakeDockerd(t)
+	logs := dockerd.addContainer("c1", "web")
+	checkpointFile := filepath.Join(t.TempDir(), "checkpoints.json")
+
+	// The checkpoints are saved when the run is cancelled, long before the checkpoint interval
+	config := &DockerConfig{CheckpointFile: checkpointFile}
+	followUntilCancelled(t, client, config, logs,
+		"2024-05-01T10:00:00.000000000Z line 1\n",
+		"2024-05-01T10:00:00.500000000Z line 2\n",
+	)
+
+	checkpoint, err := loadCheckpoints(checkpointFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := ResumeRequest{Timestamp: "2024-05-01T10:00:00Z", LinesToSkip: 2}
+	if actual := checkpoint.positions["c1"]; actual != expected {
+		t.Errorf("expected %+v, but actual %+v", expected, actual)
+	}
+}
+
+func TestRunDockerFollowFlushesLokiWhenCancelled(t *testing.T) {
+	batchWait := lokiBatchWait
+	lokiBatchWait = time.Hour
+	defer func() {
+		lokiBatchWait = batchWait
+	}()
+	loki := &lokiStandIn{}
+	server := httptest.NewServer(loki)
+	defer server.Close()
+	lokiURL, err := url.Parse(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dockerd, client := newFakeDockerd(t)
+	logs := dockerd.addContainer("c1", "web")
+
+	// The pending batch is pushed when the run is cancelled, long before the batch wait
+	config := &DockerConfig{Sinks: []SinkConfig{{Type: SinkLoki, URL: lokiURL}}}
+	followUntilCancelled(t, client, config, logs, "2024-05-01T10:00:00.000000000Z line 1\n")
+
+	loki.mu.Lock()
+	defer loki.mu.Unlock()
+	if len(loki.pushes) != 1 || len(loki.pushes[0].Streams) != 1 {
+		t.Fatalf("expected a single pushed stream, but actual %+v", loki.pushes)
+	}
+	if values := loki.pushes[0].Streams[0].Values; len(values) != 1 || values[0][1] != "line 1" {
+		t.Errorf("expected the pushed line 1, but actual %v", values)
+	}
+}
+
+func TestRunDockerFollowClosesConnSinksWhenCancelled(t *testing.T) {
+	tests := []struct {
+		typ  string
+		read func(data []byte) []string
+	}{
+		{SinkSyslog, func(data []byte) []string {
+			messages := readOctetCounted(bytes.NewReader(data))
+			for i, msg := range messages {
+				messages[i] = msg[strings.LastIndex(msg, " ")+1:]
+			}
+			return messages
+		}},
+		{SinkGELF, func(data []byte) []string {
+			var messages []string
+			for _, msg := range bytes.Split(bytes.TrimSuffix(data, []byte{0}), []byte{0}) {
+				var gelf struct {
+					ShortMessage string `json:"short_message"`
+				}
+				json.Unmarshal(msg, &gelf)
+				messages = append(messages, gelf.ShortMessage)
+			}
+			return messages
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.typ, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer l.Close()
+
+			dockerd, client := newFakeDockerd(t)
+			logs := dockerd.addContainer("c1", "web")
+
+			config := &DockerConfig{Sinks: []SinkConfig{{Type: tt.typ, URL: &url.URL{Scheme: "tcp", Host: l.Addr().String()}}}}
+			followUntilCancelled(t, client, config, logs,
+				"2024-05-01T10:00:00.000000000Z line1\n",
+				"2024-05-01T10:00:01.000000000Z line2\n",
+			)
+
+			// The connection is closed by the end of the run, after the queued lines are sent
+			conn, err := l.Accept()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+			data, err := io.ReadAll(conn)
+			if err != nil {
+				t.Fatalf("expected the connection to be closed, but actual %v", err)
+			}
+			expected := []string{"line1", "line2"}
+			if actual := tt.read(data); !reflect.DeepEqual(expected, actual) {
+				t.Errorf("expected %q, but actual %q", expected, actual)
+			}
+		})
+	}
+}
+
+func TestRunDockerFollowClosesOutputDirWhenCancelled(t *testing.T) {
+	dir := t.TempDir()
+	dockerd, client := newFakeDockerd(t)
+	logs := dockerd.addContainer("c1", "web")
+
+	// The second line rotates the file, which is compressed before the run ends
+	config := &DockerConfig{OutputDir: dir, OutputMaxSize: 10, OutputCompress: true, OutputTee: true}
+	followUntilCancelled(t, client, config, logs,
+		"2024-05-01T10:00:00.000000000Z line 1\n",
+		"2024-05-01T10:00:01.000000000Z line 2\n",
+	)
+
+	rotated, _ := filepath.Glob(filepath.Join(dir, "web-*"))
+	if len(rotated) != 1 || !strings.HasSuffix(rotated[0], ".log.gz") {
+		t.Fatalf("expected a single compressed rotated file, but actual %v", rotated)
+	}
+	data, err := os.ReadFile(filepath.Join(dir, "web.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "line 2\n" {
+		t.Errorf("expected %q, but actual %q", "line 2\n", data)
+	}
+}
+
+func TestRunDockerFollowResumesEndedStreamOfRunningContainer(t *testing.T) {
+	dockerd, client := newFakeDockerd(t)
+	logs := dockerd.addContainer("c1", "web")
+
+	lines := make(lineWriter, 10)
+	config := &DockerConfig{
+		Template:       template.Must(template.New("").Parse("{{.Message}}\n")),
+		Follow:         true,
+		MaxLogRequests: 10,
+		TailLines:      -1,
+		Out:            lines,
+		ErrOut:         io.Discard,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- RunDocker(ctx, []DockerHost{{Client: client}}, config)
+	}()
+
+	waitForLine := func(expected string) {
+		t.Helper()
+		select {
+		case line := <-lines:
+			if line != expected {
+				t.Errorf("expected %q, but actual %q", expected, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", expected)
+		}
+	}
+	logs <- "2024-05-01T10:00:00.000000000Z line 1\n"
+	waitForLine("line 1\n")
+
+	// The stream ends cleanly, like when dockerd restarts with live-restore, while the container is still running
+	logs = dockerd.endLogs("c1")
+	logs <- "2024-05-01T10:00:00.000000000Z line 1\n"
+	logs <- "2024-05-01T10:00:01.000000000Z line 2\n"
+	waitForLine("line 2\n")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestRunDockerFollowDropOldestWithoutTails(t *testing.T) {
+	dockerd, client := newFakeDockerd(t)
+	dockerd.addContainer("c1", "web")
+
+	errOut := make(lineWriter, 10)
+	config := &DockerConfig{
+		Template:             template.Must(template.New("").Parse("{{.Message}}\n")),
+		Follow:               true,
+		MaxLogRequests:       0,
+		MaxLogRequestsPolicy: MaxLogRequestsPolicyDropOldest,
+		TailLines:            -1,
+		Out:                  io.Discard,
+		ErrOut:               errOut,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	done := make(chan error, 1)
+	go func() {
+		done <- RunDocker(ctx, []DockerHost{{Client: client}}, config)
+	}()
+
+	// Without a tail to displace the container is skipped instead of exceeding the maximum
+	select {
+	case line := <-errOut:
+		expected := "tailfin reached the maximum number of log requests (0), skipping web\n"
+		if line != expected {
+			t.Errorf("expected %q, but actual %q", expected, line)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the warning")
+	}
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case line := <-errOut:
+		t.Errorf("expected no tail, but actual %q", line)
+	default:
+	}
+}
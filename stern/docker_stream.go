@@ -0,0 +1,245 @@
+package stern
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+const (
+	stdinStreamType     byte = 0
+	stdoutStreamType    byte = 1
+	stderrStreamType    byte = 2
+	systemErrStreamType byte = 3
+
+	streamHeaderLen = 8
+
+	// frameSizeSlack allows for the timestamp and details prefixed to frames
+	frameSizeSlack = 64 * 1024
+)
+
+type streamLine struct {
+	stream string
+	text   string
+}
+
+// logStreamReader reads the lines of a Docker log stream.
+//
+// Container stream format: https://docs.docker.com/reference/api/engine/version/v1.47/#tag/Container/operation/ContainerAttach
+// When TTY is not enabled the stream is multiplexed into frames, each prefixed with an 8 byte header holding the stream
+// type (stdin/stdout/stderr) and the payload size. A frame may contain several lines and a line may span several frames
+// so the payloads are buffered per stream until a full line is available. With TTY stdout and stderr are merged into a
+// raw stream which is reported as stdout.
+//
+// The json-file and local log drivers split lines longer than 16KB into partial messages. The daemon writes each
+// message in its own frame where only the last one ends with a newline, and when timestamps (and details) are requested
+// every partial message is prefixed with them. Such prefixes are stripped from continuation frames so that the partial
+// messages are joined into one line with the timestamp of the first one. TTY streams carry no frame boundaries so
+// partial messages cannot be detected there.
+//
+// Some Podman versions multiplex the stream of TTY containers as well, so a TTY stream starting with a frame header is
+// read as a multiplexed stream.
+type logStreamReader struct {
+	r           *bufio.Reader
+	tty         bool
+	sniffed     bool // whether the start of a TTY stream has been checked for a frame header
+	timestamps  bool
+	details     bool
+	maxLineSize int
+	header      [streamHeaderLen]byte
+	buffers     map[byte][]byte
+	lines       []streamLine
+	err         error
+}
+
+// newLogStreamReader returns a reader of the log stream r. Lines longer than maxLineSize bytes are truncated unless
+// maxLineSize is 0.
+func newLogStreamReader(r io.Reader, tty, timestamps, details bool, maxLineSize int) *logStreamReader {
+	return &logStreamReader{
+		r:           bufio.NewReader(r),
+		tty:         tty,
+		timestamps:  timestamps,
+		details:     details,
+		maxLineSize: maxLineSize,
+		buffers:     make(map[byte][]byte),
+	}
+}
+
+// ReadLine returns the stream name and the next line without the line ending. Any incomplete lines are returned when
+// the underlying reader is exhausted, after which the error is returned.
+func (r *logStreamReader) ReadLine() (string, string, error) {
+	for len(r.lines) == 0 {
+		if r.err != nil {
+			return "", "", r.err
+		}
+		r.err = r.readFrame()
+		if r.err != nil {
+			r.flush()
+		}
+	}
+	line := r.lines[0]
+	r.lines = r.lines[1:]
+	return line.stream, line.text, nil
+}
+
+func (r *logStreamReader) readFrame() error {
+	if r.tty && !r.sniffed {
+		r.sniffed = true
+		if header, err := r.r.Peek(streamHeaderLen); err == nil && isFrameHeader(header) {
+			r.tty = false
+		}
+	}
+	if r.tty {
+		payload, err := r.r.ReadBytes('\n')
+		r.append(stdoutStreamType, payload)
+		return err
+	}
+
+	if _, err := io.ReadFull(r.r, r.header[:]); err != nil {
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return io.EOF
+		}
+		return err
+	}
+
+	streamType := r.header[0]
+	if streamType > systemErrStreamType {
+		return fmt.Errorf("unrecognized stream type: %d", streamType)
+	}
+	if !isFrameHeader(r.header[:]) {
+		return fmt.Errorf("invalid stream frame header: %x", r.header)
+	}
+
+	payload, err := r.readPayload(int64(binary.BigEndian.Uint32(r.header[4:])))
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		err = io.EOF
+	}
+	if streamType == systemErrStreamType {
+		return fmt.Errorf("error from daemon in stream: %s", payload)
+	}
+	r.append(streamType, payload)
+	return err
+}
+
+// readPayload reads a frame payload of size bytes. The payload beyond the maximum line size and the room for its
+// prefixes is discarded, except for a final newline ending the line, so that an oversized frame is emitted truncated.
+func (r *logStreamReader) readPayload(size int64) ([]byte, error) {
+	keep := size
+	if r.maxLineSize > 0 {
+		keep = min(size, int64(r.maxLineSize+frameSizeSlack))
+	}
+	// The payload is read as it arrives rather than allocated up front as the size comes from the stream
+	payload, err := io.ReadAll(io.LimitReader(r.r, keep))
+	if err != nil {
+		return payload, err
+	}
+	if int64(len(payload)) < keep {
+		return payload, io.ErrUnexpectedEOF
+	}
+	if keep == size {
+		return payload, nil
+	}
+
+	if _, err := io.CopyN(io.Discard, r.r, size-keep-1); err != nil {
+		return payload, err
+	}
+	last, err := r.r.ReadByte()
+	if err != nil {
+		return payload, err
+	}
+	if last == '\n' {
+		payload = append(payload, last)
+	}
+	return payload, nil
+}
+
+// isFrameHeader returns true if the bytes look like a frame header, i.e. a known stream type followed by three zero
+// bytes. This is not expected at the start of raw TTY output.
+func isFrameHeader(header []byte) bool {
+	return header[0] <= systemErrStreamType && header[1] == 0 && header[2] == 0 && header[3] == 0
+}
+
+// append adds the payload to the stream buffer and moves all completed lines to the line queue
+func (r *logStreamReader) append(streamType byte, payload []byte) {
+	buf := r.buffers[streamType]
+	if len(buf) > 0 && !r.tty {
+		payload = r.trimContinuationPrefix(payload)
+	}
+	buf = append(buf, payload...)
+	for {
+		idx := bytes.IndexByte(buf, '\n')
+		if idx == -1 {
+			break
+		}
+		r.emit(streamType, buf[:idx])
+		buf = buf[idx+1:]
+	}
+	// The rest of a truncated line is dropped as it arrives to keep the buffer bounded
+	if limit := r.prefixLen(buf) + r.maxLineSize; r.maxLineSize > 0 && len(buf) > limit {
+		buf = buf[:limit]
+	}
+	r.buffers[streamType] = buf
+}
+
+// trimContinuationPrefix removes the timestamp and details prefixing a partial message continuing a line
+func (r *logStreamReader) trimContinuationPrefix(payload []byte) []byte {
+	return payload[r.prefixLen(payload):]
+}
+
+// prefixLen returns the length of the timestamp and details prefixing the message of a line, including the spaces
+// separating them. The max line size only applies to the message so that the prefix can still be parsed.
+func (r *logStreamReader) prefixLen(line []byte) int {
+	if !r.timestamps {
+		return 0
+	}
+	ts, rest, found := bytes.Cut(line, []byte{' '})
+	if !found {
+		return 0
+	}
+	if _, err := time.Parse(time.RFC3339Nano, string(ts)); err != nil {
+		return 0
+	}
+	n := len(ts) + 1
+	if r.details {
+		if details, _, found := bytes.Cut(rest, []byte{' '}); found {
+			n += len(details) + 1
+		}
+	}
+	return n
+}
+
+func (r *logStreamReader) emit(streamType byte, line []byte) {
+	line = bytes.TrimRight(line, "\r")
+	if limit := r.prefixLen(line) + r.maxLineSize; r.maxLineSize > 0 && len(line) > limit {
+		line = line[:limit]
+	}
+	r.lines = append(r.lines, streamLine{
+		stream: streamName(streamType),
+		text:   string(line),
+	})
+}
+
+func (r *logStreamReader) flush() {
+	for _, streamType := range []byte{stdinStreamType, stdoutStreamType, stderrStreamType} {
+		if buf := r.buffers[streamType]; len(buf) > 0 {
+			r.emit(streamType, buf)
+		}
+		delete(r.buffers, streamType)
+	}
+}
+
+func streamName(streamType byte) string {
+	switch streamType {
+	case stdinStreamType:
+		return StreamStdin
+	case stdoutStreamType:
+		return StreamStdout
+	case stderrStreamType:
+		return StreamStderr
+	}
+	return ""
+}
@@ -0,0 +1,290 @@
+package stern
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func frame(streamType byte, payload string) []byte {
+	header := make([]byte, streamHeaderLen)
+	header[0] = streamType
+	binary.BigEndian.PutUint32(header[4:], uint32(len(payload)))
+	return append(header, payload...)
+}
+
+func TestLogStreamReader(t *testing.T) {
+	tests := []struct {
+		name        string
+		tty         bool
+		timestamps  bool
+		details     bool
+		maxLineSize int
+		frames      [][]byte
+		expected    []streamLine
+	}{
+		{
+			name: "one line per frame",
+			frames: [][]byte{
+				frame(stdoutStreamType, "line 1\n"),
+				frame(stderrStreamType, "line 2\n"),
+			},
+			expected: []streamLine{
+				{StreamStdout, "line 1"},
+				{StreamStderr, "line 2"},
+			},
+		},
+		{
+			name: "coalesced lines in one frame",
+			frames: [][]byte{
+				frame(stdoutStreamType, "line 1\nline 2\r\nline 3\n"),
+			},
+			expected: []streamLine{
+				{StreamStdout, "line 1"},
+				{StreamStdout, "line 2"},
+				{StreamStdout, "line 3"},
+			},
+		},
+		{
+			name: "line split over frames",
+			frames: [][]byte{
+				frame(stdoutStreamType, "li"),
+				frame(stdoutStreamType, "ne 1\nline"),
+				frame(stdoutStreamType, " 2\n"),
+			},
+			expected: []streamLine{
+				{StreamStdout, "line 1"},
+				{StreamStdout, "line 2"},
+			},
+		},
+		{
+			name: "interleaved split lines are reassembled per stream",
+			frames: [][]byte{
+				frame(stdoutStreamType, "out "),
+				frame(stderrStreamType, "err "),
+				frame(stderrStreamType, "line\n"),
+				frame(stdoutStreamType, "line\n"),
+			},
+			expected: []streamLine{
+				{StreamStderr, "err line"},
+				{StreamStdout, "out line"},
+			},
+		},
+		{
+			name: "newline in header size byte",
+			frames: [][]byte{
+				// The payload size 10 is encoded as '\n'
+				frame(stdoutStreamType, "012345678\n"),
+			},
+			expected: []streamLine{
+				{StreamStdout, "012345678"},
+			},
+		},
+		{
+			name: "incomplete lines are flushed at the end",
+			frames: [][]byte{
+				frame(stdoutStreamType, "line 1\nline 2"),
+				frame(stderrStreamType, "line 3"),
+			},
+			expected: []streamLine{
+				{StreamStdout, "line 1"},
+				{StreamStdout, "line 2"},
+				{StreamStderr, "line 3"},
+			},
+		},
+		{
+			name: "truncated frame",
+			frames: [][]byte{
+				frame(stdoutStreamType, "line 1\n"),
+				frame(stdoutStreamType, "line 2\n")[:streamHeaderLen+4],
+			},
+			expected: []streamLine{
+				{StreamStdout, "line 1"},
+				{StreamStdout, "line"},
+			},
+		},
+		{
+			name:       "partial messages are joined",
+			timestamps: true,
+			frames: [][]byte{
+				frame(stdoutStreamType, "2023-02-13T21:20:30.000000001Z {\"msg\": "),
+				frame(stderrStreamType, "2023-02-13T21:20:30.000000002Z error\n"),
+				frame(stdoutStreamType, "2023-02-13T21:20:30.000000003Z \"long"),
+				frame(stdoutStreamType, "2023-02-13T21:20:30.000000004Z  line\"}\n"),
+				frame(stdoutStreamType, "2023-02-13T21:20:30.000000005Z next\n"),
+			},
+			expected: []streamLine{
+				{StreamStderr, "2023-02-13T21:20:30.000000002Z error"},
+				{StreamStdout, "2023-02-13T21:20:30.000000001Z {\"msg\": \"long line\"}"},
+				{StreamStdout, "2023-02-13T21:20:30.000000005Z next"},
+			},
+		},
+		{
+			name:       "partial messages with details are joined",
+			timestamps: true,
+			details:    true,
+			frames: [][]byte{
+				frame(stdoutStreamType, "2023-02-13T21:20:30.000000001Z task.id=t1 long "),
+				frame(stdoutStreamType, "2023-02-13T21:20:30.000000002Z task.id=t1 line\n"),
+			},
+			expected: []streamLine{
+				{StreamStdout, "2023-02-13T21:20:30.000000001Z task.id=t1 long line"},
+			},
+		},
+		{
+			name:       "continuation without timestamp is kept",
+			timestamps: true,
+			frames: [][]byte{
+				frame(stdoutStreamType, "2023-02-13T21:20:30.000000001Z long "),
+				frame(stdoutStreamType, "line\n"),
+			},
+			expected: []streamLine{
+				{StreamStdout, "2023-02-13T21:20:30.000000001Z long line"},
+			},
+		},
+		{
+			name:        "long lines are truncated",
+			timestamps:  true,
+			maxLineSize: 10,
+			frames: [][]byte{
+				frame(stdoutStreamType, "0123456789abc\n0123"),
+				frame(stdoutStreamType, "2023-02-13T21:20:30.000000001Z 456789"),
+				frame(stdoutStreamType, "2023-02-13T21:20:30.000000002Z abcdef\nshort\n"),
+			},
+			expected: []streamLine{
+				{StreamStdout, "0123456789"},
+				{StreamStdout, "0123456789"},
+				{StreamStdout, "short"},
+			},
+		},
+		{
+			name: "tty",
+			tty:  true,
+			frames: [][]byte{
+				[]byte("line 1\r\nline 2\nline 3"),
+			},
+			expected: []streamLine{
+				{StreamStdout, "line 1"},
+				{StreamStdout, "line 2"},
+				{StreamStdout, "line 3"},
+			},
+		},
+		{
+			name: "tty multiplexed by podman",
+			tty:  true,
+			frames: [][]byte{
+				frame(stdoutStreamType, "line 1\r\n"),
+				frame(stdoutStreamType, "line 2\n"),
+			},
+			expected: []streamLine{
+				{StreamStdout, "line 1"},
+				{StreamStdout, "line 2"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newLogStreamReader(bytes.NewReader(bytes.Join(tt.frames, nil)), tt.tty, tt.timestamps, tt.details, tt.maxLineSize)
+			actual := []streamLine{}
+			for {
+				stream, line, err := r.ReadLine()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("unexpected err %v", err)
+				}
+				actual = append(actual, streamLine{stream, line})
+			}
+
+			if !reflect.DeepEqual(tt.expected, actual) {
+				t.Errorf("expected %q, but actual %q", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestLogStreamReaderSystemErr(t *testing.T) {
+	logs := bytes.Join([][]byte{
+		frame(stdoutStreamType, "line 1\n"),
+		frame(systemErrStreamType, "boom"),
+	}, nil)
+	r := newLogStreamReader(bytes.NewReader(logs), false, false, false, 0)
+
+	if _, line, err := r.ReadLine(); err != nil || line != "line 1" {
+		t.Fatalf("expected line 1, but actual %q (err %v)", line, err)
+	}
+	if _, _, err := r.ReadLine(); err == nil || err.Error() != "error from daemon in stream: boom" {
+		t.Errorf("expected daemon error, but actual %v", err)
+	}
+}
+
+func TestLogStreamReaderFrameTooLarge(t *testing.T) {
+	large := "2024-05-01T10:00:00Z " + strings.Repeat("x", 2*1024*1024)
+	next := "2024-05-01T10:00:01Z line 2"
+	tests := []struct {
+		name        string
+		payload     string
+		details     bool
+		maxLineSize int
+		expected    []string
+	}{
+		// The limit applies to the message after the timestamp
+		{"truncated", large + "\n", false, 6, []string{"2024-05-01T10:00:00Z xxxxxx", next}},
+		{"no limit", large + "\n", false, 0, []string{large, next}},
+		// The partial message is continued by the next frame
+		{"truncated partial message", large, false, 6, []string{"2024-05-01T10:00:00Z xxxxxx"}},
+		{
+			"truncated after details",
+			"2024-05-01T10:00:00Z env=prod " + strings.Repeat("x", 2*1024*1024) + "\n",
+			true,
+			6,
+			[]string{"2024-05-01T10:00:00Z env=prod xxxxxx", next},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logs := bytes.Join([][]byte{
+				frame(stdoutStreamType, tt.payload),
+				frame(stdoutStreamType, next+"\n"),
+			}, nil)
+			r := newLogStreamReader(bytes.NewReader(logs), false, true, tt.details, tt.maxLineSize)
+
+			// The stream continues after the oversized frame
+			var actual []string
+			for {
+				_, line, err := r.ReadLine()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				actual = append(actual, line)
+			}
+			if !reflect.DeepEqual(tt.expected, actual) {
+				t.Errorf("expected %.80q, but actual %.80q", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestLogStreamReaderInvalidFrameHeader(t *testing.T) {
+	header := make([]byte, streamHeaderLen)
+	header[0] = stdoutStreamType
+	header[1] = 'x'
+	logs := append(frame(stdoutStreamType, "line 1\n"), header...)
+	r := newLogStreamReader(bytes.NewReader(logs), false, false, false, 0)
+
+	if _, line, err := r.ReadLine(); err != nil || line != "line 1" {
+		t.Fatalf("expected line 1, but actual %q (err %v)", line, err)
+	}
+	if _, _, err := r.ReadLine(); err == nil || err.Error() != "invalid stream frame header: 0178000000000000" {
+		t.Errorf("expected framing error, but actual %v", err)
+	}
+}
@@ -1,14 +1,17 @@
 package stern
 
 import (
-	"bufio"
 	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"io"
+	"net/url"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"text/template"
+	"time"
 
 	"github.com/containerd/errdefs"
 	"github.com/containerd/log"
@@ -17,6 +20,9 @@ import (
 	"github.com/fatih/color"
 )
 
+// errLogStreamEnded is returned when the log stream of a running container ended
+var errLogStreamEnded = errors.New("log stream ended while the container is running")
+
 type ContainerConfig struct {
 	id             string
 	name           string
@@ -24,11 +30,22 @@ type ContainerConfig struct {
 	composeProject string
 	number         string
 	tty            bool
+	swarm          bool
+	context        string // Docker context of the host, empty when tailing a single host
+	image          string
+	labels         map[string]string
+}
+
+// swarmTask is the task specific information of a line from the Swarm service logs
+type swarmTask struct {
+	name   string
+	number string
 }
 
 type DockerTail struct {
 	client         *dockerclient.Client
 	container      ContainerConfig
+	contextColor   *color.Color
 	namespaceColor *color.Color
 	containerColor *color.Color
 	options        *TailOptions
@@ -39,6 +56,13 @@ type DockerTail struct {
 		lines     int    // the number of lines seen during this timestamp
 	}
 	resumeRequest *ResumeRequest
+	tasks         map[string]swarmTask
+	multiline     *multilineGrouper
+	sorter        *timeSorter
+	activity      *atomic.Int64 // unix nano time of the last log line
+	checkpoints   *checkpoints
+	file          io.WriteCloser // file of the container in the output directory
+	sinks         sinks
 	out           io.Writer
 	errOut        io.Writer
 }
@@ -56,10 +80,12 @@ func NewDockerTail(
 		client:         client,
 		container:      containerConfig,
 		options:        options,
+		contextColor:   determineContextColor(containerConfig.context),
 		namespaceColor: namespaceColor,
 		containerColor: containerColor,
 		tmpl:           tmpl,
 		closed:         make(chan struct{}),
+		tasks:          make(map[string]swarmTask),
 		out:            out,
 		errOut:         errOut,
 	}
@@ -73,6 +99,14 @@ func determineDockerColor(containerName, namespace string) (*color.Color, *color
 	return colorList[colorIndex(namespace)][0], containerColor
 }
 
+// determineContextColor returns the color of the Docker context, or nil without a context
+func determineContextColor(context string) *color.Color {
+	if context == "" {
+		return nil
+	}
+	return colorList[colorIndex(context)][0]
+}
+
 func (t *DockerTail) Start(ctx context.Context) error {
 	tailLog := log.L.WithFields(log.Fields{"name": t.container.name, "id": t.container.id})
 	ctx, cancel := context.WithCancel(log.WithLogger(ctx, tailLog))
@@ -83,18 +117,27 @@ func (t *DockerTail) Start(ctx context.Context) error {
 
 	t.printStarting()
 
-	logs, err := t.client.ContainerLogs(
-		ctx,
-		t.container.id,
-		container.LogsOptions{
-			ShowStdout: true,
-			ShowStderr: true,
-			Follow:     t.options.Follow,
-			Timestamps: true,
-			Since:      t.options.DockerSinceTime,
-			Tail:       t.options.DockerTailLines,
-		},
-	)
+	var until string
+	if !t.options.UntilTime.IsZero() {
+		until = t.options.UntilTime.Format(time.RFC3339Nano)
+	}
+	logsOptions := container.LogsOptions{
+		ShowStdout: t.options.Stream != StreamStderr,
+		ShowStderr: t.options.Stream != StreamStdout,
+		Follow:     t.options.Follow,
+		Timestamps: true,
+		Since:      t.options.DockerSinceTime,
+		Until:      until,
+		Tail:       t.options.DockerTailLines,
+		Details:    t.container.swarm,
+	}
+	var logs io.ReadCloser
+	var err error
+	if t.container.swarm {
+		logs, err = t.client.ServiceLogs(ctx, t.container.id, logsOptions)
+	} else {
+		logs, err = t.client.ContainerLogs(ctx, t.container.id, logsOptions)
+	}
 	if err != nil {
 		return err
 	}
@@ -106,14 +149,37 @@ func (t *DockerTail) Start(ctx context.Context) error {
 		if errors.Is(err, context.Canceled) || errdefs.IsConflict(err) {
 			return nil
 		}
+		return err
 	}
 
-	return err
+	return t.checkStopped(ctx)
+}
+
+// checkStopped returns an error if the log stream of a followed container ended while the container is still running,
+// e.g. when dockerd restarted with live-restore, so that the tail is retried from its resume point
+func (t *DockerTail) checkStopped(ctx context.Context) error {
+	if !t.options.Follow || t.container.swarm || ctx.Err() != nil {
+		return nil
+	}
+	c, err := t.client.ContainerInspect(ctx, t.container.id)
+	if err != nil {
+		if errdefs.IsNotFound(err) || ctx.Err() != nil {
+			return nil
+		}
+		return err
+	}
+	if c.State != nil && c.State.Running {
+		return errLogStreamEnded
+	}
+	return nil
 }
 
 func (t *DockerTail) Close() {
 	t.printStopping()
 	close(t.closed)
+	if t.file != nil {
+		t.file.Close()
+	}
 }
 
 func (t *DockerTail) Resume(ctx context.Context, resumeRequest *ResumeRequest) error {
@@ -124,23 +190,28 @@ func (t *DockerTail) Resume(ctx context.Context, resumeRequest *ResumeRequest) e
 }
 
 func (t *DockerTail) consumeStream(ctx context.Context, logs io.Reader) error {
-	r := bufio.NewReader(logs)
+	r := newLogStreamReader(logs, t.container.tty, true, t.container.swarm, t.options.MaxLineSize)
+	t.multiline = newMultilineGrouper(t.options, func(e logEntry) {
+		t.consumeEntry(ctx, e)
+	})
+	defer t.multiline.flush()
 	for {
-		line, err := r.ReadBytes('\n')
-		if len(line) != 0 {
-			t.consumeLine(ctx, strings.TrimRight(string(line), "\r\n"))
-		}
+		stream, line, err := r.ReadLine()
 		if err != nil {
 			if err != io.EOF {
 				return err
 			}
 			return nil
 		}
+		t.consumeLine(ctx, stream, line)
 	}
 }
 
-func (t *DockerTail) consumeLine(ctx context.Context, line string) {
-	rfc3339Nano, content, err := splitLogLine(trimLeadingChars(ctx, line, t.container.tty))
+func (t *DockerTail) consumeLine(ctx context.Context, stream, line string) {
+	if t.activity != nil {
+		t.activity.Store(time.Now().UnixNano())
+	}
+	rfc3339Nano, content, err := splitLogLine(line)
 	if err != nil {
 		t.Print(ctx, fmt.Sprintf("[%v] %s", err, line))
 		return
@@ -148,44 +219,103 @@ func (t *DockerTail) consumeLine(ctx context.Context, line string) {
 
 	rfc3339 := removeSubsecond(rfc3339Nano)
 	t.rememberLastTimestamp(rfc3339)
+	t.checkpoints.update(t.container.id, t.last.timestamp, t.last.lines)
 	if t.resumeRequest.shouldSkip(rfc3339) {
 		return
 	}
 	t.resumeRequest = nil
 
-	if t.options.IsExclude(content) || !t.options.IsInclude(content) {
+	// The Swarm service logs API does not support until
+	if t.options.IsAfterUntil(rfc3339Nano) {
+		return
+	}
+
+	var task swarmTask
+	if t.container.swarm {
+		var details string
+		details, content, err = splitLogLine(content)
+		if err != nil {
+			t.Print(ctx, fmt.Sprintf("[%v] %s", err, line))
+			return
+		}
+		task = t.lookupTask(ctx, details)
+	}
+
+	t.multiline.add(logEntry{
+		timestamp: rfc3339Nano,
+		stream:    stream,
+		task:      task,
+		content:   content,
+	})
+}
+
+func (t *DockerTail) consumeEntry(ctx context.Context, e logEntry) {
+	if t.options.IsExclude(e.content) || !t.options.IsInclude(e.content) || t.options.IsBelowLevel(e.content) {
 		return
 	}
 
-	msg := t.options.HighlightMatchedString(content)
+	msg := t.options.HighlightMatchedString(e.content)
 
 	if t.options.Timestamps {
-		updatedTs, err := t.options.UpdateTimezoneAndFormat(rfc3339Nano)
+		updatedTs, err := t.options.UpdateTimezoneAndFormat(e.timestamp)
 		if err != nil {
-			t.Print(ctx, fmt.Sprintf("[%v] %s", err, line))
+			t.Print(ctx, fmt.Sprintf("[%v] %s %s", err, e.timestamp, e.content))
 			return
 		}
 		msg = updatedTs + " " + msg
 	}
 
-	t.Print(ctx, msg)
+	timestamp, _ := time.Parse(time.RFC3339Nano, e.timestamp)
+	vm := t.newLog(msg)
+	vm.Stream = e.stream
+	vm.Timestamp = t.options.logTimestamp(timestamp)
+	if t.container.swarm {
+		vm.ContainerName = e.task.name
+		vm.ContainerNumber = e.task.number
+	}
+	t.printLog(ctx, timestamp, vm)
+
+	// Sinks get the line as it was logged, without highlighting and timestamp
+	vm.Message = e.content
+	t.sinks.send(vm)
 }
 
 func (t *DockerTail) Print(ctx context.Context, msg string) {
-	vm := Log{
+	t.printLog(ctx, time.Time{}, t.newLog(msg))
+}
+
+func (t *DockerTail) newLog(msg string) Log {
+	return Log{
 		Message:         msg,
 		ContainerName:   t.container.name,
 		ServiceName:     t.container.service,
 		Namespace:       t.container.composeProject,
 		ContainerNumber: t.container.number,
+		Context:         t.container.context,
+		ContainerID:     t.container.id,
+		Image:           t.container.image,
+		Labels:          t.container.labels,
+		ContextColor:    t.contextColor,
 		NamespaceColor:  t.namespaceColor,
 		ContainerColor:  t.containerColor,
 	}
+}
 
+// printLog prints the log using the template. Logs with a timestamp are passed through the time sorter if enabled.
+func (t *DockerTail) printLog(ctx context.Context, timestamp time.Time, vm Log) {
 	var buf bytes.Buffer
 	if err := t.tmpl.Execute(&buf, vm); err != nil {
 		fmt.Fprintf(t.errOut, "expanding template failed: %s\n", err)
-		log.G(ctx).WithField("error", err).WithField("message", msg).Error("Template failure")
+		log.G(ctx).WithField("error", err).WithField("message", vm.Message).Error("Template failure")
+		return
+	}
+	if t.file != nil {
+		if _, err := t.file.Write(stripColors(buf.Bytes())); err != nil {
+			log.G(ctx).WithField("error", err).Error("Writing output file failed")
+		}
+	}
+	if t.sorter != nil && !timestamp.IsZero() {
+		t.sorter.add(timestamp, buf.String())
 		return
 	}
 	fmt.Fprint(t.out, buf.String())
@@ -197,9 +327,9 @@ func (t *DockerTail) printStarting() {
 		p := t.namespaceColor.SprintFunc()
 		c := t.containerColor.SprintFunc()
 		if t.container.composeProject == "" {
-			fmt.Fprintf(t.errOut, "%s %s\n", g("+"), c(t.container.name))
+			fmt.Fprintf(t.errOut, "%s %s%s\n", g("+"), t.contextPrefix(), c(t.container.name))
 		} else {
-			fmt.Fprintf(t.errOut, "%s %s › %s\n", g("+"), p(t.container.composeProject), c(t.container.service))
+			fmt.Fprintf(t.errOut, "%s %s%s › %s\n", g("+"), t.contextPrefix(), p(t.container.composeProject), c(t.container.service))
 		}
 	}
 }
@@ -210,27 +340,64 @@ func (t *DockerTail) printStopping() {
 		p := t.namespaceColor.SprintFunc()
 		c := t.containerColor.SprintFunc()
 		if t.container.composeProject == "" {
-			fmt.Fprintf(t.errOut, "%s %s\n", r("-"), c(t.container.name))
+			fmt.Fprintf(t.errOut, "%s %s%s\n", r("-"), t.contextPrefix(), c(t.container.name))
 		} else {
-			fmt.Fprintf(t.errOut, "%s %s › %s\n", r("-"), p(t.container.composeProject), c(t.container.service))
+			fmt.Fprintf(t.errOut, "%s %s%s › %s\n", r("-"), t.contextPrefix(), p(t.container.composeProject), c(t.container.service))
 		}
 	}
 }
 
-// Container stream format: https://docs.docker.com/reference/api/engine/version/v1.47/#tag/Container/operation/ContainerAttach
-// When TTY is not enabled, the lines are prefixed with stream type (stdin/stdout/stderr). We don't need it, so it's just
-// stripped away. The header also contains the payload size, but it seems good enough to just read full lines, which is
-// also easier since the format differs depending on TTY.
-func trimLeadingChars(ctx context.Context, line string, tty bool) string {
-	if tty {
-		return line
-	}
-	if len(line) < 8 {
-		// And sometimes the line is something else...?
-		log.G(ctx).WithField("line", line).Info("Invalid log line format received")
+// contextPrefix returns the colored Docker context followed by a space, or an empty string without a context
+func (t *DockerTail) contextPrefix() string {
+	if t.container.context == "" {
 		return ""
 	}
-	return line[8:]
+	return t.contextColor.SprintFunc()(t.container.context) + " "
+}
+
+// lookupTask resolves the task of a Swarm service log line from its details, e.g.
+// "com.docker.swarm.node.id=n1,com.docker.swarm.service.id=s1,com.docker.swarm.task.id=t1". The tasks are inspected
+// once and cached since a service may have tasks replaced during its lifetime.
+func (t *DockerTail) lookupTask(ctx context.Context, details string) swarmTask {
+	taskId := parseLogDetails(details)["com.docker.swarm.task.id"]
+	if task, ok := t.tasks[taskId]; ok {
+		return task
+	}
+
+	task := swarmTask{name: t.container.name + "." + taskId}
+	if t.client != nil && taskId != "" {
+		swarmTask, _, err := t.client.TaskInspectWithRaw(ctx, taskId)
+		if err != nil {
+			log.G(ctx).WithField("task", taskId).Error(err, ": failed to inspect task")
+		} else if swarmTask.Slot > 0 {
+			task.number = strconv.Itoa(swarmTask.Slot)
+			task.name = fmt.Sprintf("%s.%d.%s", t.container.name, swarmTask.Slot, taskId)
+		} else {
+			// Global services have no slots, the task is identified by its node instead
+			task.name = fmt.Sprintf("%s.%s.%s", t.container.name, swarmTask.NodeID, taskId)
+		}
+	}
+	t.tasks[taskId] = task
+	return task
+}
+
+// parseLogDetails parses the comma separated and URL encoded key=value pairs preceding the message when requesting
+// logs with details.
+func parseLogDetails(details string) map[string]string {
+	attrs := make(map[string]string)
+	for _, pair := range strings.Split(details, ",") {
+		k, v, _ := strings.Cut(pair, "=")
+		key, err := url.QueryUnescape(k)
+		if err != nil || key == "" {
+			continue
+		}
+		value, err := url.QueryUnescape(v)
+		if err != nil {
+			continue
+		}
+		attrs[key] = value
+	}
+	return attrs
 }
 
 func (t *DockerTail) rememberLastTimestamp(timestamp string) {
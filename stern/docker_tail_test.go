@@ -3,9 +3,16 @@ package stern
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"io"
+	"reflect"
+	"regexp"
+	"strings"
 	"testing"
 	"text/template"
+	"time"
+
+	"github.com/fatih/color"
 )
 
 func TestDetermineColor(t *testing.T) {
@@ -77,6 +84,10 @@ func TestPrintStarting(t *testing.T) {
 				compose,
 				"0",
 				false,
+				false,
+				"",
+				"",
+				nil,
 			},
 			nil,
 			io.Discard,
@@ -131,6 +142,10 @@ func TestPrintStopping(t *testing.T) {
 				compose,
 				"0",
 				false,
+				false,
+				"",
+				"",
+				nil,
 			},
 			nil,
 			io.Discard,
@@ -210,6 +225,10 @@ line 4 (container1)
 					"",
 					"0",
 					true,
+					false,
+					"",
+					"",
+					nil,
 				},
 				tmpl,
 				out,
@@ -227,3 +246,296 @@ line 4 (container1)
 		})
 	}
 }
+
+func TestConsumeStreamSwarm(t *testing.T) {
+	logLines := []byte(`2023-02-13T21:20:30.000000001Z com.docker.swarm.node.id=n1,com.docker.swarm.service.id=s1,com.docker.swarm.task.id=t1 line 1
+2023-02-13T21:20:30.000000002Z com.docker.swarm.node.id=n1,com.docker.swarm.service.id=s1,com.docker.swarm.task.id=t2 line 2
+2023-02-13T21:20:31.000000001Z com.docker.swarm.node.id=n1,com.docker.swarm.service.id=s1,com.docker.swarm.task.id=t3 line 3
+`)
+	tmpl := template.Must(template.New("").Parse(`{{printf "%s %s %s %s\n" .Namespace .ServiceName .ContainerNumber .Message}}`))
+
+	out := new(bytes.Buffer)
+	tail := NewDockerTail(
+		nil,
+		ContainerConfig{
+			"s1",
+			"stack_web",
+			"web",
+			"stack",
+			"",
+			true,
+			true,
+			"",
+			"",
+			nil,
+		},
+		tmpl,
+		out,
+		io.Discard,
+		&TailOptions{},
+	)
+	tail.tasks["t1"] = swarmTask{name: "stack_web.1.t1", number: "1"}
+	tail.tasks["t2"] = swarmTask{name: "stack_web.2.t2", number: "2"}
+	if err := tail.consumeStream(context.TODO(), bytes.NewReader(logLines)); err != nil {
+		t.Fatalf("unexpected err %v", err)
+	}
+
+	expected := []byte(`stack web 1 line 1
+stack web 2 line 2
+stack web  line 3
+`)
+	if !bytes.Equal(expected, out.Bytes()) {
+		t.Errorf("expected %s, but actual %s", expected, out)
+	}
+}
+
+func TestConsumeStreamMultiplexed(t *testing.T) {
+	var logLines []byte
+	logLines = append(logLines, frame(stdoutStreamType, "2023-02-13T21:20:30.000000001Z line 1\n2023-02-13T21:20:30.000000002Z li")...)
+	logLines = append(logLines, frame(stderrStreamType, "2023-02-13T21:20:30.000000003Z line 3\n")...)
+	logLines = append(logLines, frame(stdoutStreamType, "ne 2\n")...)
+	tmpl := template.Must(template.New("").Parse(`{{printf "%s %s\n" .Stream .Message}}`))
+
+	out := new(bytes.Buffer)
+	tail := NewDockerTail(
+		nil,
+		ContainerConfig{
+			"id",
+			"container1",
+			"",
+			"",
+			"0",
+			false,
+			false,
+			"",
+			"",
+			nil,
+		},
+		tmpl,
+		out,
+		io.Discard,
+		&TailOptions{},
+	)
+	if err := tail.consumeStream(context.TODO(), bytes.NewReader(logLines)); err != nil {
+		t.Fatalf("unexpected err %v", err)
+	}
+
+	expected := []byte(`stdout line 1
+stderr line 3
+stdout line 2
+`)
+	if !bytes.Equal(expected, out.Bytes()) {
+		t.Errorf("expected %s, but actual %s", expected, out)
+	}
+}
+
+func TestConsumeStreamMultiline(t *testing.T) {
+	logLines := []byte(`2023-02-13T21:20:30.000000001Z INFO starting
+2023-02-13T21:20:30.000000002Z ERROR request failed
+2023-02-13T21:20:30.000000003Z java.lang.NullPointerException
+2023-02-13T21:20:30.000000004Z 	at Main.main(Main.java:1)
+2023-02-13T21:20:31.000000001Z INFO done
+`)
+	tmpl := template.Must(template.New("").Parse(`{{printf "%s\n" .Message}}`))
+
+	out := new(bytes.Buffer)
+	tail := NewDockerTail(
+		nil,
+		ContainerConfig{
+			"id",
+			"container1",
+			"",
+			"",
+			"0",
+			true,
+			false,
+			"",
+			"",
+			nil,
+		},
+		tmpl,
+		out,
+		io.Discard,
+		&TailOptions{
+			Include:        []*regexp.Regexp{regexp.MustCompile(`ERROR`)},
+			MultilineStart: regexp.MustCompile(`^(INFO|ERROR) `),
+		},
+	)
+	if err := tail.consumeStream(context.TODO(), bytes.NewReader(logLines)); err != nil {
+		t.Fatalf("unexpected err %v", err)
+	}
+
+	expected := []byte(`ERROR request failed
+java.lang.NullPointerException
+	at Main.main(Main.java:1)
+`)
+	if !bytes.Equal(expected, out.Bytes()) {
+		t.Errorf("expected %s, but actual %s", expected, out)
+	}
+}
+
+func TestParseLogDetails(t *testing.T) {
+	tests := []struct {
+		details  string
+		expected map[string]string
+	}{
+		{
+			"com.docker.swarm.node.id=n1,com.docker.swarm.task.id=t1",
+			map[string]string{"com.docker.swarm.node.id": "n1", "com.docker.swarm.task.id": "t1"},
+		},
+		{
+			"key%3D1=value%2C1",
+			map[string]string{"key=1": "value,1"},
+		},
+		{
+			"",
+			map[string]string{},
+		},
+	}
+
+	for i, tt := range tests {
+		actual := parseLogDetails(tt.details)
+		if !reflect.DeepEqual(tt.expected, actual) {
+			t.Errorf("%d: expected %v, but actual %v", i, tt.expected, actual)
+		}
+	}
+}
+
+func TestConsumeStreamContext(t *testing.T) {
+	orig := color.NoColor
+	color.NoColor = true
+	defer func() {
+		color.NoColor = orig
+	}()
+
+	tmpl := template.Must(template.New("").Parse(`{{printf "%s %s %s\n" .Context .ServiceName .Message}}`))
+	out, errOut := new(bytes.Buffer), new(bytes.Buffer)
+	tail := NewDockerTail(
+		nil,
+		ContainerConfig{
+			"id",
+			"shop-web-1",
+			"web",
+			"shop",
+			"1",
+			true,
+			false,
+			"staging-1",
+			"",
+			nil,
+		},
+		tmpl,
+		out,
+		errOut,
+		&TailOptions{},
+	)
+	tail.printStarting()
+	if err := tail.consumeStream(context.TODO(), strings.NewReader("2023-02-13T21:20:30.000000001Z line 1\n")); err != nil {
+		t.Fatalf("unexpected err %v", err)
+	}
+
+	if expected := "staging-1 web line 1\n"; out.String() != expected {
+		t.Errorf("expected %q, but actual %q", expected, out.String())
+	}
+	if expected := "+ staging-1 shop › web\n"; errOut.String() != expected {
+		t.Errorf("expected %q, but actual %q", expected, errOut.String())
+	}
+}
+
+func TestConsumeStreamLogFields(t *testing.T) {
+	tmpl := template.Must(template.New("").Funcs(template.FuncMap{
+		"json": func(in any) (string, error) {
+			b, err := json.Marshal(in)
+			return string(b), err
+		},
+	}).Parse(`{{.Timestamp.Format "15:04:05.000"}} {{.ContainerID}} {{.Image}} {{index .Labels "team"}}
+{{json .}}
+`))
+
+	out := new(bytes.Buffer)
+	tail := NewDockerTail(
+		nil,
+		ContainerConfig{
+			"id",
+			"container1",
+			"container1",
+			"",
+			"",
+			true,
+			false,
+			"",
+			"nginx:1.27",
+			map[string]string{"team": "web"},
+		},
+		tmpl,
+		out,
+		io.Discard,
+		&TailOptions{Location: time.FixedZone("", 2*60*60)},
+	)
+	if err := tail.consumeStream(context.TODO(), strings.NewReader("2023-02-13T21:20:30.123456789Z line 1\n")); err != nil {
+		t.Fatalf("unexpected err %v", err)
+	}
+
+	expected := `23:20:30.123 id nginx:1.27 web
+{"message":"line 1","container":"container1","service":"container1","namespace":"","number":"","stream":"stdout","timestamp":"2023-02-13T23:20:30.123456789+02:00","id":"id","image":"nginx:1.27","labels":{"team":"web"}}
+`
+	if out.String() != expected {
+		t.Errorf("expected %q, but actual %q", expected, out.String())
+	}
+}
+
+// recordingSink records the lines sent to it
+type recordingSink struct {
+	lines []Log
+}
+
+func (s *recordingSink) send(vm Log) {
+	s.lines = append(s.lines, vm)
+}
+
+func (s *recordingSink) close() {}
+
+func TestConsumeStreamSinks(t *testing.T) {
+	tmpl := template.Must(template.New("").Parse(`{{.Message}}{{"\n"}}`))
+	out := new(bytes.Buffer)
+	tail := NewDockerTail(
+		nil,
+		ContainerConfig{
+			"id",
+			"container1",
+			"container1",
+			"",
+			"",
+			true,
+			false,
+			"",
+			"",
+			nil,
+		},
+		tmpl,
+		out,
+		io.Discard,
+		&TailOptions{
+			Timestamps: true,
+			Location:   time.UTC,
+			Highlight:  []*regexp.Regexp{regexp.MustCompile("line")},
+			Exclude:    []*regexp.Regexp{regexp.MustCompile("excluded")},
+		},
+	)
+	sink := &recordingSink{}
+	tail.sinks = sinks{sink}
+	logs := "2023-02-13T21:20:30.000000001Z line 1\n2023-02-13T21:20:31.000000001Z excluded\n"
+	if err := tail.consumeStream(context.TODO(), strings.NewReader(logs)); err != nil {
+		t.Fatalf("unexpected err %v", err)
+	}
+
+	if len(sink.lines) != 1 {
+		t.Fatalf("expected 1 line, but actual %v", sink.lines)
+	}
+	if vm := sink.lines[0]; vm.Message != "line 1" || vm.ContainerName != "container1" || vm.Timestamp.IsZero() {
+		t.Errorf("expected the line without timestamp and highlighting, but actual %+v", vm)
+	}
+	if out.String() == "line 1\n" {
+		t.Errorf("expected the printed line to have a timestamp, but actual %q", out.String())
+	}
+}
@@ -2,12 +2,14 @@ package stern
 
 import (
 	"regexp"
+	"slices"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/containerd/log"
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/swarm"
 	"github.com/hashicorp/golang-lru/v2"
 )
 
@@ -18,6 +20,10 @@ type DockerTarget struct {
 	ComposeProject  string
 	ContainerNumber string
 	Tty             bool
+	Swarm           bool
+	Context         string // Docker context of the host, empty when tailing a single host
+	Image           string
+	Labels          map[string]string
 	ResumeRequest   *ResumeRequest
 }
 
@@ -26,12 +32,24 @@ type dockerTargetFilterConfig struct {
 	containerExcludeFilter []*regexp.Regexp
 	composeProjectFilter   []*regexp.Regexp
 	imageFilter            []*regexp.Regexp
+	serviceFilter          []*regexp.Regexp
+	stackFilter            []*regexp.Regexp
+	stateFilter            []string
+	healthFilter           []string
+	networkFilter          []*regexp.Regexp
+	volumeFilter           []string
+	publishFilter          []string
+	labelFilter            []LabelSelector
 }
 
 type dockerTargetFilter struct {
 	config           dockerTargetFilterConfig
+	context          string // set as the context of the targets
 	activeContainers map[string]time.Time
+	tailedContainers map[string]int
 	seenContainers   *lru.Cache[string, *ResumeRequest]
+	checkpoints      *checkpoints
+	stopTail         func(containerId string) // stops the tail of a deselected container
 	mu               sync.RWMutex
 }
 
@@ -44,33 +62,19 @@ func newDockerTargetFilter(filterConfig dockerTargetFilterConfig, lruCacheSize i
 	return &dockerTargetFilter{
 		config:           filterConfig,
 		activeContainers: make(map[string]time.Time),
+		tailedContainers: make(map[string]int),
 		seenContainers:   lru,
 	}
 }
 
 func (f *dockerTargetFilter) visit(container container.InspectResponse, visitor func(t *DockerTarget)) {
-	var composeProject, containerNumber string
-	containerName := strings.TrimPrefix(container.Name, "/")
-	serviceName := containerName
-	if p, ok := container.Config.Labels["com.docker.compose.project"]; ok {
-		composeProject = p
-	}
-	if s, ok := container.Config.Labels["com.docker.compose.service"]; ok {
-		serviceName = s
-	}
-	if n, ok := container.Config.Labels["com.docker.compose.container-number"]; ok {
-		containerNumber = n
-	}
-
-	if !f.matchingNameFilter(serviceName) ||
-		!f.matchingComposeFilter(composeProject) ||
-		!f.matchingImageFilter(container.Config.Image) ||
-		f.matchingNameExcludeFilter(serviceName) {
+	containerName, serviceName, composeProject, containerNumber := containerNames(container)
+	if !f.matches(container) {
 		return
 	}
 
-	// Not yet started containers have no logs
-	if container.State.Status == "created" {
+	// Not yet started containers have no logs, they are only tailed when asked for by the state filter
+	if container.State.Status == "created" && !slices.Contains(f.config.stateFilter, "created") {
 		return
 	}
 
@@ -90,6 +94,9 @@ func (f *dockerTargetFilter) visit(container container.InspectResponse, visitor
 		ComposeProject:  composeProject,
 		ContainerNumber: containerNumber,
 		Tty:             container.Config.Tty,
+		Context:         f.context,
+		Image:           container.Config.Image,
+		Labels:          container.Config.Labels,
 		ResumeRequest:   resumeRequest,
 	}
 
@@ -98,10 +105,99 @@ func (f *dockerTargetFilter) visit(container container.InspectResponse, visitor
 	}
 }
 
+// revisit visits a container whose properties changed. A tailed container that no longer matches the filter is
+// deselected.
+func (f *dockerTargetFilter) revisit(container container.InspectResponse, visitor func(t *DockerTarget)) {
+	if f.matches(container) {
+		f.visit(container, visitor)
+		return
+	}
+	if f.isActive(&DockerTarget{Id: container.ID}) {
+		f.deselect(container.ID)
+	}
+}
+
+func (f *dockerTargetFilter) matches(container container.InspectResponse) bool {
+	_, serviceName, composeProject, _ := containerNames(container)
+	return f.matchingNameFilter(serviceName) &&
+		f.matchingComposeFilter(composeProject) &&
+		f.matchingImageFilter(container.Config.Image) &&
+		f.matchingLabelFilter(container.Config.Labels) &&
+		!f.matchingNameExcludeFilter(serviceName) &&
+		f.matchingStateFilter(container.State) &&
+		f.matchingHealthFilter(container.State) &&
+		f.matchingNetworkFilter(container.NetworkSettings) &&
+		f.matchingVolumeFilter(container.Mounts) &&
+		f.matchingPublishFilter(container.NetworkSettings)
+}
+
+// containerNames returns the container name, service name, compose project and container number of a container
+func containerNames(container container.InspectResponse) (string, string, string, string) {
+	var composeProject, containerNumber string
+	containerName := strings.TrimPrefix(container.Name, "/")
+	serviceName := containerName
+	if p, ok := composeLabel(container.Config.Labels, "project"); ok {
+		composeProject = p
+	}
+	if s, ok := composeLabel(container.Config.Labels, "service"); ok {
+		serviceName = s
+	}
+	if n, ok := composeLabel(container.Config.Labels, "container-number"); ok {
+		containerNumber = n
+	}
+	return containerName, serviceName, composeProject, containerNumber
+}
+
+// visitService visits a Swarm service. The target ID is the service ID as the service logs API streams the logs of all
+// the service tasks.
+func (f *dockerTargetFilter) visitService(service swarm.Service, visitor func(t *DockerTarget)) {
+	containerSpec := service.Spec.TaskTemplate.ContainerSpec
+	if containerSpec == nil {
+		// Plugin and network attachment services have no logs
+		return
+	}
+
+	stack := service.Spec.Labels["com.docker.stack.namespace"]
+	serviceName := service.Spec.Name
+	if stack != "" {
+		serviceName = strings.TrimPrefix(serviceName, stack+"_")
+	}
+
+	if !f.matchingServiceFilter(service.Spec.Name) ||
+		!f.matchingStackFilter(stack) ||
+		!f.matchingImageFilter(containerSpec.Image) ||
+		!f.matchingLabelFilter(service.Spec.Labels) ||
+		f.matchingNameExcludeFilter(serviceName) {
+		return
+	}
+
+	var resumeRequest *ResumeRequest
+	if rr, ok := f.seenContainers.Peek(service.ID); ok {
+		resumeRequest = rr
+	}
+	target := &DockerTarget{
+		Id:             service.ID,
+		Name:           service.Spec.Name,
+		ServiceName:    serviceName,
+		ComposeProject: stack,
+		Tty:            containerSpec.TTY,
+		Swarm:          true,
+		Context:        f.context,
+		Image:          containerSpec.Image,
+		Labels:         service.Spec.Labels,
+		ResumeRequest:  resumeRequest,
+	}
+
+	if f.shouldAdd(target, service.CreatedAt) {
+		visitor(target)
+	}
+}
+
 func (f *dockerTargetFilter) shouldAdd(t *DockerTarget, startedAt time.Time) bool {
 	f.mu.Lock()
 	activeStartedAt, found := f.activeContainers[t.Id]
 	f.activeContainers[t.Id] = startedAt
+	tailed := f.tailedContainers[t.Id] > 0
 	f.mu.Unlock()
 
 	// Listed already terminated containers will not emit a Die event so they will stay in the activeContainers map. When
@@ -112,6 +208,13 @@ func (f *dockerTargetFilter) shouldAdd(t *DockerTarget, startedAt time.Time) boo
 		return false
 	}
 
+	// The die event was missed, e.g. while reconnecting to dockerd, and the container is still being tailed. The running
+	// tail resumes the restarted container.
+	if found && tailed {
+		log.L.WithField("id", t.Id).WithField("name", t.Name).Info("Container restarted while being tailed")
+		return false
+	}
+
 	return true
 }
 
@@ -167,6 +270,127 @@ func (f *dockerTargetFilter) matchingImageFilter(containerImage string) bool {
 	return false
 }
 
+func (f *dockerTargetFilter) matchingServiceFilter(serviceName string) bool {
+	if len(f.config.serviceFilter) == 0 {
+		return true
+	}
+
+	for _, re := range f.config.serviceFilter {
+		if re.MatchString(serviceName) {
+			return true
+		}
+	}
+	log.L.WithField("service", serviceName).Info("Service name does not match filters")
+	return false
+}
+
+func (f *dockerTargetFilter) matchingStackFilter(stack string) bool {
+	if len(f.config.stackFilter) == 0 {
+		return true
+	} else if len(stack) > 0 {
+		for _, re := range f.config.stackFilter {
+			if re.MatchString(stack) {
+				return true
+			}
+		}
+	}
+	log.L.WithField("stack", stack).Info("Stack name does not match filters")
+	return false
+}
+
+func (f *dockerTargetFilter) matchingLabelFilter(labels map[string]string) bool {
+	if matchingLabels(labels, f.config.labelFilter) {
+		return true
+	}
+	log.L.WithField("labels", labels).Info("Labels do not match filters")
+	return false
+}
+
+func (f *dockerTargetFilter) matchingStateFilter(state *container.State) bool {
+	if len(f.config.stateFilter) == 0 {
+		return true
+	}
+
+	var status string
+	if state != nil {
+		status = state.Status
+	}
+	if slices.Contains(f.config.stateFilter, status) {
+		return true
+	}
+	log.L.WithField("state", status).Info("Container state does not match filters")
+	return false
+}
+
+func (f *dockerTargetFilter) matchingHealthFilter(state *container.State) bool {
+	if len(f.config.healthFilter) == 0 {
+		return true
+	}
+
+	health := container.NoHealthcheck
+	if state != nil && state.Health != nil {
+		health = state.Health.Status
+	}
+	if slices.Contains(f.config.healthFilter, health) {
+		return true
+	}
+	log.L.WithField("health", health).Info("Container health does not match filters")
+	return false
+}
+
+func (f *dockerTargetFilter) matchingNetworkFilter(settings *container.NetworkSettings) bool {
+	if len(f.config.networkFilter) == 0 {
+		return true
+	} else if settings != nil {
+		for network := range settings.Networks {
+			for _, re := range f.config.networkFilter {
+				if re.MatchString(network) {
+					return true
+				}
+			}
+		}
+	}
+	log.L.Info("Container networks do not match filters")
+	return false
+}
+
+// matchingVolumeFilter matches the volume name, or the source or destination of a mount
+func (f *dockerTargetFilter) matchingVolumeFilter(mounts []container.MountPoint) bool {
+	if len(f.config.volumeFilter) == 0 {
+		return true
+	}
+
+	for _, m := range mounts {
+		for _, volume := range f.config.volumeFilter {
+			if volume == m.Name || volume == m.Source || volume == m.Destination {
+				return true
+			}
+		}
+	}
+	log.L.Info("Container volumes do not match filters")
+	return false
+}
+
+// matchingPublishFilter matches published ports given as port or port/protocol
+func (f *dockerTargetFilter) matchingPublishFilter(settings *container.NetworkSettings) bool {
+	if len(f.config.publishFilter) == 0 {
+		return true
+	} else if settings != nil {
+		for port, bindings := range settings.Ports {
+			for _, binding := range bindings {
+				for _, publish := range f.config.publishFilter {
+					hostPort, proto, found := strings.Cut(publish, "/")
+					if hostPort == binding.HostPort && (!found || proto == port.Proto()) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	log.L.Info("Container published ports do not match filters")
+	return false
+}
+
 func (f *dockerTargetFilter) inactive(containerId string) {
 	f.mu.Lock()
 	defer f.mu.Unlock()
@@ -175,6 +399,46 @@ func (f *dockerTargetFilter) inactive(containerId string) {
 	delete(f.activeContainers, containerId)
 }
 
+// deselect makes the container inactive and stops its tail
+func (f *dockerTargetFilter) deselect(containerId string) {
+	log.L.WithField("id", containerId).Info("Deselect container")
+	f.inactive(containerId)
+	if f.stopTail != nil {
+		f.stopTail(containerId)
+	}
+}
+
+// retain makes all containers except the given ones inactive and forgets them
+func (f *dockerTargetFilter) retain(containerIds map[string]bool) {
+	f.mu.Lock()
+	var removed []string
+	for id := range f.activeContainers {
+		if !containerIds[id] {
+			removed = append(removed, id)
+		}
+	}
+	f.mu.Unlock()
+
+	for _, id := range removed {
+		f.inactive(id)
+		f.forget(id)
+	}
+}
+
+// tailing marks the container as being tailed, or no longer tailed, including retries
+func (f *dockerTargetFilter) tailing(containerId string, tailing bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if tailing {
+		f.tailedContainers[containerId]++
+		return
+	}
+	f.tailedContainers[containerId]--
+	if f.tailedContainers[containerId] <= 0 {
+		delete(f.tailedContainers, containerId)
+	}
+}
+
 func (f *dockerTargetFilter) setResumeRequest(containerId string, resume *ResumeRequest) {
 	if resume == nil {
 		return
@@ -187,6 +451,7 @@ func (f *dockerTargetFilter) forget(containerId string) {
 	log.L.WithField("id", containerId).Info("Forget container")
 	// Actively remove container from LRU cache to minimize the risk of old (but not removed) containers getting evicted
 	f.seenContainers.Remove(containerId)
+	f.checkpoints.forget(containerId)
 }
 
 func (f *dockerTargetFilter) isActive(t *DockerTarget) bool {
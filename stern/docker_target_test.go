@@ -6,6 +6,9 @@ import (
 	"testing"
 
 	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/network"
+	"github.com/docker/docker/api/types/swarm"
+	"github.com/docker/go-connections/nat"
 )
 
 func TestTargetFilter(t *testing.T) {
@@ -52,16 +55,15 @@ func TestTargetFilter(t *testing.T) {
 		createContainer("compose2", "id6", "container2", "image2"),
 	}
 
-	genTarget := func(composeProject, id, name string) DockerTarget {
-		containerName := name + "-0"
-		if composeProject == "" {
-			containerName = name
-		}
+	genTarget := func(composeProject, id, name, image string) DockerTarget {
+		container := createContainer(composeProject, id, name, image)
 		return DockerTarget{
 			ComposeProject: composeProject,
 			Id:             id,
-			Name:           containerName,
+			Name:           container.Name,
 			ServiceName:    name,
+			Image:          image,
+			Labels:         container.Config.Labels,
 		}
 	}
 
@@ -79,12 +81,12 @@ func TestTargetFilter(t *testing.T) {
 				imageFilter:            []*regexp.Regexp{},
 			},
 			expected: []DockerTarget{
-				genTarget("", "id1", "container1"),
-				genTarget("", "id2", "container2"),
-				genTarget("compose1", "id3", "container1"),
-				genTarget("compose1", "id4", "container2"),
-				genTarget("compose2", "id5", "container1"),
-				genTarget("compose2", "id6", "container2"),
+				genTarget("", "id1", "container1", "image1"),
+				genTarget("", "id2", "container2", "image1"),
+				genTarget("compose1", "id3", "container1", "image1"),
+				genTarget("compose1", "id4", "container2", "image2"),
+				genTarget("compose2", "id5", "container1", "image2"),
+				genTarget("compose2", "id6", "container2", "image2"),
 			},
 		},
 		{
@@ -99,12 +101,12 @@ func TestTargetFilter(t *testing.T) {
 				imageFilter:            []*regexp.Regexp{},
 			},
 			expected: []DockerTarget{
-				genTarget("", "id1", "container1"),
-				genTarget("", "id2", "container2"),
-				genTarget("compose1", "id3", "container1"),
-				genTarget("compose1", "id4", "container2"),
-				genTarget("compose2", "id5", "container1"),
-				genTarget("compose2", "id6", "container2"),
+				genTarget("", "id1", "container1", "image1"),
+				genTarget("", "id2", "container2", "image1"),
+				genTarget("compose1", "id3", "container1", "image1"),
+				genTarget("compose1", "id4", "container2", "image2"),
+				genTarget("compose2", "id5", "container1", "image2"),
+				genTarget("compose2", "id6", "container2", "image2"),
 			},
 		},
 		{
@@ -130,9 +132,9 @@ func TestTargetFilter(t *testing.T) {
 				imageFilter:            []*regexp.Regexp{},
 			},
 			expected: []DockerTarget{
-				genTarget("", "id2", "container2"),
-				genTarget("compose1", "id4", "container2"),
-				genTarget("compose2", "id6", "container2"),
+				genTarget("", "id2", "container2", "image1"),
+				genTarget("compose1", "id4", "container2", "image2"),
+				genTarget("compose2", "id6", "container2", "image2"),
 			},
 		},
 		{
@@ -147,9 +149,9 @@ func TestTargetFilter(t *testing.T) {
 				imageFilter:          []*regexp.Regexp{},
 			},
 			expected: []DockerTarget{
-				genTarget("", "id1", "container1"),
-				genTarget("compose1", "id3", "container1"),
-				genTarget("compose2", "id5", "container1"),
+				genTarget("", "id1", "container1", "image1"),
+				genTarget("compose1", "id3", "container1", "image1"),
+				genTarget("compose2", "id5", "container1", "image2"),
 			},
 		},
 		{
@@ -161,9 +163,9 @@ func TestTargetFilter(t *testing.T) {
 				imageFilter:            []*regexp.Regexp{regexp.MustCompile(`image1`)},
 			},
 			expected: []DockerTarget{
-				genTarget("", "id1", "container1"),
-				genTarget("", "id2", "container2"),
-				genTarget("compose1", "id3", "container1"),
+				genTarget("", "id1", "container1", "image1"),
+				genTarget("", "id2", "container2", "image1"),
+				genTarget("compose1", "id3", "container1", "image1"),
 			},
 		},
 		{
@@ -175,8 +177,8 @@ func TestTargetFilter(t *testing.T) {
 				imageFilter:            []*regexp.Regexp{},
 			},
 			expected: []DockerTarget{
-				genTarget("compose1", "id3", "container1"),
-				genTarget("compose1", "id4", "container2"),
+				genTarget("compose1", "id3", "container1", "image1"),
+				genTarget("compose1", "id4", "container2", "image2"),
 			},
 		},
 	}
@@ -226,11 +228,13 @@ func TestTargetFilterShouldAdd(t *testing.T) {
 			Id:          id,
 			Name:        name,
 			ServiceName: name,
+			Labels:      map[string]string{},
 		}
 	}
 	tests := []struct {
 		name      string
 		forget    bool
+		tailing   bool
 		container container.InspectResponse
 		expected  []DockerTarget
 	}{
@@ -249,6 +253,17 @@ func TestTargetFilterShouldAdd(t *testing.T) {
 			container: createContainer("id1", "c1", "2000-01-01T00:00:01+00:00"),
 			expected:  []DockerTarget{genTarget("id1", "c1", "2000-01-01T00:00:01+00:00", true)},
 		},
+		{
+			name:      "same container ID with new start time being tailed should be ignored",
+			tailing:   true,
+			container: createContainer("id1", "c1", "2000-01-01T00:00:02+00:00"),
+			expected:  []DockerTarget{},
+		},
+		{
+			name:      "same container ID with the start time seen while tailed should be ignored",
+			container: createContainer("id1", "c1", "2000-01-01T00:00:02+00:00"),
+			expected:  []DockerTarget{},
+		},
 		{
 			name:      "different container ID can be added",
 			container: createContainer("id2", "c2", "2000-01-01T00:00:00+00:00"),
@@ -266,6 +281,10 @@ func TestTargetFilterShouldAdd(t *testing.T) {
 			if tt.forget {
 				filter.inactive("id2")
 			}
+			if tt.tailing {
+				filter.tailing("id1", true)
+				defer filter.tailing("id1", false)
+			}
 			actual := []DockerTarget{}
 			filter.visit(tt.container, func(target *DockerTarget) {
 				actual = append(actual, *target)
@@ -276,3 +295,292 @@ func TestTargetFilterShouldAdd(t *testing.T) {
 		})
 	}
 }
+
+func TestTargetFilterProperties(t *testing.T) {
+	c := container.InspectResponse{
+		ContainerJSONBase: &container.ContainerJSONBase{
+			ID:   "id1",
+			Name: "/c1",
+			State: &container.State{
+				Status:    "running",
+				StartedAt: "2000-01-01T00:00:00+00:00",
+				Health:    &container.Health{Status: container.Unhealthy},
+			},
+		},
+		Config: &container.Config{Labels: map[string]string{}},
+		Mounts: []container.MountPoint{
+			{Name: "data", Source: "/var/lib/docker/volumes/data/_data", Destination: "/data"},
+			{Source: "/etc/app", Destination: "/config"},
+		},
+		NetworkSettings: &container.NetworkSettings{
+			NetworkSettingsBase: container.NetworkSettingsBase{
+				Ports: nat.PortMap{
+					"80/tcp":   []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "8080"}},
+					"53/udp":   []nat.PortBinding{{HostIP: "0.0.0.0", HostPort: "5353"}},
+					"9000/tcp": nil,
+				},
+			},
+			Networks: map[string]*network.EndpointSettings{
+				"shop_backend": {},
+			},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		config   dockerTargetFilterConfig
+		expected bool
+	}{
+		{"no filters", dockerTargetFilterConfig{}, true},
+		{"state", dockerTargetFilterConfig{stateFilter: []string{"paused", "running"}}, true},
+		{"state mismatch", dockerTargetFilterConfig{stateFilter: []string{"exited"}}, false},
+		{"health", dockerTargetFilterConfig{healthFilter: []string{"unhealthy"}}, true},
+		{"health mismatch", dockerTargetFilterConfig{healthFilter: []string{"healthy", "none"}}, false},
+		{"network", dockerTargetFilterConfig{networkFilter: []*regexp.Regexp{regexp.MustCompile(`_backend$`)}}, true},
+		{"network mismatch", dockerTargetFilterConfig{networkFilter: []*regexp.Regexp{regexp.MustCompile(`^bridge$`)}}, false},
+		{"volume name", dockerTargetFilterConfig{volumeFilter: []string{"data"}}, true},
+		{"volume destination", dockerTargetFilterConfig{volumeFilter: []string{"/config"}}, true},
+		{"volume source", dockerTargetFilterConfig{volumeFilter: []string{"/etc/app"}}, true},
+		{"volume mismatch", dockerTargetFilterConfig{volumeFilter: []string{"/etc"}}, false},
+		{"publish", dockerTargetFilterConfig{publishFilter: []string{"8080"}}, true},
+		{"publish protocol", dockerTargetFilterConfig{publishFilter: []string{"5353/udp"}}, true},
+		{"publish protocol mismatch", dockerTargetFilterConfig{publishFilter: []string{"5353/tcp"}}, false},
+		{"label", dockerTargetFilterConfig{labelFilter: []LabelSelector{{key: "team", operator: labelNotExists}}}, true},
+		{"label mismatch", dockerTargetFilterConfig{labelFilter: []LabelSelector{{key: "team", operator: labelExists}}}, false},
+		{"publish container port", dockerTargetFilterConfig{publishFilter: []string{"80", "9000"}}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := newDockerTargetFilter(tt.config, 10)
+			actual := false
+			filter.visit(c, func(*DockerTarget) { actual = true })
+			if actual != tt.expected {
+				t.Errorf("expected %v, but actual %v", tt.expected, actual)
+			}
+		})
+	}
+
+	t.Run("no health check", func(t *testing.T) {
+		filter := newDockerTargetFilter(dockerTargetFilterConfig{healthFilter: []string{"none"}}, 10)
+		noHealth := c
+		noHealth.ContainerJSONBase = &container.ContainerJSONBase{
+			ID:    "id2",
+			Name:  "/c2",
+			State: &container.State{Status: "running", StartedAt: "2000-01-01T00:00:00+00:00"},
+		}
+		actual := false
+		filter.visit(noHealth, func(*DockerTarget) { actual = true })
+		if !actual {
+			t.Errorf("expected a container without health check to match none")
+		}
+	})
+
+	t.Run("created", func(t *testing.T) {
+		created := c
+		created.ContainerJSONBase = &container.ContainerJSONBase{
+			ID:    "id3",
+			Name:  "/c3",
+			State: &container.State{Status: "created", StartedAt: "0001-01-01T00:00:00Z"},
+		}
+		for _, tt := range []struct {
+			stateFilter []string
+			expected    bool
+		}{
+			{nil, false},
+			{[]string{"running"}, false},
+			{[]string{"created"}, true},
+		} {
+			filter := newDockerTargetFilter(dockerTargetFilterConfig{stateFilter: tt.stateFilter}, 10)
+			actual := false
+			filter.visit(created, func(*DockerTarget) { actual = true })
+			if actual != tt.expected {
+				t.Errorf("state filter %v: expected %v, but actual %v", tt.stateFilter, tt.expected, actual)
+			}
+		}
+	})
+}
+
+func TestTargetFilterRevisit(t *testing.T) {
+	filter := newDockerTargetFilter(dockerTargetFilterConfig{healthFilter: []string{"healthy"}}, 10)
+	var stopped []string
+	filter.stopTail = func(containerId string) {
+		stopped = append(stopped, containerId)
+	}
+	withHealth := func(status string) container.InspectResponse {
+		return container.InspectResponse{
+			ContainerJSONBase: &container.ContainerJSONBase{
+				ID:   "id1",
+				Name: "/c1",
+				State: &container.State{
+					Status:    "running",
+					StartedAt: "2000-01-01T00:00:00+00:00",
+					Health:    &container.Health{Status: status},
+				},
+			},
+			Config: &container.Config{Labels: map[string]string{}},
+		}
+	}
+
+	added := 0
+	visitor := func(*DockerTarget) { added++ }
+	filter.revisit(withHealth("starting"), visitor)
+	filter.revisit(withHealth("healthy"), visitor)
+	filter.revisit(withHealth("healthy"), visitor)
+	if added != 1 || len(stopped) != 0 {
+		t.Fatalf("expected 1 added and 0 stopped, but actual %d and %v", added, stopped)
+	}
+
+	filter.revisit(withHealth("unhealthy"), visitor)
+	if !reflect.DeepEqual(stopped, []string{"id1"}) {
+		t.Errorf("expected id1 to be stopped, but actual %v", stopped)
+	}
+	if filter.isActive(&DockerTarget{Id: "id1"}) {
+		t.Errorf("expected id1 to be inactive")
+	}
+	filter.revisit(withHealth("unhealthy"), visitor)
+	if len(stopped) != 1 {
+		t.Errorf("expected an inactive container not to be stopped again, but actual %v", stopped)
+	}
+
+	filter.revisit(withHealth("healthy"), visitor)
+	if added != 2 {
+		t.Errorf("expected the healthy container to be added again, but actual %d", added)
+	}
+}
+
+func TestTargetFilterRetain(t *testing.T) {
+	filter := newDockerTargetFilter(dockerTargetFilterConfig{
+		containerFilter: []*regexp.Regexp{regexp.MustCompile(`.*`)},
+	}, 10)
+	for _, id := range []string{"id1", "id2", "id3"} {
+		filter.visit(container.InspectResponse{
+			ContainerJSONBase: &container.ContainerJSONBase{
+				ID:    id,
+				Name:  id,
+				State: &container.State{StartedAt: "2000-01-01T00:00:00+00:00"},
+			},
+			Config: &container.Config{Labels: map[string]string{}},
+		}, func(*DockerTarget) {})
+		filter.setResumeRequest(id, &ResumeRequest{Timestamp: "2000-01-01T00:00:01Z", LinesToSkip: 1})
+	}
+
+	filter.retain(map[string]bool{"id1": true, "id3": true})
+
+	for id, expected := range map[string]bool{"id1": true, "id2": false, "id3": true} {
+		if actual := filter.isActive(&DockerTarget{Id: id}); actual != expected {
+			t.Errorf("%s: expected active %v, but actual %v", id, expected, actual)
+		}
+		if _, actual := filter.seenContainers.Get(id); actual != expected {
+			t.Errorf("%s: expected seen %v, but actual %v", id, expected, actual)
+		}
+	}
+}
+
+func TestTargetFilterService(t *testing.T) {
+	createService := func(stack, id, name, image string) swarm.Service {
+		labels := map[string]string{}
+		serviceName := name
+		if stack != "" {
+			labels["com.docker.stack.namespace"] = stack
+			serviceName = stack + "_" + name
+		}
+		return swarm.Service{
+			ID: id,
+			Spec: swarm.ServiceSpec{
+				Annotations: swarm.Annotations{
+					Name:   serviceName,
+					Labels: labels,
+				},
+				TaskTemplate: swarm.TaskSpec{
+					ContainerSpec: &swarm.ContainerSpec{Image: image},
+				},
+			},
+		}
+	}
+
+	services := []swarm.Service{
+		createService("", "id1", "service1", "image1"),
+		createService("stack1", "id2", "service1", "image1"),
+		createService("stack1", "id3", "service2", "image2"),
+		createService("stack2", "id4", "service1", "image2"),
+		{ID: "id5", Spec: swarm.ServiceSpec{Annotations: swarm.Annotations{Name: "plugin"}}},
+	}
+
+	genTarget := func(stack, id, name, image string) DockerTarget {
+		service := createService(stack, id, name, image)
+		return DockerTarget{
+			Id:             id,
+			Name:           service.Spec.Name,
+			ServiceName:    name,
+			ComposeProject: stack,
+			Swarm:          true,
+			Image:          image,
+			Labels:         service.Spec.Labels,
+		}
+	}
+
+	tests := []struct {
+		name     string
+		config   dockerTargetFilterConfig
+		expected []DockerTarget
+	}{
+		{
+			name: "match all",
+			config: dockerTargetFilterConfig{
+				serviceFilter: []*regexp.Regexp{regexp.MustCompile(`.*`)},
+			},
+			expected: []DockerTarget{
+				genTarget("", "id1", "service1", "image1"),
+				genTarget("stack1", "id2", "service1", "image1"),
+				genTarget("stack1", "id3", "service2", "image2"),
+				genTarget("stack2", "id4", "service1", "image2"),
+			},
+		},
+		{
+			name: "filter by serviceFilter",
+			config: dockerTargetFilterConfig{
+				serviceFilter: []*regexp.Regexp{regexp.MustCompile(`stack1_`)},
+			},
+			expected: []DockerTarget{
+				genTarget("stack1", "id2", "service1", "image1"),
+				genTarget("stack1", "id3", "service2", "image2"),
+			},
+		},
+		{
+			name: "filter by stackFilter",
+			config: dockerTargetFilterConfig{
+				stackFilter: []*regexp.Regexp{regexp.MustCompile(`stack2`)},
+			},
+			expected: []DockerTarget{
+				genTarget("stack2", "id4", "service1", "image2"),
+			},
+		},
+		{
+			name: "filter by excludeFilter and imageFilter",
+			config: dockerTargetFilterConfig{
+				containerExcludeFilter: []*regexp.Regexp{regexp.MustCompile(`service2`)},
+				imageFilter:            []*regexp.Regexp{regexp.MustCompile(`image2`)},
+			},
+			expected: []DockerTarget{
+				genTarget("stack2", "id4", "service1", "image2"),
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := []DockerTarget{}
+			filter := newDockerTargetFilter(tt.config, 10)
+			for _, service := range services {
+				filter.visitService(service, func(target *DockerTarget) {
+					actual = append(actual, *target)
+				})
+			}
+
+			if !reflect.DeepEqual(tt.expected, actual) {
+				t.Errorf("expected %v, but actual %v", tt.expected, actual)
+			}
+		})
+	}
+}
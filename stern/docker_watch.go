@@ -3,69 +3,208 @@ package stern
 import (
 	"context"
 	"fmt"
+	"io"
+	"slices"
+	"strings"
+	"time"
 
 	"github.com/containerd/log"
+	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/api/types/events"
 	"github.com/docker/docker/api/types/filters"
 	dockerclient "github.com/docker/docker/client"
 )
 
-func WatchDockers(ctx context.Context, config *DockerConfig, filter *dockerTargetFilter, client *dockerclient.Client) (chan *DockerTarget, error) {
+// Backoff when reconnecting to dockerd after the event stream dropped
+var (
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 30 * time.Second
+)
+
+func WatchDockers(
+	ctx context.Context,
+	config *DockerConfig,
+	filter *dockerTargetFilter,
+	client *dockerclient.Client,
+	printer *eventPrinter,
+) (chan *DockerTarget, error) {
 	added := make(chan *DockerTarget)
 	go func() {
+		defer close(added)
 		visitor := func(t *DockerTarget) {
 			log.L.WithFields(log.Fields{"id": t.Id, "name": t.Name}).Info("Active container")
 			added <- t
 		}
-		ctx, cancel := context.WithCancel(ctx)
-		defer cancel()
 
-		// Start watching for container events
-		args := filters.NewArgs()
-		args.Add("event", string(events.ActionDie))
-		args.Add("event", string(events.ActionStart))
-		args.Add("event", string(events.ActionDestroy))
-		for _, label := range config.Label {
-			args.Add("label", label)
-		}
-		opts := events.ListOptions{Filters: args}
-		watcher, errc := client.Events(ctx, opts)
+		var since time.Time
+		reconnect(ctx, config.ErrOut, func() error {
+			return watchDockers(ctx, config, filter, client, printer, &since, visitor)
+		})
+	}()
+	return added, nil
+}
+
+// watchDockers lists the containers and watches for container events until the context is done or the event stream
+// drops. since is the time of the last seen event and is used to catch up on missed events when reconnecting.
+func watchDockers(
+	ctx context.Context,
+	config *DockerConfig,
+	filter *dockerTargetFilter,
+	client *dockerclient.Client,
+	printer *eventPrinter,
+	since *time.Time,
+	visitor func(t *DockerTarget),
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Start watching for container events
+	args := filters.NewArgs()
+	args.Add("type", string(events.ContainerEventType))
+	args.Add("event", string(events.ActionDie))
+	args.Add("event", string(events.ActionStart))
+	args.Add("event", string(events.ActionDestroy))
+	args.Add("event", string(podmanActionDied))
+	args.Add("event", string(podmanActionRemove))
+	args.Add("event", string(events.ActionHealthStatus))
+	args.Add("event", string(events.ActionPause))
+	args.Add("event", string(events.ActionUnPause))
+	if slices.Contains(config.StateQuery, "created") {
+		args.Add("event", string(events.ActionCreate))
+	}
+	addLabelFilters(args, config.Label)
+	opts := events.ListOptions{Filters: args}
+	reconnecting := !since.IsZero()
+	if reconnecting {
+		opts.Since = formatEventTime(*since)
+	} else {
+		*since = time.Now()
+	}
+	watcher, errc := client.Events(ctx, opts)
+
+	// Network events are watched separately as the label filter would be applied to the network
+	var networkWatcher <-chan events.Message
+	var networkErrc <-chan error
+	if len(config.NetworkQuery) > 0 {
+		networkArgs := filters.NewArgs()
+		networkArgs.Add("type", string(events.NetworkEventType))
+		networkArgs.Add("event", string(events.ActionConnect))
+		networkArgs.Add("event", string(events.ActionDisconnect))
+		networkWatcher, networkErrc = client.Events(ctx, events.ListOptions{Since: opts.Since, Filters: networkArgs})
+	}
 
-		// Then list all current containers
-		containers, err := ContainerGenerator(ctx, config, client)
+	// Then list all current containers
+	containers, err := ContainerGenerator(ctx, config, client)
+	if err != nil {
+		return err
+	}
+	listed := make(map[string]bool)
+	for target := range containers {
+		listed[target.ID] = true
+		filter.visit(target, visitor)
+	}
+	if reconnecting {
+		// Containers removed while disconnected
+		filter.retain(listed)
+	}
+
+	inspect := func(id string) (container.InspectResponse, bool) {
+		log.L.WithField("id", id).Info("Inspect container")
+		container, err := client.ContainerInspect(ctx, id)
 		if err != nil {
-			return
-		}
-		for target := range containers {
-			filter.visit(target, visitor)
+			log.L.WithField("id", id).Error(err, ": failed to inspect container")
+			return container, false
 		}
+		return container, true
+	}
 
-		for {
-			select {
-			case e := <-watcher:
-				switch e.Action {
-				case events.ActionStart:
-					log.L.WithField("id", e.Actor.ID).Info("Inspect container")
-					container, err := client.ContainerInspect(ctx, e.Actor.ID)
-					if err != nil {
-						log.L.WithField("id", e.Actor.ID).Error(err, ": failed to inspect container")
-						continue
-					}
-					filter.visit(container, visitor)
-				case events.ActionDie:
+	for {
+		select {
+		case e := <-watcher:
+			*since = time.Unix(0, e.TimeNano)
+			e = normalizeEvent(e)
+			switch e.Action {
+			case events.ActionStart:
+				container, ok := inspect(e.Actor.ID)
+				if !ok {
+					continue
+				}
+				// A listed container that no longer matches, e.g. an exited container with --state exited, is deselected
+				filter.revisit(container, visitor)
+				if container.RestartCount > 0 && filter.isActive(&DockerTarget{Id: e.Actor.ID}) {
+					printer.print(newRestartEvent(e, container.RestartCount))
+				}
+			case events.ActionDie:
+				if filter.isActive(&DockerTarget{Id: e.Actor.ID}) {
+					printer.printDie(ctx, client, e)
 					filter.inactive(e.Actor.ID)
-				case events.ActionDestroy:
-					filter.forget(e.Actor.ID)
+					continue
+				}
+				// A container that did not match while running may match once it exited, e.g. with --state exited
+				if container, ok := inspect(e.Actor.ID); ok {
+					filter.visit(container, visitor)
+				}
+			case events.ActionCreate:
+				if container, ok := inspect(e.Actor.ID); ok {
+					filter.visit(container, visitor)
+				}
+			case events.ActionDestroy:
+				filter.forget(e.Actor.ID)
+			case events.ActionPause, events.ActionUnPause:
+				if container, ok := inspect(e.Actor.ID); ok {
+					filter.revisit(container, visitor)
+				}
+			default:
+				if !strings.HasPrefix(string(e.Action), string(events.ActionHealthStatus)) {
+					continue
+				}
+				if filter.isActive(&DockerTarget{Id: e.Actor.ID}) {
+					printer.print(newHealthEvent(e))
+				}
+				if container, ok := inspect(e.Actor.ID); ok {
+					filter.revisit(container, visitor)
 				}
-			case <-ctx.Done():
-				close(added)
-				return
-			case err := <-errc:
-				fmt.Fprintf(config.ErrOut, "dockerd error: %v\n", err)
-				close(added)
-				return
 			}
+		case e := <-networkWatcher:
+			*since = time.Unix(0, e.TimeNano)
+			if container, ok := inspect(e.Actor.Attributes["container"]); ok {
+				filter.revisit(container, visitor)
+			}
+		case <-ctx.Done():
+			return nil
+		case err := <-errc:
+			return err
+		case err := <-networkErrc:
+			return err
 		}
-	}()
-	return added, nil
+	}
+}
+
+// reconnect runs watch until the context is done, retrying with exponential backoff when the connection to dockerd is
+// lost.
+func reconnect(ctx context.Context, errOut io.Writer, watch func() error) {
+	delay := minReconnectDelay
+	for {
+		connected := time.Now()
+		err := watch()
+		if ctx.Err() != nil {
+			return
+		}
+		if time.Since(connected) > maxReconnectDelay {
+			// The connection was healthy for a while, start over
+			delay = minReconnectDelay
+		}
+		fmt.Fprintf(errOut, "dockerd error: %v, reconnecting in %s\n", err, delay)
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return
+		}
+		delay = min(delay*2, maxReconnectDelay)
+	}
+}
+
+// formatEventTime formats the time as the seconds.nanoseconds timestamp used by the events API
+func formatEventTime(t time.Time) string {
+	return fmt.Sprintf("%d.%09d", t.Unix(), t.Nanosecond())
 }
@@ -0,0 +1,115 @@
+package stern
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+)
+
+func TestReconnect(t *testing.T) {
+	origMin, origMax := minReconnectDelay, maxReconnectDelay
+	minReconnectDelay, maxReconnectDelay = time.Millisecond, 4*time.Millisecond
+	defer func() {
+		minReconnectDelay, maxReconnectDelay = origMin, origMax
+	}()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errOut := new(bytes.Buffer)
+	attempts := 0
+	reconnect(ctx, errOut, func() error {
+		attempts++
+		if attempts == 5 {
+			cancel()
+			return context.Canceled
+		}
+		return errors.New("unexpected EOF")
+	})
+
+	if attempts != 5 {
+		t.Errorf("expected 5 attempts, but actual %d", attempts)
+	}
+	expected := []string{
+		"dockerd error: unexpected EOF, reconnecting in 1ms",
+		"dockerd error: unexpected EOF, reconnecting in 2ms",
+		"dockerd error: unexpected EOF, reconnecting in 4ms",
+		"dockerd error: unexpected EOF, reconnecting in 4ms",
+	}
+	if actual := strings.Split(strings.TrimSpace(errOut.String()), "\n"); strings.Join(actual, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("expected %q, but actual %q", expected, actual)
+	}
+}
+
+func TestFormatEventTime(t *testing.T) {
+	ts := time.Date(2023, 2, 13, 21, 20, 30, 1500, time.UTC)
+	if actual, expected := formatEventTime(ts), "1676323230.000001500"; actual != expected {
+		t.Errorf("expected %q, but actual %q", expected, actual)
+	}
+}
+
+func TestWatchDockersStateChange(t *testing.T) {
+	dockerd, client := newFakeDockerd(t)
+	dockerd.addContainer("c1", "web")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := &DockerConfig{ErrOut: io.Discard}
+	filter := newDockerTargetFilter(dockerTargetFilterConfig{stateFilter: []string{container.StateExited}}, 100)
+	added, err := WatchDockers(ctx, config, filter, client, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The running container is not added until it exits
+	dockerd.setState("c1", container.StateExited)
+	dockerd.sendEvent("c1", events.ActionDie)
+	select {
+	case target := <-added:
+		if target.Id != "c1" {
+			t.Errorf("expected c1, but actual %s", target.Id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the exited container")
+	}
+
+	// The restarted container no longer matches
+	dockerd.setState("c1", container.StateRunning)
+	dockerd.sendEvent("c1", events.ActionStart)
+	for deadline := time.Now().Add(5 * time.Second); filter.isActive(&DockerTarget{Id: "c1"}); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the restarted container to be deselected")
+		}
+	}
+}
+
+func TestWatchDockersCreated(t *testing.T) {
+	dockerd, client := newFakeDockerd(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	config := &DockerConfig{ErrOut: io.Discard, StateQuery: []string{container.StateCreated}}
+	filter := newDockerTargetFilter(dockerTargetFilterConfig{stateFilter: config.StateQuery}, 100)
+	added, err := WatchDockers(ctx, config, filter, client, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dockerd.addContainer("c1", "web")
+	dockerd.setState("c1", container.StateCreated)
+	dockerd.sendEvent("c1", events.ActionCreate)
+	select {
+	case target := <-added:
+		if target.Id != "c1" {
+			t.Errorf("expected c1, but actual %s", target.Id)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the created container")
+	}
+}
@@ -5,19 +5,46 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"regexp"
+	"slices"
 	"strings"
 	"text/template"
+	"time"
 
 	"github.com/containerd/log"
 	"github.com/fatih/color"
 )
 
+// Formats of the --stdin input
+const (
+	InputFormatText    = "text"
+	InputFormatCompose = "compose" // output of docker compose logs, prefixed by the container like "web-1  | "
+)
+
+// composePrefix matches the container prefix of docker compose logs, which is colored when written to a terminal
+var composePrefix = regexp.MustCompile(`^(?:\x1b\[[0-9;]*m)*([^\s|\x1b]+)\s*\|(?:\x1b\[[0-9;]*m)* ?`)
+
+// composeContainerName splits the name of a container created by Compose, like web-1 or shop_web_1 (Compose v1), into
+// the service and the container number
+var composeContainerName = regexp.MustCompile(`^(.+)[-_](\d+)$`)
+
 type FileTail struct {
-	Options *TailOptions
-	tmpl    *template.Template
-	in      io.Reader
-	out     io.Writer
-	errOut  io.Writer
+	Options   *TailOptions
+	tmpl      *template.Template
+	in        io.Reader
+	out       io.Writer
+	errOut    io.Writer
+	multiline *multilineGrouper
+
+	// Set for the compose input format
+	inputFormat            string
+	containerFilter        []*regexp.Regexp
+	containerExcludeFilter []*regexp.Regexp
+
+	// Set when the lines carry timestamps
+	timestampFormat string
+	sorter          *timeSorter
+	last            time.Time // time of the last line with a timestamp
 }
 
 // NewFileTail returns a new tail of the input reader
@@ -42,6 +69,8 @@ func (t *FileTail) Start() error {
 // ConsumeReader reads the data from the reader and writes into the out
 // writer.
 func (t *FileTail) ConsumeReader(reader *bufio.Reader) error {
+	t.multiline = newMultilineGrouper(t.Options, t.consumeEntry)
+	defer t.multiline.flush()
 	for {
 		line, err := reader.ReadBytes('\n')
 		if len(line) != 0 {
@@ -59,16 +88,24 @@ func (t *FileTail) ConsumeReader(reader *bufio.Reader) error {
 
 // Print prints a color coded log message
 func (t *FileTail) Print(msg string) {
-	vm := Log{
+	t.printLog(time.Time{}, Log{
 		Message:        msg,
 		ContainerName:  "",
 		ContainerColor: color.New(color.Reset),
-	}
+	})
+}
 
+// printLog prints the log using the template. Logs with a timestamp are passed through the time sorter if enabled.
+func (t *FileTail) printLog(timestamp time.Time, vm Log) {
+	vm.Timestamp = t.Options.logTimestamp(timestamp)
 	var buf bytes.Buffer
 	if err := t.tmpl.Execute(&buf, vm); err != nil {
 		fmt.Fprintf(t.errOut, "expanding template failed: %s\n", err)
-		log.L.WithField("error", err).WithField("message", msg).Error("Template failure")
+		log.L.WithField("error", err).WithField("message", vm.Message).Error("Template failure")
+		return
+	}
+	if t.sorter != nil && !timestamp.IsZero() {
+		t.sorter.add(timestamp, buf.String())
 		return
 	}
 
@@ -76,12 +113,92 @@ func (t *FileTail) Print(msg string) {
 }
 
 func (t *FileTail) consumeLine(line string) {
-	content := line
+	var container string
+	if t.inputFormat == InputFormatCompose {
+		// Lines without a prefix, like "Attaching to web-1", are passed on as they are
+		if m := composePrefix.FindStringSubmatchIndex(line); m != nil {
+			container = line[m[2]:m[3]]
+			line = line[m[1]:]
+			if !t.matchesContainer(container) {
+				return
+			}
+		}
+	}
+	var timestamp string
+	if t.timestampFormat != "" {
+		if ts, rest, ok := parseLineTimestamp(t.timestampFormat, line, t.Options.Location); ok {
+			t.last = ts
+			line = rest
+		}
+		// Lines without a timestamp, like the continuation lines of a stack trace, belong to the previous line
+		if !t.last.IsZero() {
+			timestamp = t.last.Format(time.RFC3339Nano)
+			if t.Options.IsBeforeSince(timestamp) || t.Options.IsAfterUntil(timestamp) {
+				return
+			}
+		}
+	}
+	t.multiline.add(logEntry{timestamp: timestamp, container: container, content: line})
+}
+
+// matchesContainer returns true if the service name of the container matches the container filters, as when tailing
+// Compose containers
+func (t *FileTail) matchesContainer(container string) bool {
+	service, _ := splitComposeContainerName(container)
+	if len(t.containerFilter) > 0 && !slices.ContainsFunc(t.containerFilter, func(re *regexp.Regexp) bool {
+		return re.MatchString(service)
+	}) {
+		return false
+	}
+	return !slices.ContainsFunc(t.containerExcludeFilter, func(re *regexp.Regexp) bool {
+		return re.MatchString(service)
+	})
+}
 
-	if t.Options.IsExclude(content) || !t.Options.IsInclude(content) {
+// splitComposeContainerName returns the service name and container number of a container created by Compose, or the
+// container name without a number for other containers
+func splitComposeContainerName(container string) (string, string) {
+	if m := composeContainerName.FindStringSubmatch(container); m != nil {
+		return m[1], m[2]
+	}
+	return container, ""
+}
+
+func (t *FileTail) consumeEntry(e logEntry) {
+	if t.Options.IsExclude(e.content) || !t.Options.IsInclude(e.content) || t.Options.IsBelowLevel(e.content) {
+		return
+	}
+
+	msg := t.Options.HighlightMatchedString(e.content)
+	var timestamp time.Time
+	if e.timestamp != "" {
+		timestamp, _ = time.Parse(time.RFC3339Nano, e.timestamp)
+		if t.Options.Timestamps {
+			updatedTs, err := t.Options.UpdateTimezoneAndFormat(e.timestamp)
+			if err != nil {
+				t.Print(fmt.Sprintf("[%v] %s %s", err, e.timestamp, e.content))
+				return
+			}
+			msg = updatedTs + " " + msg
+		}
+	}
+	if e.container == "" {
+		t.printLog(timestamp, Log{
+			Message:        msg,
+			ContainerName:  "",
+			ContainerColor: color.New(color.Reset),
+		})
 		return
 	}
 
-	msg := t.Options.HighlightMatchedString(content)
-	t.Print(msg)
+	service, number := splitComposeContainerName(e.container)
+	namespaceColor, containerColor := determineDockerColor(e.container, "")
+	t.printLog(timestamp, Log{
+		Message:         msg,
+		ContainerName:   e.container,
+		ServiceName:     service,
+		ContainerNumber: number,
+		NamespaceColor:  namespaceColor,
+		ContainerColor:  containerColor,
+	})
 }
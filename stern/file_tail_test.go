@@ -4,9 +4,11 @@ import (
 	"bufio"
 	"bytes"
 	"io"
+	"regexp"
 	"strings"
 	"testing"
 	"text/template"
+	"time"
 )
 
 func TestConsumeFileTail(t *testing.T) {
@@ -44,3 +46,118 @@ line 4
 		})
 	}
 }
+
+func TestConsumeFileTailCompose(t *testing.T) {
+	logLines := "Attaching to db-1, web-1, web-2\n" +
+		"\x1b[36mweb-1  |\x1b[0m GET /\n" +
+		"db-1   | ready\n" +
+		"web-2  | GET /health\n" +
+		"shop_worker_1 | done\n" +
+		"web-1  | at main.go:10"
+	tmpl := template.Must(template.New("").Parse(
+		`{{printf "%s/%s/%s/%s\n" .ContainerName .ServiceName .ContainerNumber .Message}}`))
+
+	tests := []struct {
+		name     string
+		include  []*regexp.Regexp
+		exclude  []*regexp.Regexp
+		expected string
+	}{
+		{
+			name: "all",
+			expected: `///Attaching to db-1, web-1, web-2
+web-1/web/1/GET /
+db-1/db/1/ready
+web-2/web/2/GET /health
+shop_worker_1/shop_worker/1/done
+web-1/web/1/at main.go:10
+`,
+		},
+		{
+			name:    "container filters",
+			include: []*regexp.Regexp{regexp.MustCompile(`^web$`), regexp.MustCompile(`^db`)},
+			exclude: []*regexp.Regexp{regexp.MustCompile(`^db$`)},
+			expected: `///Attaching to db-1, web-1, web-2
+web-1/web/1/GET /
+web-2/web/2/GET /health
+web-1/web/1/at main.go:10
+`,
+		},
+		{
+			name:    "anchored exclude",
+			exclude: []*regexp.Regexp{regexp.MustCompile(`^web$`)},
+			expected: `///Attaching to db-1, web-1, web-2
+db-1/db/1/ready
+shop_worker_1/shop_worker/1/done
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := new(bytes.Buffer)
+			tail := NewFileTail(tmpl, nil, out, io.Discard, &TailOptions{})
+			tail.inputFormat = InputFormatCompose
+			tail.containerFilter = tt.include
+			tail.containerExcludeFilter = tt.exclude
+			if err := tail.ConsumeReader(bufio.NewReader(strings.NewReader(logLines))); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.expected {
+				t.Errorf("expected %q, but actual %q", tt.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestConsumeFileTailComposeMultiline(t *testing.T) {
+	logLines := `web-1  | panic: oops
+web-2  | GET /
+web-1  |   at main.go:10
+`
+	tmpl := template.Must(template.New("").Parse(`{{printf "%s: %q\n" .ContainerName .Message}}`))
+	out := new(bytes.Buffer)
+	tail := NewFileTail(tmpl, nil, out, io.Discard, &TailOptions{MultilinePattern: regexp.MustCompile(`^\s`)})
+	tail.inputFormat = InputFormatCompose
+	if err := tail.ConsumeReader(bufio.NewReader(strings.NewReader(logLines))); err != nil {
+		t.Fatal(err)
+	}
+	expected := "web-1: \"panic: oops\\n  at main.go:10\"\nweb-2: \"GET /\"\n"
+	if out.String() != expected {
+		t.Errorf("expected %q, but actual %q", expected, out.String())
+	}
+}
+
+func TestConsumeFileTailTimestamps(t *testing.T) {
+	logLines := `2024-05-01T10:00:02Z web started
+2024-05-01T09:59:00Z too early
+panic: oops
+  at main.go:10
+2024-05-01T10:00:01Z cron started
+2024-05-01T10:00:03Z web stopping
+`
+	tmpl := template.Must(template.New("").Parse(`{{printf "%s\n" .Message}}`))
+	out := new(bytes.Buffer)
+	sorter := newTimeSorter(out, 0)
+	tail := NewFileTail(tmpl, nil, out, io.Discard, &TailOptions{
+		Timestamps:      true,
+		TimestampFormat: TimestampFormatShort,
+		Location:        time.UTC,
+		SinceTime:       time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+	})
+	tail.timestampFormat = StdinTimestampFormatRFC3339
+	tail.sorter = sorter
+	if err := tail.ConsumeReader(bufio.NewReader(strings.NewReader(logLines))); err != nil {
+		t.Fatal(err)
+	}
+	sorter.flush()
+
+	// The lines without a timestamp are dropped with the line before them
+	expected := `05-01 10:00:01 cron started
+05-01 10:00:02 web started
+05-01 10:00:03 web stopping
+`
+	if out.String() != expected {
+		t.Errorf("expected %q, but actual %q", expected, out.String())
+	}
+}
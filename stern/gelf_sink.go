@@ -0,0 +1,128 @@
+package stern
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/rand/v2"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	gelfChunkSize = 1420 // bytes of the UDP packets, to fit the MTU of most networks
+	gelfMaxChunks = 128
+)
+
+// gelfChunkMagic starts the header of a chunk, followed by the message ID, the sequence number, and the count
+var gelfChunkMagic = []byte{0x1e, 0x0f}
+
+// newGELFSink returns a started sink sending GELF messages to the Graylog input at u. Messages are gzipped and
+// chunked over UDP, and null-byte delimited over TCP.
+func newGELFSink(u *url.URL, errOut io.Writer) *connSink {
+	hostname := sinkHostname()
+	udp := u.Scheme == "udp"
+	return newConnSink(SinkGELF, u, func(vm Log) ([][]byte, error) {
+		if strings.TrimSpace(vm.Message) == "" {
+			return nil, nil // GELF messages cannot be empty
+		}
+		msg, err := json.Marshal(gelfMessage(vm, hostname, time.Now()))
+		if err != nil {
+			return nil, err
+		}
+		if udp {
+			return gelfChunks(msg)
+		}
+		return [][]byte{append(msg, 0)}, nil
+	}, errOut)
+}
+
+// gelfMessage returns the GELF message of the line. The first line of multiline events is the short message. The
+// container metadata and the labels not set by Docker are additional fields, e.g. _container_name and
+// _app.kubernetes.io_name. Lines without a timestamp get now.
+func gelfMessage(vm Log, hostname string, now time.Time) map[string]any {
+	timestamp := vm.Timestamp
+	if timestamp.IsZero() {
+		timestamp = now
+	}
+	msg := map[string]any{
+		"version":   "1.1",
+		"host":      hostname,
+		"timestamp": float64(timestamp.UnixMicro()) / 1e6,
+		"level":     syslogSeverity(vm),
+	}
+	if short, _, multiline := strings.Cut(vm.Message, "\n"); multiline {
+		msg["short_message"] = short
+		msg["full_message"] = vm.Message
+	} else {
+		msg["short_message"] = vm.Message
+	}
+
+	set := func(name, value string) {
+		// _id is reserved by Graylog
+		if value != "" && name != "_id" {
+			msg[name] = value
+		}
+	}
+	for k, v := range sinkLabels(vm.Labels) {
+		set("_"+gelfFieldName(k), v)
+	}
+	set("_container_name", vm.ContainerName)
+	set("_container_id", vm.ContainerID)
+	set("_image_name", vm.Image)
+	set("_service", vm.ServiceName)
+	set("_namespace", vm.Namespace)
+	set("_number", vm.ContainerNumber)
+	set("_stream", vm.Stream)
+	set("_context", vm.Context)
+	return msg
+}
+
+// gelfFieldName replaces the characters that are not allowed in field names, e.g. app.kubernetes.io/name becomes
+// app.kubernetes.io_name
+func gelfFieldName(name string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '_' || r == '.' || r == '-' || (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') {
+			return r
+		}
+		return '_'
+	}, name)
+}
+
+// gelfChunks gzips the message and splits it into chunks when it does not fit a packet
+func gelfChunks(msg []byte) ([][]byte, error) {
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	if _, err := zw.Write(msg); err != nil {
+		return nil, err
+	}
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	data := buf.Bytes()
+	if len(data) <= gelfChunkSize {
+		return [][]byte{data}, nil
+	}
+
+	header := len(gelfChunkMagic) + 8 + 2
+	size := gelfChunkSize - header
+	count := (len(data) + size - 1) / size
+	if count > gelfMaxChunks {
+		return nil, fmt.Errorf("message of %d bytes exceeds %d chunks", len(msg), gelfMaxChunks)
+	}
+	id := rand.Uint64()
+	chunks := make([][]byte, 0, count)
+	for i := range count {
+		chunk := make([]byte, 0, gelfChunkSize)
+		chunk = append(chunk, gelfChunkMagic...)
+		chunk = binary.BigEndian.AppendUint64(chunk, id)
+		chunk = append(chunk, byte(i), byte(count))
+		chunk = append(chunk, data[i*size:min((i+1)*size, len(data))]...)
+		chunks = append(chunks, chunk)
+	}
+	return chunks, nil
+}
@@ -0,0 +1,211 @@
+package stern
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"math/rand/v2"
+	"net"
+	"net/url"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestGELFMessage(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		vm       Log
+		expected map[string]any
+	}{
+		{
+			Log{
+				Message:         `{"level":"error","msg":"failed"}`,
+				ContainerName:   "shop-web-1",
+				ServiceName:     "web",
+				Namespace:       "shop",
+				ContainerNumber: "1",
+				Stream:          StreamStdout,
+				Timestamp:       time.Date(2024, 5, 1, 9, 59, 59, 250000000, time.UTC),
+				ContainerID:     "8f7a1c",
+				Image:           "shop/web:1.2",
+				Labels: map[string]string{
+					"com.docker.compose.service": "web",
+					"app.kubernetes.io/name":     "web",
+					"id":                         "reserved",
+					"service":                    "overridden",
+				},
+			},
+			map[string]any{
+				"version":                 "1.1",
+				"host":                    "myhost",
+				"short_message":           `{"level":"error","msg":"failed"}`,
+				"timestamp":               1714557599.25,
+				"level":                   severityError,
+				"_container_name":         "shop-web-1",
+				"_container_id":           "8f7a1c",
+				"_image_name":             "shop/web:1.2",
+				"_service":                "web",
+				"_namespace":              "shop",
+				"_number":                 "1",
+				"_stream":                 "stdout",
+				"_app.kubernetes.io_name": "web",
+			},
+		},
+		{
+			Log{Message: "panic: oops\n\ngoroutine 1", ContainerName: "nginx", Stream: StreamStderr},
+			map[string]any{
+				"version":         "1.1",
+				"host":            "myhost",
+				"short_message":   "panic: oops",
+				"full_message":    "panic: oops\n\ngoroutine 1",
+				"timestamp":       1714557600.0,
+				"level":           severityError,
+				"_container_name": "nginx",
+				"_stream":         "stderr",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if actual := gelfMessage(tt.vm, "myhost", now); !reflect.DeepEqual(tt.expected, actual) {
+			t.Errorf("expected %v, but actual %v", tt.expected, actual)
+		}
+	}
+}
+
+func TestGELFChunks(t *testing.T) {
+	small := []byte(`{"short_message":"line 1"}`)
+	chunks, err := gelfChunks(small)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(chunks) != 1 || !bytes.Equal(gunzip(t, chunks[0]), small) {
+		t.Errorf("expected a single gzipped packet, but actual %q", chunks)
+	}
+
+	// Random hex does not compress well
+	random := make([]byte, 5000)
+	for i := range random {
+		random[i] = byte(rand.N(256))
+	}
+	large := []byte(hex.EncodeToString(random))
+	chunks, err = gelfChunks(large)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if len(chunks) < 2 {
+		t.Fatalf("expected multiple chunks, but actual %d", len(chunks))
+	}
+	var data []byte
+	for i, chunk := range chunks {
+		if len(chunk) > gelfChunkSize {
+			t.Errorf("chunk %d: expected at most %d bytes, but actual %d", i, gelfChunkSize, len(chunk))
+		}
+		if !bytes.Equal(chunk[:2], gelfChunkMagic) || !bytes.Equal(chunk[2:10], chunks[0][2:10]) ||
+			chunk[10] != byte(i) || chunk[11] != byte(len(chunks)) {
+			t.Errorf("chunk %d: unexpected header %x", i, chunk[:12])
+		}
+		data = append(data, chunk[12:]...)
+	}
+	if !bytes.Equal(gunzip(t, data), large) {
+		t.Errorf("expected the chunks to reassemble the message")
+	}
+}
+
+func gunzip(t *testing.T, data []byte) []byte {
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	msg, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	return msg
+}
+
+func TestGELFSink(t *testing.T) {
+	t.Run("udp", func(t *testing.T) {
+		conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer conn.Close()
+
+		errOut := new(bytes.Buffer)
+		s := newGELFSink(&url.URL{Scheme: "udp", Host: conn.LocalAddr().String()}, errOut)
+		s.send(Log{Message: "", ContainerName: "nginx"})
+		s.send(Log{Message: "line 1", ContainerName: "nginx"})
+		s.close()
+
+		conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		buf := make([]byte, 65536)
+		n, _, err := conn.ReadFrom(buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		assertGELFMessages(t, [][]byte{gunzip(t, buf[:n])}, []string{"line 1"})
+		if errOut.Len() != 0 {
+			t.Errorf("expected no errors, but actual %q", errOut)
+		}
+	})
+
+	t.Run("tcp", func(t *testing.T) {
+		l, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		defer l.Close()
+		received := make(chan []byte, 1)
+		go func() {
+			conn, err := l.Accept()
+			if err != nil {
+				received <- nil
+				return
+			}
+			defer conn.Close()
+			data, _ := io.ReadAll(conn)
+			received <- data
+		}()
+
+		errOut := new(bytes.Buffer)
+		s := newGELFSink(&url.URL{Scheme: "tcp", Host: l.Addr().String()}, errOut)
+		s.send(Log{Message: "line 1", ContainerName: "nginx"})
+		s.send(Log{Message: "line 2", ContainerName: "nginx"})
+		s.close()
+
+		data := <-received
+		if !bytes.HasSuffix(data, []byte{0}) {
+			t.Errorf("expected null-byte delimited messages, but actual %q", data)
+		}
+		assertGELFMessages(t, bytes.Split(bytes.TrimSuffix(data, []byte{0}), []byte{0}), []string{"line 1", "line 2"})
+		if errOut.Len() != 0 {
+			t.Errorf("expected no errors, but actual %q", errOut)
+		}
+	})
+}
+
+func assertGELFMessages(t *testing.T, messages [][]byte, expected []string) {
+	t.Helper()
+	var actual []string
+	for _, data := range messages {
+		var msg struct {
+			Version       string `json:"version"`
+			ShortMessage  string `json:"short_message"`
+			ContainerName string `json:"_container_name"`
+		}
+		if err := json.Unmarshal(data, &msg); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		if msg.Version != "1.1" || msg.ContainerName != "nginx" {
+			t.Errorf("unexpected message %s", data)
+		}
+		actual = append(actual, msg.ShortMessage)
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %v, but actual %v", expected, actual)
+	}
+}
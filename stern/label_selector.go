@@ -0,0 +1,153 @@
+package stern
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/docker/docker/api/types/filters"
+)
+
+type labelOperator int
+
+const (
+	labelExists labelOperator = iota
+	labelNotExists
+	labelEquals
+	labelNotEquals
+	labelMatches
+	labelNotMatches
+	labelIn
+	labelNotIn
+)
+
+// LabelSelector selects containers and services by a label. The selector is one of
+//
+//	key             the label is set
+//	!key            the label is not set
+//	key=value       the label is set to value
+//	key!=value      the label is not set to value, or not set at all
+//	key=~regex      the label value matches the regular expression
+//	key!~regex      the label value does not match the regular expression, or the label is not set
+//	key in (a,b)    the label is set to one of the values
+//	key notin (a,b) the label is not set to any of the values, or not set at all
+type LabelSelector struct {
+	key      string
+	operator labelOperator
+	values   []string
+	re       *regexp.Regexp
+}
+
+var labelSetSelectorRe = regexp.MustCompile(`^\s*([^\s!=~(),]+)\s+(in|notin)\s+\(([^()]*)\)\s*$`)
+
+// labelOperators are the operators between the key and the value, the longest first for operators starting alike
+var labelOperators = []struct {
+	token    string
+	operator labelOperator
+}{
+	{"!=", labelNotEquals},
+	{"=~", labelMatches},
+	{"!~", labelNotMatches},
+	{"=", labelEquals},
+}
+
+func ParseLabelSelector(selector string) (LabelSelector, error) {
+	if m := labelSetSelectorRe.FindStringSubmatch(selector); m != nil {
+		s := LabelSelector{key: m[1], operator: labelIn}
+		if m[2] == "notin" {
+			s.operator = labelNotIn
+		}
+		for _, v := range strings.Split(m[3], ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				s.values = append(s.values, v)
+			}
+		}
+		if len(s.values) == 0 {
+			return LabelSelector{}, fmt.Errorf("label selector %q has no values", selector)
+		}
+		return s, nil
+	}
+
+	s, value := splitLabelSelector(selector)
+
+	if s.key == "" || strings.ContainsAny(s.key, "!=~") {
+		return LabelSelector{}, fmt.Errorf("invalid label selector %q", selector)
+	}
+	switch s.operator {
+	case labelMatches, labelNotMatches:
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return LabelSelector{}, fmt.Errorf("invalid regular expression in label selector %q: %w", selector, err)
+		}
+		s.re = re
+	case labelEquals, labelNotEquals:
+		s.values = []string{value}
+	}
+	return s, nil
+}
+
+// splitLabelSelector splits the selector at its first operator, so that the value may contain operators like in
+// key=a!=b
+func splitLabelSelector(selector string) (LabelSelector, string) {
+	if key, ok := strings.CutPrefix(selector, "!"); ok {
+		return LabelSelector{key: key, operator: labelNotExists}, ""
+	}
+	for i := range selector {
+		for _, op := range labelOperators {
+			if strings.HasPrefix(selector[i:], op.token) {
+				return LabelSelector{key: selector[:i], operator: op.operator}, selector[i+len(op.token):]
+			}
+		}
+	}
+	return LabelSelector{key: selector, operator: labelExists}, ""
+}
+
+func (s LabelSelector) Matches(labels map[string]string) bool {
+	value, ok := labels[s.key]
+	switch s.operator {
+	case labelExists:
+		return ok
+	case labelNotExists:
+		return !ok
+	case labelEquals, labelIn:
+		return ok && slices.Contains(s.values, value)
+	case labelNotEquals, labelNotIn:
+		return !ok || !slices.Contains(s.values, value)
+	case labelMatches:
+		return ok && s.re.MatchString(value)
+	case labelNotMatches:
+		return !ok || !s.re.MatchString(value)
+	}
+	return false
+}
+
+// dockerFilter returns the label filter to narrow down the selection server side. Selectors which cannot be expressed
+// as a dockerd label filter are only evaluated client side.
+func (s LabelSelector) dockerFilter() (string, bool) {
+	switch s.operator {
+	case labelEquals:
+		return s.key + "=" + s.values[0], true
+	case labelExists, labelMatches, labelIn:
+		return s.key, true
+	}
+	return "", false
+}
+
+func matchingLabels(labels map[string]string, selectors []LabelSelector) bool {
+	for _, s := range selectors {
+		if !s.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// addLabelFilters adds the label filters of the selectors that dockerd can evaluate
+func addLabelFilters(args filters.Args, selectors []LabelSelector) {
+	for _, s := range selectors {
+		if f, ok := s.dockerFilter(); ok {
+			args.Add("label", f)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package stern
+
+import (
+	"testing"
+)
+
+func TestLabelSelector(t *testing.T) {
+	labels := map[string]string{
+		"team": "payments-eu",
+		"tier": "backend",
+		"app":  "",
+		"expr": "a!=b",
+		"op":   "a=~b",
+	}
+
+	tests := []struct {
+		selector     string
+		expected     bool
+		dockerFilter string
+	}{
+		{"team", true, "team"},
+		{"owner", false, "owner"},
+		{"app", true, "app"},
+		{"!owner", true, ""},
+		{"!team", false, ""},
+		{"tier=backend", true, "tier=backend"},
+		{"tier=frontend", false, "tier=frontend"},
+		{"app=", true, "app="},
+		{"tier!=frontend", true, ""},
+		{"tier!=backend", false, ""},
+		{"owner!=someone", true, ""},
+		{"team=~payments-.*", true, "team"},
+		{"team=~^payments$", false, "team"},
+		{"owner=~.*", false, "owner"},
+		{"team!~^checkout-", true, ""},
+		{"team!~payments", false, ""},
+		{"expr=a!=b", true, "expr=a!=b"},
+		{"expr=~^a!=b", true, "expr"},
+		{"op=a=~b", true, "op=a=~b"},
+		{"op!=a=~b", false, ""},
+		{"tier in (frontend, backend)", true, "tier"},
+		{"tier in (frontend,web)", false, "tier"},
+		{"owner in (a,b)", false, "owner"},
+		{"tier notin (frontend,web)", true, ""},
+		{"tier notin (backend)", false, ""},
+		{"owner notin (a)", true, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.selector, func(t *testing.T) {
+			s, err := ParseLabelSelector(tt.selector)
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if actual := s.Matches(labels); actual != tt.expected {
+				t.Errorf("expected %v, but actual %v", tt.expected, actual)
+			}
+			if actual, _ := s.dockerFilter(); actual != tt.dockerFilter {
+				t.Errorf("expected docker filter %q, but actual %q", tt.dockerFilter, actual)
+			}
+		})
+	}
+}
+
+func TestParseLabelSelectorInvalid(t *testing.T) {
+	for _, selector := range []string{"", "!", "=value", "!=value", "team=~[invalid", "tier in ()", "team!key"} {
+		if _, err := ParseLabelSelector(selector); err == nil {
+			t.Errorf("%q: expected an error, but actual nil", selector)
+		}
+	}
+}
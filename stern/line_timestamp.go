@@ -0,0 +1,123 @@
+package stern
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Formats of the timestamps of the --stdin input
+const (
+	StdinTimestampFormatRFC3339 = "rfc3339" // a leading RFC3339 timestamp like in the output of docker logs -t
+	StdinTimestampFormatAuto    = "auto"    // a leading timestamp in one of the common date time formats
+	StdinTimestampFieldPrefix   = "json:"   // followed by the field of JSON lines holding the timestamp
+)
+
+// leadingTimestamp matches the date time at the start of a line, optionally in brackets, e.g.
+// "2024-05-01T10:00:00.123Z", "2024-05-01 10:00:00,123", or "[2024-05-01T10:00:00+02:00]"
+var leadingTimestamp = regexp.MustCompile(
+	`^\[?(\d{4}-\d{2}-\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?)\]?(?:\s+|$)`)
+
+// Layouts of the timestamps matched by leadingTimestamp after normalizing them. Fractional seconds are accepted
+// without being part of the layout.
+var leadingTimestampLayouts = []string{
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05Z0700",
+	"2006-01-02T15:04:05",
+}
+
+// ValidateStdinTimestampFormat returns an error if the format is not one of the formats of the --stdin input
+func ValidateStdinTimestampFormat(format string) error {
+	switch {
+	case format == "", format == StdinTimestampFormatRFC3339, format == StdinTimestampFormatAuto:
+		return nil
+	case strings.HasPrefix(format, StdinTimestampFieldPrefix) && len(format) > len(StdinTimestampFieldPrefix):
+		return nil
+	}
+	return errors.New("stdin-timestamp-format should be one of 'rfc3339', 'auto', or 'json:<field>'")
+}
+
+// parseLineTimestamp returns the time of the line in the format, and the line without a leading timestamp. Timestamps
+// without a time zone are in loc.
+func parseLineTimestamp(format, line string, loc *time.Location) (time.Time, string, bool) {
+	switch {
+	case format == StdinTimestampFormatRFC3339:
+		ts, rest, _ := strings.Cut(line, " ")
+		t, err := time.Parse(time.RFC3339Nano, ts)
+		if err != nil {
+			return time.Time{}, line, false
+		}
+		return t, rest, true
+	case format == StdinTimestampFormatAuto:
+		m := leadingTimestamp.FindStringSubmatchIndex(line)
+		if m == nil {
+			return time.Time{}, line, false
+		}
+		t, ok := parseDateTime(line[m[2]:m[3]], loc)
+		if !ok {
+			return time.Time{}, line, false
+		}
+		return t, line[m[1]:], true
+	case strings.HasPrefix(format, StdinTimestampFieldPrefix):
+		t, ok := parseJSONTimestamp(line, strings.TrimPrefix(format, StdinTimestampFieldPrefix), loc)
+		return t, line, ok
+	}
+	return time.Time{}, line, false
+}
+
+// parseDateTime parses a timestamp matched by leadingTimestamp
+func parseDateTime(s string, loc *time.Location) (time.Time, bool) {
+	s = strings.Replace(s, " ", "T", 1)
+	s = strings.Replace(s, ",", ".", 1)
+	for _, layout := range leadingTimestampLayouts {
+		if t, err := time.ParseInLocation(layout, s, loc); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
+
+// parseJSONTimestamp returns the time in the field of the JSON line. The field holds a date time string, or a Unix
+// time in seconds, milliseconds, microseconds or nanoseconds.
+func parseJSONTimestamp(line, field string, loc *time.Location) (time.Time, bool) {
+	decoder := json.NewDecoder(strings.NewReader(line))
+	decoder.UseNumber()
+	obj := make(map[string]any)
+	if err := decoder.Decode(&obj); err != nil {
+		return time.Time{}, false
+	}
+	switch v := obj[field].(type) {
+	case string:
+		if m := leadingTimestamp.FindStringSubmatch(v); m != nil && len(m[0]) == len(v) {
+			return parseDateTime(m[1], loc)
+		}
+	case json.Number:
+		return parseUnixTime(v.String())
+	}
+	return time.Time{}, false
+}
+
+// parseUnixTime parses a Unix time, guessing the unit by its magnitude. Only seconds may have a fraction.
+func parseUnixTime(s string) (time.Time, bool) {
+	whole, frac, _ := strings.Cut(s, ".")
+	i, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return time.Time{}, false
+	}
+	switch abs := max(i, -i); {
+	case abs < 1e11:
+		if len(frac) > 9 {
+			frac = frac[:9]
+		}
+		nsec, _ := strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+		return time.Unix(i, nsec), true
+	case abs < 1e14:
+		return time.UnixMilli(i), true
+	case abs < 1e17:
+		return time.UnixMicro(i), true
+	}
+	return time.Unix(0, i), true
+}
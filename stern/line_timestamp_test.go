@@ -0,0 +1,65 @@
+package stern
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseLineTimestamp(t *testing.T) {
+	cet := time.FixedZone("CET", 3600)
+	ts := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tsMilli := ts.Add(123 * time.Millisecond)
+
+	tests := []struct {
+		format   string
+		line     string
+		expected time.Time // zero when not found
+		rest     string
+	}{
+		{"rfc3339", "2024-05-01T10:00:00.123000000Z hello", tsMilli, "hello"},
+		{"rfc3339", "2024-05-01T12:00:00+02:00 hello", ts, "hello"},
+		{"rfc3339", "2024-05-01 10:00:00 hello", time.Time{}, "2024-05-01 10:00:00 hello"},
+		{"rfc3339", "hello", time.Time{}, "hello"},
+		{"auto", "2024-05-01T10:00:00.123Z hello", tsMilli, "hello"},
+		{"auto", "2024-05-01 11:00:00,123 INFO hello", tsMilli, "INFO hello"},
+		{"auto", "[2024-05-01T12:00:00+0200] hello", ts, "hello"},
+		{"auto", "2024-05-01T10:00:00Z", ts, ""},
+		{"auto", "2024-05-01T10:00:00Zhello", time.Time{}, "2024-05-01T10:00:00Zhello"},
+		{"auto", "INFO 2024-05-01T10:00:00Z hello", time.Time{}, "INFO 2024-05-01T10:00:00Z hello"},
+		{"json:time", `{"time":"2024-05-01T10:00:00.123Z","msg":"hello"}`, tsMilli, `{"time":"2024-05-01T10:00:00.123Z","msg":"hello"}`},
+		{"json:time", `{"time":"2024-05-01 11:00:00","msg":"hello"}`, ts, `{"time":"2024-05-01 11:00:00","msg":"hello"}`},
+		{"json:ts", `{"ts":1714557600.123,"msg":"hello"}`, tsMilli, `{"ts":1714557600.123,"msg":"hello"}`},
+		{"json:ts", `{"ts":1714557600123,"msg":"hello"}`, tsMilli, `{"ts":1714557600123,"msg":"hello"}`},
+		{"json:ts", `{"ts":1714557600123000000,"msg":"hello"}`, tsMilli, `{"ts":1714557600123000000,"msg":"hello"}`},
+		{"json:ts", `{"time":"2024-05-01T10:00:00Z"}`, time.Time{}, `{"time":"2024-05-01T10:00:00Z"}`},
+		{"json:ts", `{"ts":"yesterday"}`, time.Time{}, `{"ts":"yesterday"}`},
+		{"json:ts", `not json`, time.Time{}, `not json`},
+	}
+
+	for _, tt := range tests {
+		actual, rest, ok := parseLineTimestamp(tt.format, tt.line, cet)
+		if ok != !tt.expected.IsZero() {
+			t.Errorf("%s %q: expected found %v, but actual %v", tt.format, tt.line, !tt.expected.IsZero(), ok)
+			continue
+		}
+		if !actual.Equal(tt.expected) {
+			t.Errorf("%s %q: expected %v, but actual %v", tt.format, tt.line, tt.expected, actual)
+		}
+		if rest != tt.rest {
+			t.Errorf("%s %q: expected rest %q, but actual %q", tt.format, tt.line, tt.rest, rest)
+		}
+	}
+}
+
+func TestValidateStdinTimestampFormat(t *testing.T) {
+	for _, format := range []string{"", "rfc3339", "auto", "json:time"} {
+		if err := ValidateStdinTimestampFormat(format); err != nil {
+			t.Errorf("%q: unexpected err %v", format, err)
+		}
+	}
+	for _, format := range []string{"json:", "RFC3339", "unix"} {
+		if err := ValidateStdinTimestampFormat(format); err == nil {
+			t.Errorf("%q: expected an error", format)
+		}
+	}
+}
@@ -0,0 +1,368 @@
+package stern
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// logRecord is an entry of a log file
+type logRecord struct {
+	time    time.Time // zero when the file carries no timestamps
+	stream  string
+	line    string
+	partial bool  // the line continues in the next record
+	err     error // the entry could not be parsed, line holds the raw entry
+}
+
+// logFileReader reads the records of a log file written by the json-file or the local logging driver of dockerd, or
+// the lines of any other file. Rotated files compressed by the drivers are decompressed.
+//
+// json-file writes one JSON object per message, e.g. {"log":"hello\n","stream":"stdout","time":"2024-..."}, where
+// partial messages lack the trailing newline. local writes protobuf encoded LogEntry messages, each framed by its size
+// as a big-endian uint32 before and after it: https://github.com/moby/moby/tree/master/daemon/logger/local
+//
+// When following a file which is still being written, an incomplete record at the end of the file is kept until the
+// rest of it has been written. The format is detected once the start of the file is available.
+type logFileReader struct {
+	r       *bufio.Reader
+	follow  bool
+	read    func() (logRecord, error)
+	pending []byte // the start of an incomplete record
+}
+
+const (
+	localFrameLen   = 4
+	maxLocalMessage = 1024 * 1024 // dockerd rejects larger messages
+
+	jsonFilePrefix = `{"log":`
+)
+
+func newLogFileReader(r io.Reader, follow bool) *logFileReader {
+	return &logFileReader{r: bufio.NewReader(r), follow: follow}
+}
+
+// Read returns the next record of the file, or io.EOF at its end
+func (r *logFileReader) Read() (logRecord, error) {
+	if r.read == nil {
+		if err := r.detect(); err != nil {
+			return logRecord{}, err
+		}
+	}
+	return r.read()
+}
+
+func (r *logFileReader) detect() error {
+	start, err := r.r.Peek(len(jsonFilePrefix))
+	if err != nil && r.follow {
+		// Too short to tell yet
+		return err
+	}
+	if len(start) >= 2 && start[0] == 0x1f && start[1] == 0x8b {
+		gz, err := gzip.NewReader(r.r)
+		if err != nil {
+			return err
+		}
+		r.r = bufio.NewReader(gz)
+		return r.detect()
+	}
+
+	switch {
+	case string(start) == jsonFilePrefix:
+		r.read = r.readJSONFile
+	case isLocalLog(start):
+		r.read = r.readLocal
+	default:
+		r.read = r.readText
+	}
+	return nil
+}
+
+// isLocalLog returns true if the file starts with the size of a LogEntry message followed by its source field
+func isLocalLog(start []byte) bool {
+	if len(start) <= localFrameLen {
+		return false
+	}
+	size := binary.BigEndian.Uint32(start)
+	return size > 0 && size <= maxLocalMessage && start[localFrameLen] == 1<<3|2
+}
+
+// readLine returns the next line including the newline. Without a newline at the end of the file the rest of the file
+// is returned, or kept for the next call when following.
+func (r *logFileReader) readLine() ([]byte, error) {
+	line, err := r.r.ReadBytes('\n')
+	r.pending = append(r.pending, line...)
+	if err != nil && (r.follow || len(r.pending) == 0) {
+		return nil, err
+	}
+	line = r.pending
+	r.pending = nil
+	return line, nil
+}
+
+// fill reads until at least n bytes are pending
+func (r *logFileReader) fill(n int) error {
+	for len(r.pending) < n {
+		buf := make([]byte, n-len(r.pending))
+		read, err := r.r.Read(buf)
+		r.pending = append(r.pending, buf[:read]...)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+type jsonFileEntry struct {
+	Log    string    `json:"log"`
+	Stream string    `json:"stream"`
+	Time   time.Time `json:"time"`
+}
+
+func (r *logFileReader) readJSONFile() (logRecord, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return logRecord{}, err
+	}
+	var entry jsonFileEntry
+	if jsonErr := json.Unmarshal(line, &entry); jsonErr != nil {
+		return logRecord{line: string(bytes.TrimSuffix(line, []byte{'\n'})), err: jsonErr}, nil
+	}
+	text, complete := strings.CutSuffix(entry.Log, "\n")
+	return logRecord{
+		time:    entry.Time,
+		stream:  entry.Stream,
+		line:    text,
+		partial: !complete,
+	}, nil
+}
+
+func (r *logFileReader) readLocal() (logRecord, error) {
+	if err := r.fill(localFrameLen); err != nil {
+		return logRecord{}, r.incomplete(err)
+	}
+	size := int(binary.BigEndian.Uint32(r.pending))
+	if size > maxLocalMessage {
+		return logRecord{}, fmt.Errorf("invalid message size %d", size)
+	}
+	frameLen := size + 2*localFrameLen
+	if err := r.fill(frameLen); err != nil {
+		return logRecord{}, r.incomplete(err)
+	}
+	frame := r.pending[:frameLen]
+	r.pending = r.pending[frameLen:]
+	if !bytes.Equal(frame[:localFrameLen], frame[size+localFrameLen:]) {
+		return logRecord{}, errors.New("corrupted message framing")
+	}
+	return decodeLocalEntry(frame[localFrameLen : size+localFrameLen])
+}
+
+// incomplete handles the end of the file within a record. Unless following, the record was cut off by a crash or by
+// copying the file while it was written, and is dropped.
+func (r *logFileReader) incomplete(err error) error {
+	if err == io.EOF && !r.follow {
+		r.pending = nil
+	}
+	return err
+}
+
+func (r *logFileReader) readText() (logRecord, error) {
+	line, err := r.readLine()
+	if err != nil {
+		return logRecord{}, err
+	}
+	return logRecord{line: strings.TrimSuffix(string(line), "\n")}, nil
+}
+
+// decodeLocalEntry decodes the protobuf message
+//
+//	message LogEntry {
+//		string source = 1;
+//		int64 time_nano = 2;
+//		bytes line = 3;
+//		bool partial = 4;
+//		PartialLogEntryMetadata partial_log_metadata = 5; // bool last = 1; ...
+//	}
+func decodeLocalEntry(b []byte) (logRecord, error) {
+	var rec logRecord
+	var partial, last bool
+	err := decodeProtobuf(b, func(field int, value uint64, data []byte) error {
+		switch field {
+		case 1:
+			rec.stream = string(data)
+		case 2:
+			rec.time = time.Unix(0, int64(value)).UTC()
+		case 3:
+			rec.line = string(data)
+		case 4:
+			partial = value != 0
+		case 5:
+			return decodeProtobuf(data, func(field int, value uint64, _ []byte) error {
+				if field == 1 {
+					last = value != 0
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	rec.partial = partial && !last
+	return rec, err
+}
+
+// decodeProtobuf calls fn with the value of varint fields, or the data of length-delimited fields, of the message
+func decodeProtobuf(b []byte, fn func(field int, value uint64, data []byte) error) error {
+	errInvalid := errors.New("invalid protobuf message")
+	for len(b) > 0 {
+		key, n := binary.Uvarint(b)
+		if n <= 0 {
+			return errInvalid
+		}
+		b = b[n:]
+		field := int(key >> 3)
+		var value uint64
+		var data []byte
+		switch key & 7 {
+		case 0: // varint
+			value, n = binary.Uvarint(b)
+			if n <= 0 {
+				return errInvalid
+			}
+			b = b[n:]
+		case 1: // 64-bit
+			if len(b) < 8 {
+				return errInvalid
+			}
+			b = b[8:]
+		case 2: // length-delimited
+			size, n := binary.Uvarint(b)
+			if n <= 0 || uint64(len(b)-n) < size {
+				return errInvalid
+			}
+			data = b[n : n+int(size)]
+			b = b[n+int(size):]
+		case 5: // 32-bit
+			if len(b) < 4 {
+				return errInvalid
+			}
+			b = b[4:]
+		default:
+			return errInvalid
+		}
+		if err := fn(field, value, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isRotatedLogFile returns true for files rotated by the logging drivers, which are not written to anymore
+func isRotatedLogFile(path string) bool {
+	_, rotation := splitRotation(path)
+	return rotation > 0 || strings.HasSuffix(path, ".gz")
+}
+
+// rotatedLogFile matches the rotation number and compression suffixes added by the logging drivers
+var rotatedLogFile = regexp.MustCompile(`^(.*?)(?:\.(\d+))?(?:\.gz)?$`)
+
+// globLogFiles expands the glob patterns into the log files to read. Rotated files are ordered before the file they
+// were rotated from, oldest first.
+func globLogFiles(patterns []string) ([]string, error) {
+	var paths []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(pattern)
+		if err != nil {
+			return nil, err
+		}
+		if len(matches) == 0 {
+			return nil, fmt.Errorf("no files match %q", pattern)
+		}
+		for _, match := range matches {
+			if !slices.Contains(paths, match) {
+				paths = append(paths, match)
+			}
+		}
+	}
+	slices.SortStableFunc(paths, func(a, b string) int {
+		baseA, rotationA := splitRotation(a)
+		baseB, rotationB := splitRotation(b)
+		if baseA != baseB {
+			return strings.Compare(baseA, baseB)
+		}
+		return rotationB - rotationA
+	})
+	return paths, nil
+}
+
+// groupLogFiles groups the ordered log files by the file they were rotated from
+func groupLogFiles(paths []string) [][]string {
+	var groups [][]string
+	var last string
+	for _, path := range paths {
+		current, _ := splitRotation(path)
+		if len(groups) == 0 || current != last {
+			groups = append(groups, nil)
+			last = current
+		}
+		groups[len(groups)-1] = append(groups[len(groups)-1], path)
+	}
+	return groups
+}
+
+// splitRotation returns the path of the current log file and the rotation number, 0 for the current file
+func splitRotation(path string) (string, int) {
+	m := rotatedLogFile.FindStringSubmatch(path)
+	rotation, _ := strconv.Atoi(m[2])
+	return m[1], rotation
+}
+
+// logFileContainerName derives the container name from the path of the log file, e.g. the short container ID from
+// /var/lib/docker/containers/<id>/<id>-json.log or /var/lib/docker/containers/<id>/local-logs/container.log
+func logFileContainerName(path string) string {
+	if id := logFileContainerId(path); id != "" {
+		return id[:12]
+	}
+	return logFileBaseName(path)
+}
+
+// logFileContainerId returns the container ID in the path of the log file, or an empty string if there is none
+func logFileContainerId(path string) string {
+	if name := logFileBaseName(path); isContainerId(name) {
+		return name
+	}
+	return ""
+}
+
+// logFileBaseName returns the name of the log file without the rotation and logging driver suffixes, which is the
+// container ID for the files of the logging drivers
+func logFileBaseName(path string) string {
+	current, _ := splitRotation(path)
+	name := filepath.Base(current)
+	if name == "container.log" && filepath.Base(filepath.Dir(current)) == "local-logs" {
+		name = filepath.Base(filepath.Dir(filepath.Dir(current)))
+	}
+	return strings.TrimSuffix(name, "-json.log")
+}
+
+func isContainerId(s string) bool {
+	if len(s) != 64 {
+		return false
+	}
+	for _, c := range s {
+		if !strings.ContainsRune("0123456789abcdef", c) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,229 @@
+package stern
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"text/template"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/fatih/color"
+)
+
+// fileFollowInterval is how often followed files are checked for new data, truncation and rotation
+var fileFollowInterval = 250 * time.Millisecond
+
+// LogFileTail reads a log file of a container, e.g. one copied from /var/lib/docker/containers of a broken host
+type LogFileTail struct {
+	Options        *TailOptions
+	path           string
+	name           string
+	id             string // container ID, empty if the path has none
+	containerColor *color.Color
+	tmpl           *template.Template
+	partial        map[string]*logRecord // partial messages per stream
+	multiline      *multilineGrouper
+	sorter         *timeSorter
+	out            io.Writer
+	errOut         io.Writer
+}
+
+// NewLogFileTail returns a new tail of the log file
+func NewLogFileTail(path string, tmpl *template.Template, out, errOut io.Writer, options *TailOptions) *LogFileTail {
+	name := logFileContainerName(path)
+	return &LogFileTail{
+		Options:        options,
+		path:           path,
+		name:           name,
+		id:             logFileContainerId(path),
+		containerColor: colorList[colorIndex(name)][1],
+		tmpl:           tmpl,
+		partial:        make(map[string]*logRecord),
+		out:            out,
+		errOut:         errOut,
+	}
+}
+
+// Start reads the file until its end. When following, it then waits for new data like tail -F: a truncated file is
+// read again from the start, and a file replaced by rotation is read to its end before the new file is opened. Files
+// rotated by the logging drivers are never followed.
+func (t *LogFileTail) Start(ctx context.Context) error {
+	f, err := os.Open(t.path)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		f.Close()
+	}()
+
+	t.multiline = newMultilineGrouper(t.Options, t.consumeEntry)
+	defer t.multiline.flush()
+	defer t.flushPartial()
+
+	follow := t.Options.Follow && !isRotatedLogFile(t.path)
+	r := newLogFileReader(f, follow)
+	for {
+		if err := t.consumeFile(r); err != nil {
+			return fmt.Errorf("%s: %w", t.path, err)
+		}
+		if !follow {
+			return nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(fileFollowInterval):
+		}
+
+		stat, err := os.Stat(t.path)
+		if err != nil {
+			// Rotated and not created again yet
+			continue
+		}
+		current, err := f.Stat()
+		if err != nil {
+			return err
+		}
+		if !os.SameFile(stat, current) {
+			// Data written before the rotation
+			if err := t.consumeFile(r); err != nil {
+				return fmt.Errorf("%s: %w", t.path, err)
+			}
+			rotated, err := os.Open(t.path)
+			if err != nil {
+				continue
+			}
+			f.Close()
+			f = rotated
+			r = newLogFileReader(f, follow)
+			continue
+		}
+		if offset, err := f.Seek(0, io.SeekCurrent); err == nil && stat.Size() < offset {
+			if !t.Options.OnlyLogLines {
+				fmt.Fprintf(t.errOut, "%s: file truncated\n", t.path)
+			}
+			if _, err := f.Seek(0, io.SeekStart); err != nil {
+				return err
+			}
+			r = newLogFileReader(f, follow)
+		}
+	}
+}
+
+// consumeFile consumes the records until the end of the file
+func (t *LogFileTail) consumeFile(r *logFileReader) error {
+	for {
+		rec, err := r.Read()
+		if err != nil {
+			if err != io.EOF {
+				return err
+			}
+			return nil
+		}
+		t.consumeRecord(rec)
+	}
+}
+
+// consumeRecord joins partial messages into lines and filters them by stream and time
+func (t *LogFileTail) consumeRecord(rec logRecord) {
+	if rec.err != nil {
+		t.Print(time.Time{}, "", fmt.Sprintf("[%v] %s", rec.err, rec.line))
+		return
+	}
+	if t.Options.Stream != "" && rec.stream != t.Options.Stream {
+		return
+	}
+
+	if p, ok := t.partial[rec.stream]; ok {
+		// The line keeps the time of its first message, the rest of a truncated line is dropped
+		p.line += rec.line
+		if t.Options.MaxLineSize > 0 && len(p.line) > t.Options.MaxLineSize {
+			p.line = p.line[:t.Options.MaxLineSize]
+		}
+		if rec.partial {
+			return
+		}
+		delete(t.partial, rec.stream)
+		rec = *p
+	} else if rec.partial {
+		t.partial[rec.stream] = &rec
+		return
+	}
+	t.consumeLine(rec)
+}
+
+func (t *LogFileTail) flushPartial() {
+	for stream, p := range t.partial {
+		t.consumeLine(*p)
+		delete(t.partial, stream)
+	}
+}
+
+func (t *LogFileTail) consumeLine(rec logRecord) {
+	if t.Options.MaxLineSize > 0 && len(rec.line) > t.Options.MaxLineSize {
+		rec.line = rec.line[:t.Options.MaxLineSize]
+	}
+	var timestamp string
+	if !rec.time.IsZero() {
+		timestamp = rec.time.Format(time.RFC3339Nano)
+		if t.Options.IsBeforeSince(timestamp) || t.Options.IsAfterUntil(timestamp) {
+			return
+		}
+	}
+	t.multiline.add(logEntry{
+		timestamp: timestamp,
+		stream:    rec.stream,
+		content:   rec.line,
+	})
+}
+
+func (t *LogFileTail) consumeEntry(e logEntry) {
+	if t.Options.IsExclude(e.content) || !t.Options.IsInclude(e.content) || t.Options.IsBelowLevel(e.content) {
+		return
+	}
+
+	msg := t.Options.HighlightMatchedString(e.content)
+
+	var timestamp time.Time
+	if e.timestamp != "" {
+		timestamp, _ = time.Parse(time.RFC3339Nano, e.timestamp)
+		if t.Options.Timestamps {
+			updatedTs, err := t.Options.UpdateTimezoneAndFormat(e.timestamp)
+			if err != nil {
+				t.Print(time.Time{}, e.stream, fmt.Sprintf("[%v] %s %s", err, e.timestamp, e.content))
+				return
+			}
+			msg = updatedTs + " " + msg
+		}
+	}
+	t.Print(timestamp, e.stream, msg)
+}
+
+// Print prints a color coded log message. Messages with a timestamp are passed through the time sorter if enabled.
+func (t *LogFileTail) Print(timestamp time.Time, stream, msg string) {
+	vm := Log{
+		Message:        msg,
+		ContainerName:  t.name,
+		ServiceName:    t.name,
+		Stream:         stream,
+		Timestamp:      t.Options.logTimestamp(timestamp),
+		ContainerID:    t.id,
+		ContainerColor: t.containerColor,
+	}
+
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, vm); err != nil {
+		fmt.Fprintf(t.errOut, "expanding template failed: %s\n", err)
+		log.L.WithField("error", err).WithField("message", msg).Error("Template failure")
+		return
+	}
+	if t.sorter != nil && !timestamp.IsZero() {
+		t.sorter.add(timestamp, buf.String())
+		return
+	}
+	fmt.Fprint(t.out, buf.String())
+}
@@ -0,0 +1,377 @@
+package stern
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"testing"
+	"text/template"
+	"time"
+)
+
+// localEntry encodes a message like the local logging driver
+func localEntry(stream string, t time.Time, line string, partial, last bool) []byte {
+	field := func(b []byte, num, wireType int) []byte {
+		return binary.AppendUvarint(b, uint64(num<<3|wireType))
+	}
+	bytesField := func(b []byte, num int, data []byte) []byte {
+		b = field(b, num, 2)
+		b = binary.AppendUvarint(b, uint64(len(data)))
+		return append(b, data...)
+	}
+	var msg []byte
+	msg = bytesField(msg, 1, []byte(stream))
+	msg = binary.AppendUvarint(field(msg, 2, 0), uint64(t.UnixNano()))
+	msg = bytesField(msg, 3, []byte(line))
+	if partial {
+		msg = binary.AppendUvarint(field(msg, 4, 0), 1)
+		var md []byte
+		if last {
+			md = binary.AppendUvarint(field(md, 1, 0), 1)
+		}
+		md = bytesField(md, 2, []byte("partial-id"))
+		msg = bytesField(msg, 5, md)
+	}
+	frame := binary.BigEndian.AppendUint32(nil, uint32(len(msg)))
+	frame = append(frame, msg...)
+	return binary.BigEndian.AppendUint32(frame, uint32(len(msg)))
+}
+
+func gzipped(data []byte) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	_, _ = w.Write(data)
+	_ = w.Close()
+	return buf.Bytes()
+}
+
+func TestLogFileReader(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 10, 0, 0, 123456789, time.UTC)
+	jsonFile := []byte(`{"log":"line 1\n","stream":"stdout","time":"2024-05-01T10:00:00.123456789Z"}
+{"log":"par","stream":"stderr","time":"2024-05-01T10:00:00.123456789Z"}
+{"log":"tial\n","stream":"stderr","time":"2024-05-01T10:00:00.123456789Z"}
+not json
+`)
+	expectedJSONFile := []logRecord{
+		{time: ts, stream: StreamStdout, line: "line 1"},
+		{time: ts, stream: StreamStderr, line: "par", partial: true},
+		{time: ts, stream: StreamStderr, line: "tial"},
+		{line: "not json", err: &json.SyntaxError{}},
+	}
+	var local []byte
+	local = append(local, localEntry(StreamStdout, ts, "line 1", false, false)...)
+	local = append(local, localEntry(StreamStderr, ts, "par", true, false)...)
+	local = append(local, localEntry(StreamStderr, ts, "tial", true, true)...)
+	local = append(local, localEntry(StreamStdout, ts, "cut off", false, false)[:10]...)
+
+	tests := []struct {
+		name     string
+		data     []byte
+		expected []logRecord
+	}{
+		{
+			name:     "json-file",
+			data:     jsonFile,
+			expected: expectedJSONFile,
+		},
+		{
+			name:     "compressed json-file",
+			data:     gzipped(jsonFile),
+			expected: expectedJSONFile,
+		},
+		{
+			name: "local",
+			data: local,
+			expected: []logRecord{
+				{time: ts, stream: StreamStdout, line: "line 1"},
+				{time: ts, stream: StreamStderr, line: "par", partial: true},
+				{time: ts, stream: StreamStderr, line: "tial"},
+			},
+		},
+		{
+			name: "plain text",
+			data: []byte("line 1\n{\"msg\":\"line 2\"}\nline 3"),
+			expected: []logRecord{
+				{line: "line 1"},
+				{line: `{"msg":"line 2"}`},
+				{line: "line 3"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := newLogFileReader(bytes.NewReader(tt.data), false)
+			var actual []logRecord
+			for {
+				rec, err := r.Read()
+				if err == io.EOF {
+					break
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				actual = append(actual, rec)
+			}
+			if len(actual) != len(tt.expected) {
+				t.Fatalf("expected %d records, but actual %d: %+v", len(tt.expected), len(actual), actual)
+			}
+			for i, expected := range tt.expected {
+				// Only the presence of errors is compared
+				if (actual[i].err == nil) != (expected.err == nil) {
+					t.Errorf("%d: expected err %v, but actual %v", i, expected.err, actual[i].err)
+				}
+				if !actual[i].time.Equal(expected.time) {
+					t.Errorf("%d: expected time %v, but actual %v", i, expected.time, actual[i].time)
+				}
+				actual[i].err, expected.err = nil, nil
+				actual[i].time, expected.time = time.Time{}, time.Time{}
+				if !reflect.DeepEqual(actual[i], expected) {
+					t.Errorf("%d: expected %+v, but actual %+v", i, expected, actual[i])
+				}
+			}
+		})
+	}
+}
+
+func TestLogFileTail(t *testing.T) {
+	const id = "4b6e3b7c1f0a9d2e8c5a7b3d1e9f0a2c4b6d8e0f1a3c5e7b9d1f3a5c7e9b1d3f"
+	path := filepath.Join(t.TempDir(), id, id+"-json.log")
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	data := `{"log":"starting\n","stream":"stdout","time":"2024-05-01T09:59:59Z"}
+{"log":"GET /\n","stream":"stdout","time":"2024-05-01T10:00:01Z"}
+{"log":"long ","stream":"stderr","time":"2024-05-01T10:00:02Z"}
+{"log":"error\n","stream":"stderr","time":"2024-05-01T10:00:03Z"}
+{"log":"GET /health\n","stream":"stdout","time":"2024-05-01T10:00:04Z"}
+{"log":"stopping\n","stream":"stdout","time":"2024-05-01T10:01:00Z"}
+`
+	if err := os.WriteFile(path, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	tmpl := template.Must(template.New("").Parse("{{.ContainerName}} {{.Stream}} {{.Message}}\n"))
+
+	tests := []struct {
+		name     string
+		options  *TailOptions
+		expected string
+	}{
+		{
+			name: "since and until",
+			options: &TailOptions{
+				SinceTime: time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC),
+				UntilTime: time.Date(2024, 5, 1, 10, 0, 30, 0, time.UTC),
+			},
+			expected: `4b6e3b7c1f0a stdout GET /
+4b6e3b7c1f0a stderr long error
+4b6e3b7c1f0a stdout GET /health
+`,
+		},
+		{
+			name:    "stream",
+			options: &TailOptions{Stream: StreamStderr},
+			expected: `4b6e3b7c1f0a stderr long error
+`,
+		},
+		{
+			name: "include and timestamps",
+			options: &TailOptions{
+				Include:         []*regexp.Regexp{regexp.MustCompile(`GET`)},
+				Timestamps:      true,
+				TimestampFormat: TimestampFormatShort,
+				Location:        time.UTC,
+			},
+			expected: `4b6e3b7c1f0a stdout 05-01 10:00:01 GET /
+4b6e3b7c1f0a stdout 05-01 10:00:04 GET /health
+`,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			out := new(bytes.Buffer)
+			tail := NewLogFileTail(path, tmpl, out, io.Discard, tt.options)
+			if err := tail.Start(context.Background()); err != nil {
+				t.Fatal(err)
+			}
+			if out.String() != tt.expected {
+				t.Errorf("expected %q, but actual %q", tt.expected, out.String())
+			}
+		})
+	}
+}
+
+func TestLogFileReaderFollow(t *testing.T) {
+	ts := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	jsonLine := `{"log":"line 1\n","stream":"stdout","time":"2024-05-01T10:00:00Z"}` + "\n"
+	local := localEntry(StreamStdout, ts, "line 1", false, false)
+
+	tests := []struct {
+		name string
+		data []byte
+	}{
+		{"json-file", []byte(jsonLine)},
+		{"local", local},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// The file is written a few bytes at a time
+			file := new(bytes.Buffer)
+			r := newLogFileReader(file, true)
+			for i := 0; i < len(tt.data); i += 3 {
+				if _, err := r.Read(); err != io.EOF {
+					t.Fatalf("expected EOF at %d, but actual %v", i, err)
+				}
+				file.Write(tt.data[i:min(i+3, len(tt.data))])
+			}
+			rec, err := r.Read()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if rec.line != "line 1" || rec.stream != StreamStdout || !rec.time.Equal(ts) {
+				t.Errorf("unexpected record %+v", rec)
+			}
+			if _, err := r.Read(); err != io.EOF {
+				t.Errorf("expected EOF, but actual %v", err)
+			}
+		})
+	}
+}
+
+// lineWriter sends every write to the channel
+type lineWriter chan string
+
+func (w lineWriter) Write(p []byte) (int, error) {
+	w <- string(p)
+	return len(p), nil
+}
+
+func TestLogFileTailFollow(t *testing.T) {
+	orig := fileFollowInterval
+	fileFollowInterval = time.Millisecond
+	defer func() {
+		fileFollowInterval = orig
+	}()
+
+	path := filepath.Join(t.TempDir(), "web-json.log")
+	entry := func(msg string) string {
+		return `{"log":"` + msg + `\n","stream":"stdout","time":"2024-05-01T10:00:00Z"}` + "\n"
+	}
+	write := func(flag int, data string) {
+		f, err := os.OpenFile(path, flag|os.O_WRONLY|os.O_CREATE, 0o644)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString(data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	lines := make(lineWriter, 10)
+	expect := func(expected string) {
+		t.Helper()
+		select {
+		case line := <-lines:
+			if line != expected {
+				t.Fatalf("expected %q, but actual %q", expected, line)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("timed out waiting for %q", expected)
+		}
+	}
+
+	write(os.O_TRUNC, entry("line 1")+entry("line 2"))
+	tmpl := template.Must(template.New("").Parse("{{.ContainerName}} {{.Message}}\n"))
+	tail := NewLogFileTail(path, tmpl, lines, io.Discard, &TailOptions{Follow: true})
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- tail.Start(ctx)
+	}()
+	expect("web line 1\n")
+	expect("web line 2\n")
+
+	// An entry written in two steps
+	line3 := entry("line 3")
+	write(os.O_APPEND, line3[:20])
+	time.Sleep(10 * fileFollowInterval)
+	write(os.O_APPEND, line3[20:])
+	expect("web line 3\n")
+
+	// Truncated
+	write(os.O_TRUNC, entry("line 4"))
+	expect("web line 4\n")
+
+	// Rotated
+	write(os.O_APPEND, entry("line 5"))
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	write(os.O_TRUNC, entry("line 6"))
+	expect("web line 5\n")
+	expect("web line 6\n")
+
+	cancel()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGlobLogFiles(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a-json.log", "a-json.log.1", "a-json.log.2.gz", "b-json.log", "b-json.log.10", "b-json.log.9"} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	actual, err := globLogFiles([]string{filepath.Join(dir, "*-json.log*"), filepath.Join(dir, "a-json.log")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"a-json.log.2.gz", "a-json.log.1", "a-json.log", "b-json.log.10", "b-json.log.9", "b-json.log"}
+	for i := range expected {
+		expected[i] = filepath.Join(dir, expected[i])
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("expected %v, but actual %v", expected, actual)
+	}
+
+	expectedGroups := [][]string{expected[:3], expected[3:]}
+	if groups := groupLogFiles(actual); !reflect.DeepEqual(groups, expectedGroups) {
+		t.Errorf("expected groups %v, but actual %v", expectedGroups, groups)
+	}
+
+	if _, err := globLogFiles([]string{filepath.Join(dir, "c-json.log")}); err == nil {
+		t.Errorf("expected an error for a pattern without matches")
+	}
+}
+
+func TestLogFileContainerName(t *testing.T) {
+	const id = "4b6e3b7c1f0a9d2e8c5a7b3d1e9f0a2c4b6d8e0f1a3c5e7b9d1f3a5c7e9b1d3f"
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/var/lib/docker/containers/" + id + "/" + id + "-json.log", "4b6e3b7c1f0a"},
+		{"/tmp/" + id + "-json.log.3.gz", "4b6e3b7c1f0a"},
+		{"/var/lib/docker/containers/" + id + "/local-logs/container.log.1", "4b6e3b7c1f0a"},
+		{"web-json.log", "web"},
+		{"/var/log/app.log", "app.log"},
+	}
+
+	for _, tt := range tests {
+		if actual := logFileContainerName(tt.path); actual != tt.expected {
+			t.Errorf("%s: expected %q, but actual %q", tt.path, tt.expected, actual)
+		}
+	}
+}
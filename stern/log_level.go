@@ -0,0 +1,92 @@
+package stern
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Log levels, numbered like the levels of bunyan
+const (
+	LevelTrace = 10
+	LevelDebug = 20
+	LevelInfo  = 30
+	LevelWarn  = 40
+	LevelError = 50
+	LevelFatal = 60
+)
+
+// LevelNames are the names accepted for the minimum level, from the lowest to the highest level
+var LevelNames = []string{"trace", "debug", "info", "warn", "error", "fatal"}
+
+// levels maps the level names of common loggers, in lower case, to levels
+var levels = map[string]int{
+	"trace":       LevelTrace,
+	"debug":       LevelDebug,
+	"info":        LevelInfo,
+	"information": LevelInfo,
+	"notice":      LevelInfo,
+	"default":     LevelInfo,
+	"warn":        LevelWarn,
+	"warning":     LevelWarn,
+	"error":       LevelError,
+	"err":         LevelError,
+	"dpanic":      LevelError,
+	"fatal":       LevelFatal,
+	"panic":       LevelFatal,
+	"crit":        LevelFatal,
+	"critical":    LevelFatal,
+	"alert":       LevelFatal,
+	"emerg":       LevelFatal,
+	"emergency":   LevelFatal,
+}
+
+// levelFields are the fields holding the level of structured logs
+var levelFields = []string{"level", "lvl", "severity", "log.level"}
+
+// ParseLevel returns the level of a level name like "warn"
+func ParseLevel(name string) (int, error) {
+	if level, ok := levels[strings.ToLower(name)]; ok {
+		return level, nil
+	}
+	return 0, fmt.Errorf("unknown level %q", name)
+}
+
+// lineLevel returns the level of a JSON or logfmt log line. Numeric levels are bunyan levels.
+func lineLevel(msg string) (int, bool) {
+	if strings.HasPrefix(strings.TrimSpace(msg), "{") {
+		decoder := json.NewDecoder(strings.NewReader(msg))
+		decoder.UseNumber()
+		obj := make(map[string]any)
+		// Only the first object of a multiline event is decoded
+		if err := decoder.Decode(&obj); err == nil {
+			for _, field := range levelFields {
+				switch v := obj[field].(type) {
+				case string:
+					level, ok := levels[strings.ToLower(v)]
+					return level, ok
+				case json.Number:
+					level, err := v.Int64()
+					return int(level), err == nil
+				}
+			}
+			return 0, false
+		}
+	}
+
+	// Only the first line of a multiline event is parsed, plain text lines are not logfmt
+	line, _, _ := strings.Cut(msg, "\n")
+	pairs, err := ParseLogfmt(line)
+	if err != nil {
+		return 0, false
+	}
+	for _, field := range levelFields {
+		for _, p := range pairs {
+			if p.Key == field {
+				level, ok := levels[strings.ToLower(p.Value)]
+				return level, ok
+			}
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,72 @@
+package stern
+
+import "testing"
+
+func TestLineLevel(t *testing.T) {
+	tests := []struct {
+		msg      string
+		expected int // 0 when the line has no level
+	}{
+		{`{"level":"warn","msg":"disk almost full"}`, LevelWarn},
+		{`{"lvl":"ERROR","msg":"failed"}`, LevelError},
+		{`{"severity":"WARNING","message":"slow"}`, LevelWarn},
+		{`{"log.level":"debug","message":"ecs"}`, LevelDebug},
+		{`{"name":"api","level":30,"msg":"bunyan"}`, LevelInfo},
+		{`{"level":50,"msg":"pino"}` + "\n  at main.js:10", LevelError},
+		{`{"level":"verbose"}`, 0},
+		{`{"msg":"no level"}`, 0},
+		{`time=2024-05-01T10:00:00Z level=info msg="started"`, LevelInfo},
+		{`ts=1714557600 lvl=warn msg="slow request"`, LevelWarn},
+		{`severity="error" msg=failed`, LevelError},
+		{`msg="level=error is not the level" foo=bar`, 0},
+		{`msg="retrying level=error later" level=info`, LevelInfo},
+		{"level=error msg=failed\n  at main.go:12", LevelError},
+		{`ERROR something failed`, 0},
+		{`retrying with level=debug`, 0},
+		{`{broken json level=fatal`, 0},
+	}
+
+	for _, tt := range tests {
+		level, ok := lineLevel(tt.msg)
+		if ok != (tt.expected != 0) || level != tt.expected {
+			t.Errorf("%q: expected level %d, but actual %d (%v)", tt.msg, tt.expected, level, ok)
+		}
+	}
+}
+
+func TestIsBelowLevel(t *testing.T) {
+	o := TailOptions{MinLevel: LevelWarn}
+	tests := []struct {
+		msg      string
+		expected bool
+	}{
+		{`{"level":"info"}`, true},
+		{`{"level":"warn"}`, false},
+		{`{"level":"fatal"}`, false},
+		{`level=debug msg=x`, true},
+		{`plain text`, false},
+	}
+	for _, tt := range tests {
+		if actual := o.IsBelowLevel(tt.msg); actual != tt.expected {
+			t.Errorf("%q: expected %v, but actual %v", tt.msg, tt.expected, actual)
+		}
+	}
+
+	if (TailOptions{}).IsBelowLevel(`{"level":"trace"}`) {
+		t.Errorf("expected no line to be below the level when it is not set")
+	}
+}
+
+func TestParseLevel(t *testing.T) {
+	for _, name := range LevelNames {
+		if _, err := ParseLevel(name); err != nil {
+			t.Errorf("%s: unexpected err %v", name, err)
+		}
+	}
+	if level, _ := ParseLevel("WARNING"); level != LevelWarn {
+		t.Errorf("expected WARNING to be %d, but actual %d", LevelWarn, level)
+	}
+	if _, err := ParseLevel("verbose"); err == nil {
+		t.Errorf("expected an error for an unknown level")
+	}
+}
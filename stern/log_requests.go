@@ -0,0 +1,131 @@
+package stern
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Policies for new targets when the maximum number of log requests is reached in follow mode
+const (
+	MaxLogRequestsPolicyError      = "error"
+	MaxLogRequestsPolicyQueue      = "queue"
+	MaxLogRequestsPolicyDropOldest = "drop-oldest" // displaces the tail idle the longest
+	MaxLogRequestsPolicyDropIdlest = "drop-idlest" // alias of drop-oldest
+)
+
+// logRequest is a running tail in follow mode
+type logRequest struct {
+	target    *DockerTarget
+	ctx       context.Context
+	cancel    context.CancelFunc
+	activity  atomic.Int64 // unix nano time of the last log line, or of the start
+	displaced bool         // canceled to make room for a queued target, but not yet done
+}
+
+// logRequests keeps track of the running tails in follow mode to enforce the maximum number of log requests. A slot is
+// held from the registration of a tail until it is done, and a freed slot is handed to the next queued target before
+// any new target.
+type logRequests struct {
+	ctx      context.Context
+	max      int
+	requests map[*DockerTarget]*logRequest
+	queue    []*DockerTarget
+	mu       sync.Mutex
+}
+
+// newLogRequests returns the log requests of tails running until ctx is done
+func newLogRequests(ctx context.Context, max int) *logRequests {
+	return &logRequests{
+		ctx:      ctx,
+		max:      max,
+		requests: make(map[*DockerTarget]*logRequest),
+	}
+}
+
+// add registers the tail of the target if a slot is free, and returns nil otherwise
+func (r *logRequests) add(target *DockerTarget) *logRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.requests) >= r.max {
+		return nil
+	}
+	return r.register(target)
+}
+
+func (r *logRequests) register(target *DockerTarget) *logRequest {
+	ctx, cancel := context.WithCancel(r.ctx)
+	req := &logRequest{target: target, ctx: ctx, cancel: cancel}
+	req.activity.Store(time.Now().UnixNano())
+	r.requests[target] = req
+	return req
+}
+
+// enqueue registers the tail of the target if a slot was freed meanwhile, and otherwise queues the target until a
+// running tail is done and returns nil
+func (r *logRequests) enqueue(target *DockerTarget) *logRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if len(r.requests) < r.max {
+		return r.register(target)
+	}
+	r.queue = append(r.queue, target)
+	return nil
+}
+
+// done removes the tail and returns the registered tail of the next queued target, if any
+func (r *logRequests) done(req *logRequest) *logRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	req.cancel()
+	if r.requests[req.target] != req {
+		return nil
+	}
+	delete(r.requests, req.target)
+	if len(r.queue) == 0 || len(r.requests) >= r.max {
+		return nil
+	}
+	next := r.queue[0]
+	r.queue = r.queue[1:]
+	return r.register(next)
+}
+
+// displaceIdlest stops the tail which has been idle the longest and queues the target to take its slot once the
+// stopped tail is done. It returns the target of the stopped tail, or nil if there is no tail to stop.
+func (r *logRequests) displaceIdlest(target *DockerTarget) *DockerTarget {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var idlest *logRequest
+	for _, req := range r.requests {
+		if req.displaced {
+			continue
+		}
+		if idlest == nil || req.activity.Load() < idlest.activity.Load() {
+			idlest = req
+		}
+	}
+	if idlest == nil {
+		return nil
+	}
+	idlest.displaced = true
+	idlest.cancel()
+	r.queue = append(r.queue, target)
+	return idlest.target
+}
+
+// stop stops the tails of the container on the host of the Docker context and removes it from the queue. The stopped
+// tails are done as usual.
+func (r *logRequests) stop(context, containerId string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for target, req := range r.requests {
+		if target.Context == context && target.Id == containerId {
+			req.cancel()
+		}
+	}
+	r.queue = slices.DeleteFunc(r.queue, func(t *DockerTarget) bool {
+		return t.Context == context && t.Id == containerId
+	})
+}
@@ -0,0 +1,90 @@
+package stern
+
+import (
+	"context"
+	"testing"
+)
+
+func TestLogRequestsQueue(t *testing.T) {
+	requests := newLogRequests(context.Background(), 2)
+	t1, t2, t3, t4 := &DockerTarget{Id: "id1"}, &DockerTarget{Id: "id2"}, &DockerTarget{Id: "id3"}, &DockerTarget{Id: "id4"}
+
+	req1 := requests.add(t1)
+	req2 := requests.add(t2)
+	if req1 == nil || req2 == nil {
+		t.Fatal("expected the tails to be registered")
+	}
+	if req := requests.add(t3); req != nil {
+		t.Fatal("expected no free slot")
+	}
+	if req := requests.enqueue(t3); req != nil {
+		t.Fatal("expected the target to be queued")
+	}
+
+	// The freed slot is handed to the queued target before a new target can take it
+	next := requests.done(req1)
+	if next == nil || next.target != t3 {
+		t.Fatalf("expected the tail of the queued target %v, but actual %v", t3, next)
+	}
+	if req := requests.add(t4); req != nil {
+		t.Errorf("expected the new target not to skip the queue")
+	}
+	if next := requests.done(req2); next != nil {
+		t.Errorf("expected no queued target, but actual %v", next.target)
+	}
+	if req := requests.enqueue(t4); req == nil {
+		t.Errorf("expected the target to be registered in the free slot")
+	}
+}
+
+func TestLogRequestsDisplaceIdlest(t *testing.T) {
+	requests := newLogRequests(context.Background(), 2)
+	t1, t2, t3, t4 := &DockerTarget{Id: "id1"}, &DockerTarget{Id: "id2"}, &DockerTarget{Id: "id3"}, &DockerTarget{Id: "id4"}
+
+	req1 := requests.add(t1)
+	req2 := requests.add(t2)
+	req1.activity.Store(2)
+	req2.activity.Store(1)
+
+	if displaced := requests.displaceIdlest(t3); displaced != t2 {
+		t.Fatalf("expected %v to be displaced, but actual %v", t2, displaced)
+	}
+	if req2.ctx.Err() == nil || req1.ctx.Err() != nil {
+		t.Errorf("expected only the displaced tail to be canceled")
+	}
+	// The slot is held until the displaced tail is done
+	if req := requests.add(t4); req != nil {
+		t.Errorf("expected no free slot before the displaced tail is done")
+	}
+	if displaced := requests.displaceIdlest(t4); displaced != t1 {
+		t.Errorf("expected %v to be displaced, but actual %v", t1, displaced)
+	}
+	if displaced := requests.displaceIdlest(&DockerTarget{Id: "id5"}); displaced != nil {
+		t.Errorf("expected no tail to displace, but actual %v", displaced)
+	}
+	if next := requests.done(req2); next == nil || next.target != t3 {
+		t.Errorf("expected the tail of %v to take the slot of the displaced tail, but actual %v", t3, next)
+	}
+}
+
+func TestLogRequestsStop(t *testing.T) {
+	requests := newLogRequests(context.Background(), 2)
+	t1, t2, t3 := &DockerTarget{Id: "id1"}, &DockerTarget{Id: "id2"}, &DockerTarget{Id: "id3"}
+
+	req1 := requests.add(t1)
+	req2 := requests.add(t2)
+	requests.enqueue(t3)
+	requests.enqueue(&DockerTarget{Id: "id1"})
+
+	requests.stop("", "id1")
+	if req1.ctx.Err() == nil || req2.ctx.Err() != nil {
+		t.Errorf("expected only the stopped tail to be canceled")
+	}
+	// The stopped tail is done as usual and frees its slot for the queued target
+	if next := requests.done(req1); next == nil || next.target != t3 {
+		t.Errorf("expected the tail of the queued target %v, but actual %v", t3, next)
+	}
+	if next := requests.done(req2); next != nil {
+		t.Errorf("expected the stopped container to be removed from the queue, but actual %v", next.target)
+	}
+}
@@ -0,0 +1,77 @@
+package stern
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+const logfmtSpace = " \t\r\n"
+
+// LogfmtPair is a key/value pair of a logfmt line
+type LogfmtPair struct {
+	Key   string
+	Value string
+}
+
+// ParseLogfmt parses a logfmt line like `level=info msg="listening on :8080" port=8080` into its key/value pairs in
+// order. Every field must be a key=value pair, with an optionally quoted value, so that plain text is not mistaken for
+// logfmt.
+func ParseLogfmt(text string) ([]LogfmtPair, error) {
+	var pairs []LogfmtPair
+	s := strings.Trim(text, logfmtSpace)
+	for s != "" {
+		i := strings.IndexAny(s, "=\""+logfmtSpace)
+		if i <= 0 || s[i] != '=' {
+			return nil, fmt.Errorf("expected key=value at %q", s)
+		}
+		key := s[:i]
+		s = s[i+1:]
+
+		var value string
+		if strings.HasPrefix(s, `"`) {
+			end := closingQuote(s)
+			if end < 0 {
+				return nil, fmt.Errorf("unterminated quoted value of %q", key)
+			}
+			var err error
+			if value, err = strconv.Unquote(s[:end+1]); err != nil {
+				return nil, fmt.Errorf("invalid quoted value of %q: %w", key, err)
+			}
+			s = s[end+1:]
+			if s != "" && !strings.ContainsRune(logfmtSpace, rune(s[0])) {
+				return nil, fmt.Errorf("expected a space after the quoted value of %q", key)
+			}
+		} else {
+			end := strings.IndexAny(s, logfmtSpace)
+			if end < 0 {
+				end = len(s)
+			}
+			value = s[:end]
+			if strings.ContainsAny(value, `="`) {
+				return nil, fmt.Errorf("unquoted value of %q contains '=' or '\"'", key)
+			}
+			s = s[end:]
+		}
+		pairs = append(pairs, LogfmtPair{key, value})
+		s = strings.TrimLeft(s, logfmtSpace)
+	}
+	if len(pairs) == 0 {
+		return nil, errors.New("no key=value pairs")
+	}
+	return pairs, nil
+}
+
+// closingQuote returns the index of the quote closing the quoted string at the start of s, or -1 if there is none
+func closingQuote(s string) int {
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '\\':
+			i++
+		case '"':
+			return i
+		}
+	}
+	return -1
+}
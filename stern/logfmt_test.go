@@ -0,0 +1,53 @@
+package stern
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseLogfmt(t *testing.T) {
+	tests := []struct {
+		text      string
+		expected  []LogfmtPair
+		wantError bool
+	}{
+		{
+			`time=2024-05-01T10:00:00Z level=info msg="listening on :8080" port=8080`,
+			[]LogfmtPair{{"time", "2024-05-01T10:00:00Z"}, {"level", "info"}, {"msg", "listening on :8080"}, {"port", "8080"}},
+			false,
+		},
+		{
+			`msg="say \"hi\"\tnow" empty= path=/a\b` + "\n",
+			[]LogfmtPair{{"msg", "say \"hi\"\tnow"}, {"empty", ""}, {"path", `/a\b`}},
+			false,
+		},
+		{`  level=warn   msg=x  `, []LogfmtPair{{"level", "warn"}, {"msg", "x"}}, false},
+		{`Starting server on port=8080`, nil, true},
+		{`GET /api?a=b 200`, nil, true},
+		{`url=/api?a=b`, nil, true},
+		{`msg="unterminated`, nil, true},
+		{`msg="a"b`, nil, true},
+		{`=value`, nil, true},
+		{`{"level":"info"}`, nil, true},
+		{"level=error msg=failed\n  at main.go:12", nil, true},
+		{``, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.text, func(t *testing.T) {
+			actual, err := ParseLogfmt(tt.text)
+			if tt.wantError {
+				if err == nil {
+					t.Errorf("expected error, but got %v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if !reflect.DeepEqual(tt.expected, actual) {
+				t.Errorf("expected %v, but actual %v", tt.expected, actual)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package stern
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	lokiPushPath  = "/loki/api/v1/push"
+	lokiBatchSize = 1000 // lines pushed at once
+)
+
+// lokiBatchWait is the time to wait for more lines before pushing a batch
+var lokiBatchWait = time.Second
+
+// lokiSink pushes the log lines to Loki in batches. Failed pushes are retried with exponential backoff when Loki is
+// unavailable or rate limits, and dropped after sinkMaxRetries.
+type lokiSink struct {
+	*sinkQueue
+	url    string
+	client *http.Client
+}
+
+// newLokiSink returns a started sink pushing to the Loki at u. The push path is added if u has no path.
+func newLokiSink(u *url.URL, errOut io.Writer) *lokiSink {
+	push := *u
+	if push.Path == "" || push.Path == "/" {
+		push.Path = lokiPushPath
+	}
+	s := &lokiSink{
+		sinkQueue: newSinkQueue(SinkLoki, errOut),
+		url:       push.String(),
+		client:    &http.Client{Timeout: 10 * time.Second},
+	}
+	go s.run()
+	return s
+}
+
+func (s *lokiSink) run() {
+	defer close(s.done)
+
+	var batch []Log
+	timer := time.NewTimer(lokiBatchWait)
+	timer.Stop()
+	for {
+		select {
+		case vm, ok := <-s.entries:
+			if !ok {
+				if len(batch) > 0 {
+					s.push(batch)
+				}
+				return
+			}
+			if len(batch) == 0 {
+				timer.Reset(lokiBatchWait)
+			}
+			batch = append(batch, vm)
+			if len(batch) >= lokiBatchSize {
+				timer.Stop()
+				s.push(batch)
+				batch = nil
+			}
+		case <-timer.C:
+			s.push(batch)
+			batch = nil
+		}
+	}
+}
+
+// push pushes the batch, retrying until it is pushed, the error is permanent, or the sink is closed
+func (s *lokiSink) push(batch []Log) {
+	body, err := json.Marshal(lokiPushRequest(batch))
+	if err == nil {
+		err = s.retry(func() (bool, error) { return s.post(body) })
+	}
+	if err != nil {
+		fmt.Fprintf(s.errOut, "loki: failed to push %d lines: %v\n", len(batch), err)
+	}
+}
+
+// post posts the body and returns whether a failed push is to be retried
+func (s *lokiSink) post(body []byte) (bool, error) {
+	resp, err := s.client.Post(s.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode/100 == 2 {
+		io.Copy(io.Discard, resp.Body)
+		return false, nil
+	}
+
+	msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	return resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500, err
+}
+
+type lokiPush struct {
+	Streams []lokiStream `json:"streams"`
+}
+
+type lokiStream struct {
+	Stream map[string]string `json:"stream"`
+	Values [][2]string       `json:"values"`
+}
+
+// lokiPushRequest groups the lines by their labels into streams. Lines without a timestamp get the current time.
+func lokiPushRequest(batch []Log) lokiPush {
+	var push lokiPush
+	streams := make(map[string]int)
+	now := time.Now()
+	for _, vm := range batch {
+		labels := lokiLabels(vm)
+		key := lokiStreamKey(labels)
+		i, ok := streams[key]
+		if !ok {
+			i = len(push.Streams)
+			streams[key] = i
+			push.Streams = append(push.Streams, lokiStream{Stream: labels})
+		}
+		timestamp := vm.Timestamp
+		if timestamp.IsZero() {
+			timestamp = now
+		}
+		push.Streams[i].Values = append(push.Streams[i].Values,
+			[2]string{strconv.FormatInt(timestamp.UnixNano(), 10), vm.Message})
+	}
+	return push
+}
+
+// lokiLabels returns the stream labels of the line: the compose project as namespace, the service, container, stream,
+// Docker context, and the Compose project and service labels. Other container labels are left out as every label name
+// counts towards the label limit of Loki, and their values would multiply the streams.
+func lokiLabels(vm Log) map[string]string {
+	labels := make(map[string]string)
+	set := func(name, value string) {
+		if value != "" {
+			labels[name] = value
+		}
+	}
+	project, _ := composeLabel(vm.Labels, "project")
+	service, _ := composeLabel(vm.Labels, "service")
+	set("compose_project", project)
+	set("compose_service", service)
+	set("namespace", vm.Namespace)
+	set("service", vm.ServiceName)
+	set("container", vm.ContainerName)
+	set("stream", vm.Stream)
+	set("context", vm.Context)
+	return labels
+}
+
+func lokiStreamKey(labels map[string]string) string {
+	var b strings.Builder
+	for _, k := range slices.Sorted(maps.Keys(labels)) {
+		b.WriteString(k)
+		b.WriteByte('=')
+		b.WriteString(labels[k])
+		b.WriteByte(0)
+	}
+	return b.String()
+}
@@ -0,0 +1,210 @@
+package stern
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lokiStandIn records the pushes it receives, failing the first pushes with the given status codes
+type lokiStandIn struct {
+	failures []int
+	pushes   []lokiPush
+	requests int
+	mu       sync.Mutex
+}
+
+func (l *lokiStandIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.requests++
+	if r.URL.Path != lokiPushPath || r.Header.Get("Content-Type") != "application/json" {
+		http.Error(w, "unexpected request", http.StatusNotFound)
+		return
+	}
+	if len(l.failures) > 0 {
+		status := l.failures[0]
+		l.failures = l.failures[1:]
+		http.Error(w, "failure", status)
+		return
+	}
+	var push lokiPush
+	if err := json.NewDecoder(r.Body).Decode(&push); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	l.pushes = append(l.pushes, push)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// waitForPushes waits until the stand-in has received n pushes
+func (l *lokiStandIn) waitForPushes(t *testing.T, n int) {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(time.Millisecond) {
+		l.mu.Lock()
+		pushed := len(l.pushes)
+		l.mu.Unlock()
+		if pushed >= n {
+			return
+		}
+	}
+	t.Fatalf("expected %d pushes", n)
+}
+
+func setLokiTimings(t *testing.T) {
+	setSinkBackoff(t)
+	batchWait := lokiBatchWait
+	lokiBatchWait = 10 * time.Millisecond
+	t.Cleanup(func() { lokiBatchWait = batchWait })
+}
+
+func TestLokiSink(t *testing.T) {
+	setLokiTimings(t)
+	loki := &lokiStandIn{failures: []int{http.StatusServiceUnavailable, http.StatusTooManyRequests}}
+	server := httptest.NewServer(loki)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL)
+	errOut := new(bytes.Buffer)
+	s := newLokiSink(u, errOut)
+	ts := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	web := Log{
+		ContainerName: "shop-web-1",
+		ServiceName:   "web",
+		Namespace:     "shop",
+		Stream:        StreamStdout,
+		Labels:        map[string]string{"com.docker.compose.project": "shop", "team": "checkout"},
+	}
+	for i, msg := range []string{"line 1", "line 2"} {
+		vm := web
+		vm.Message = msg
+		vm.Timestamp = ts.Add(time.Duration(i) * time.Second)
+		s.send(vm)
+	}
+	s.send(Log{Message: "error 1", ContainerName: "nginx", ServiceName: "nginx", Stream: StreamStderr, Timestamp: ts})
+	// The batch is retried until the sink is closed
+	loki.waitForPushes(t, 1)
+	s.close()
+	s.send(Log{Message: "after close"})
+
+	expected := []lokiPush{{Streams: []lokiStream{
+		{
+			Stream: map[string]string{"compose_project": "shop", "namespace": "shop", "service": "web", "container": "shop-web-1", "stream": "stdout"},
+			Values: [][2]string{{"1714557600000000000", "line 1"}, {"1714557601000000000", "line 2"}},
+		},
+		{
+			Stream: map[string]string{"service": "nginx", "container": "nginx", "stream": "stderr"},
+			Values: [][2]string{{"1714557600000000000", "error 1"}},
+		},
+	}}}
+	if !reflect.DeepEqual(expected, loki.pushes) {
+		t.Errorf("expected %v, but actual %v", expected, loki.pushes)
+	}
+	if loki.requests != 3 {
+		t.Errorf("expected 3 requests, but actual %d", loki.requests)
+	}
+	if errOut.Len() != 0 {
+		t.Errorf("expected no errors, but actual %q", errOut)
+	}
+}
+
+func TestLokiSinkPermanentFailure(t *testing.T) {
+	setLokiTimings(t)
+	loki := &lokiStandIn{failures: []int{http.StatusBadRequest}}
+	server := httptest.NewServer(loki)
+	defer server.Close()
+
+	u, _ := url.Parse(server.URL + lokiPushPath)
+	errOut := new(bytes.Buffer)
+	s := newLokiSink(u, errOut)
+	s.send(Log{Message: "line 1", ContainerName: "nginx"})
+	s.close()
+
+	if loki.requests != 1 || len(loki.pushes) != 0 {
+		t.Errorf("expected a single failed request, but actual %d requests and %v", loki.requests, loki.pushes)
+	}
+	if expected := "loki: failed to push 1 lines: 400 Bad Request: failure\n"; errOut.String() != expected {
+		t.Errorf("expected %q, but actual %q", expected, errOut)
+	}
+}
+
+func TestLokiSinkBufferFull(t *testing.T) {
+	errOut := new(bytes.Buffer)
+	s := newSinkQueue(SinkLoki, errOut)
+	s.entries = make(chan Log, 1)
+	for _, msg := range []string{"line 1", "line 2", "line 3"} {
+		s.send(Log{Message: msg})
+	}
+	close(s.done) // nothing is sent without running a sink
+	s.close()
+
+	if vm := <-s.entries; vm.Message != "line 1" {
+		t.Errorf("expected the first line to be buffered, but actual %q", vm.Message)
+	}
+	expected := "loki: buffer full, dropping log lines\nloki: dropped 2 log lines\n"
+	if errOut.String() != expected {
+		t.Errorf("expected %q, but actual %q", expected, errOut)
+	}
+}
+
+func TestLokiLabels(t *testing.T) {
+	tests := []struct {
+		vm       Log
+		expected map[string]string
+	}{
+		{
+			Log{
+				ContainerName: "shop-web-1",
+				ServiceName:   "web",
+				Namespace:     "shop",
+				Stream:        StreamStdout,
+				Context:       "staging-1",
+				Labels: map[string]string{
+					"com.docker.compose.project":       "shop",
+					"com.docker.compose.service":       "web",
+					"org.opencontainers.image.version": "1.2.3",
+					"traefik.http.routers.web.rule":    "Host(`shop.example.com`)",
+					"com.example.team":                 "checkout",
+				},
+			},
+			map[string]string{
+				"compose_project": "shop",
+				"compose_service": "web",
+				"namespace":       "shop",
+				"service":         "web",
+				"container":       "shop-web-1",
+				"stream":          "stdout",
+				"context":         "staging-1",
+			},
+		},
+		{
+			Log{
+				ContainerName: "shop_web_1",
+				ServiceName:   "web",
+				Namespace:     "shop",
+				Stream:        StreamStderr,
+				Labels:        map[string]string{"io.podman.compose.project": "shop", "io.podman.compose.service": "web"},
+			},
+			map[string]string{
+				"compose_project": "shop",
+				"compose_service": "web",
+				"namespace":       "shop",
+				"service":         "web",
+				"container":       "shop_web_1",
+				"stream":          "stderr",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		if actual := lokiLabels(tt.vm); !reflect.DeepEqual(tt.expected, actual) {
+			t.Errorf("expected %v, but actual %v", tt.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,108 @@
+package stern
+
+import (
+	"sync"
+	"time"
+)
+
+// logEntry is a parsed log line, or a multiline event, on its way through the TailOptions pipeline
+type logEntry struct {
+	timestamp string // RFC3339Nano timestamp, empty when unknown
+	stream    string
+	task      swarmTask
+	container string // container of a line read from docker compose logs output
+	content   string
+}
+
+// multilineGrouper groups continuation lines, e.g. stack traces, with the line starting the event so that
+// include/exclude/highlight and templates act on the whole event. Lines are grouped per stream and Swarm task, or
+// container, as they may be interleaved. A pending event is emitted when the next event starts, when no line has been
+// added to it for TailOptions.MultilineTimeout, or when flushed.
+type multilineGrouper struct {
+	options *TailOptions
+	emit    func(logEntry)
+	pending map[string]*pendingEvent
+	order   []string
+	mu      sync.Mutex
+}
+
+// pendingEvent is an event waiting for its continuation lines, with the timer emitting it after the timeout
+type pendingEvent struct {
+	entry logEntry
+	timer *time.Timer
+}
+
+func newMultilineGrouper(options *TailOptions, emit func(logEntry)) *multilineGrouper {
+	return &multilineGrouper{
+		options: options,
+		emit:    emit,
+		pending: make(map[string]*pendingEvent),
+	}
+}
+
+func (g *multilineGrouper) add(e logEntry) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if !g.options.IsMultiline() {
+		g.emit(e)
+		return
+	}
+
+	key := e.stream + "/" + e.task.name + "/" + e.container
+	if p, ok := g.pending[key]; ok {
+		if g.options.IsMultilineContinuation(e.content) &&
+			(g.options.MaxLineSize == 0 || len(p.entry.content)+1+len(e.content) <= g.options.MaxLineSize) {
+			p.entry.content += "\n" + e.content
+			if p.timer != nil {
+				p.timer.Reset(g.options.MultilineTimeout)
+			}
+			return
+		}
+		g.emitPending(key)
+	}
+
+	p := &pendingEvent{entry: e}
+	if g.options.MultilineTimeout > 0 {
+		p.timer = time.AfterFunc(g.options.MultilineTimeout, func() {
+			g.timeout(key, p)
+		})
+	}
+	g.pending[key] = p
+	g.order = append(g.order, key)
+}
+
+// timeout emits the pending event of the key unless it was already emitted
+func (g *multilineGrouper) timeout(key string, p *pendingEvent) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	if g.pending[key] == p {
+		g.emitPending(key)
+	}
+}
+
+// flush emits all pending events
+func (g *multilineGrouper) flush() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	for len(g.order) > 0 {
+		g.emitPending(g.order[0])
+	}
+}
+
+func (g *multilineGrouper) emitPending(key string) {
+	p := g.pending[key]
+	delete(g.pending, key)
+	for i, k := range g.order {
+		if k == key {
+			g.order = append(g.order[:i], g.order[i+1:]...)
+			break
+		}
+	}
+	if p.timer != nil {
+		p.timer.Stop()
+	}
+	g.emit(p.entry)
+}
@@ -0,0 +1,210 @@
+package stern
+
+import (
+	"reflect"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestMultilineGrouper(t *testing.T) {
+	tests := []struct {
+		name     string
+		options  *TailOptions
+		entries  []logEntry
+		expected []logEntry
+	}{
+		{
+			name:    "no multiline options",
+			options: &TailOptions{},
+			entries: []logEntry{
+				{content: "line 1"},
+				{content: "  line 2"},
+			},
+			expected: []logEntry{
+				{content: "line 1"},
+				{content: "  line 2"},
+			},
+		},
+		{
+			name:    "multiline pattern",
+			options: &TailOptions{MultilinePattern: regexp.MustCompile(`^\s`)},
+			entries: []logEntry{
+				{timestamp: "ts1", content: "Exception"},
+				{timestamp: "ts2", content: "  at a"},
+				{timestamp: "ts3", content: "  at b"},
+				{timestamp: "ts4", content: "next"},
+			},
+			expected: []logEntry{
+				{timestamp: "ts1", content: "Exception\n  at a\n  at b"},
+				{timestamp: "ts4", content: "next"},
+			},
+		},
+		{
+			name:    "multiline start",
+			options: &TailOptions{MultilineStart: regexp.MustCompile(`^\d{4}-`)},
+			entries: []logEntry{
+				{content: "2024-01-01 ERROR boom"},
+				{content: "Traceback"},
+				{content: "  File x"},
+				{content: "2024-01-01 INFO ok"},
+			},
+			expected: []logEntry{
+				{content: "2024-01-01 ERROR boom\nTraceback\n  File x"},
+				{content: "2024-01-01 INFO ok"},
+			},
+		},
+		{
+			name:    "leading continuation starts an event",
+			options: &TailOptions{MultilinePattern: regexp.MustCompile(`^\s`)},
+			entries: []logEntry{
+				{content: "  at a"},
+				{content: "  at b"},
+			},
+			expected: []logEntry{
+				{content: "  at a\n  at b"},
+			},
+		},
+		{
+			name:    "streams are grouped separately",
+			options: &TailOptions{MultilinePattern: regexp.MustCompile(`^\s`)},
+			entries: []logEntry{
+				{stream: StreamStderr, content: "Exception"},
+				{stream: StreamStdout, content: "request"},
+				{stream: StreamStderr, content: "  at a"},
+				{stream: StreamStdout, content: "next request"},
+			},
+			expected: []logEntry{
+				{stream: StreamStdout, content: "request"},
+				{stream: StreamStderr, content: "Exception\n  at a"},
+				{stream: StreamStdout, content: "next request"},
+			},
+		},
+		{
+			name: "max line size",
+			options: &TailOptions{
+				MultilinePattern: regexp.MustCompile(`^\s`),
+				MaxLineSize:      10,
+			},
+			entries: []logEntry{
+				{content: "line"},
+				{content: " 1"},
+				{content: " 2"},
+				{content: " 3"},
+			},
+			expected: []logEntry{
+				{content: "line\n 1\n 2"},
+				{content: " 3"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			actual := []logEntry{}
+			g := newMultilineGrouper(tt.options, func(e logEntry) {
+				actual = append(actual, e)
+			})
+			for _, e := range tt.entries {
+				g.add(e)
+			}
+			g.flush()
+
+			if !reflect.DeepEqual(tt.expected, actual) {
+				t.Errorf("expected %q, but actual %q", tt.expected, actual)
+			}
+		})
+	}
+}
+
+func TestMultilineGrouperTimeout(t *testing.T) {
+	var mu sync.Mutex
+	actual := []logEntry{}
+	g := newMultilineGrouper(&TailOptions{
+		MultilinePattern: regexp.MustCompile(`^\s`),
+		MultilineTimeout: 10 * time.Millisecond,
+	}, func(e logEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		actual = append(actual, e)
+	})
+	g.add(logEntry{content: "Exception"})
+	g.add(logEntry{content: "  at a"})
+
+	expected := []logEntry{{content: "Exception\n  at a"}}
+	deadline := time.Now().Add(time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		done := len(actual) > 0
+		mu.Unlock()
+		if done {
+			break
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if !reflect.DeepEqual(expected, actual) {
+		t.Errorf("expected %q, but actual %q", expected, actual)
+	}
+}
+
+func TestMultilineGrouperTimeoutPerStream(t *testing.T) {
+	emitted := make(chan logEntry, 10)
+	g := newMultilineGrouper(&TailOptions{
+		MultilinePattern: regexp.MustCompile(`^\s`),
+		MultilineTimeout: 50 * time.Millisecond,
+	}, func(e logEntry) {
+		emitted <- e
+	})
+	defer g.flush()
+	g.add(logEntry{stream: StreamStderr, content: "Exception"})
+	g.add(logEntry{stream: StreamStderr, content: "  at a"})
+
+	// The busy stdout does not hold back the stack trace on stderr
+	start := time.Now()
+	g.add(logEntry{stream: StreamStdout, content: "GET /"})
+	for {
+		select {
+		case e := <-emitted:
+			expected := logEntry{stream: StreamStderr, content: "Exception\n  at a"}
+			if !reflect.DeepEqual(expected, e) {
+				t.Errorf("expected %q, but actual %q", expected, e)
+			}
+			return
+		case <-time.After(10 * time.Millisecond):
+			if time.Since(start) > time.Second {
+				t.Fatal("timed out waiting for the stack trace")
+			}
+			g.add(logEntry{stream: StreamStdout, content: "  continued"})
+		}
+	}
+}
+
+func TestMultilineGrouperFlushStopsTimers(t *testing.T) {
+	var emitted []logEntry
+	g := newMultilineGrouper(&TailOptions{
+		MultilinePattern: regexp.MustCompile(`^\s`),
+		MultilineTimeout: time.Hour,
+	}, func(e logEntry) {
+		emitted = append(emitted, e)
+	})
+	g.add(logEntry{stream: StreamStdout, content: "GET /"})
+	g.add(logEntry{stream: StreamStderr, content: "Exception"})
+	var timers []*time.Timer
+	for _, p := range g.pending {
+		timers = append(timers, p.timer)
+	}
+	g.flush()
+
+	if len(emitted) != 2 {
+		t.Errorf("expected 2 events, but actual %q", emitted)
+	}
+	for _, timer := range timers {
+		if timer.Stop() {
+			t.Errorf("expected the timers to be stopped by the flush")
+		}
+	}
+}
@@ -0,0 +1,275 @@
+package stern
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// rotatedOutputTimeFormat is the time format of the name of rotated output files, like web-1-2024-05-01T10-00-00.000.log
+const rotatedOutputTimeFormat = "2006-01-02T15-04-05.000"
+
+// colorEscape matches the SGR escape sequences of colors
+var colorEscape = regexp.MustCompile(`\x1b\[[0-9;]*m`)
+
+// outputDir writes the rendered lines of each container to its own file in a directory. The files are rotated when
+// they would exceed maxSize, or on the first write after they have been open for maxAge, and rotated files are
+// optionally compressed.
+type outputDir struct {
+	dir         string
+	maxSize     int64         // 0 for no rotation by size
+	maxAge      time.Duration // 0 for no rotation by age
+	compress    bool
+	files       map[string]*rotatingFile
+	compressing sync.WaitGroup
+	now         func() time.Time
+	errOut      io.Writer
+	mu          sync.Mutex
+}
+
+func newOutputDir(config *DockerConfig) (*outputDir, error) {
+	if err := os.MkdirAll(config.OutputDir, 0o755); err != nil {
+		return nil, err
+	}
+	return &outputDir{
+		dir:      config.OutputDir,
+		maxSize:  config.OutputMaxSize,
+		maxAge:   config.OutputMaxAge,
+		compress: config.OutputCompress,
+		files:    make(map[string]*rotatingFile),
+		now:      time.Now,
+		errOut:   config.ErrOut,
+	}, nil
+}
+
+// file returns the file of the target, to be closed when the tail ends. Tails of the same container, e.g. after a
+// restart, share the file, which is closed when the last of them is closed.
+func (d *outputDir) file(target *DockerTarget) *rotatingFile {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	path := filepath.Join(d.dir, outputFileName(target))
+	f, ok := d.files[path]
+	if !ok {
+		f = &rotatingFile{path: path, dir: d}
+		d.files[path] = f
+	}
+	f.tails++
+	return f
+}
+
+// release closes the file when no tail uses it anymore, so that the files of removed containers are not kept open
+func (d *outputDir) release(f *rotatingFile) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	f.tails--
+	if f.tails > 0 || d.files[f.path] != f {
+		return
+	}
+	delete(d.files, f.path)
+	if err := f.closeFile(); err != nil {
+		fmt.Fprintf(d.errOut, "failed to close %s: %v\n", f.path, err)
+	}
+}
+
+// close closes the files and waits for the rotated files to be compressed
+func (d *outputDir) close() {
+	d.mu.Lock()
+	for _, f := range d.files {
+		if err := f.closeFile(); err != nil {
+			fmt.Fprintf(d.errOut, "failed to close %s: %v\n", f.path, err)
+		}
+	}
+	d.mu.Unlock()
+	d.compressing.Wait()
+}
+
+// outputFileName returns the path of the file of the target relative to the output directory, e.g. shop/web-1.log
+// for a Compose container, nginx.log for a plain container, or staging-1/shop/web-1.log when tailing multiple hosts
+func outputFileName(target *DockerTarget) string {
+	name := target.Name
+	if target.ComposeProject != "" {
+		name = target.ServiceName
+		if target.ContainerNumber != "" {
+			name += "-" + target.ContainerNumber
+		}
+	}
+	var parts []string
+	for _, part := range []string{target.Context, target.ComposeProject, name + ".log"} {
+		if part != "" {
+			parts = append(parts, strings.ReplaceAll(part, string(filepath.Separator), "_"))
+		}
+	}
+	return filepath.Join(parts...)
+}
+
+// rotatingFile is an output file, opened on the first write
+type rotatingFile struct {
+	path   string
+	dir    *outputDir
+	tails  int // tails using the file, guarded by the mutex of dir
+	f      *os.File
+	size   int64
+	opened time.Time
+	closed bool
+	mu     sync.Mutex
+}
+
+func (f *rotatingFile) Write(p []byte) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if f.closed {
+		return 0, os.ErrClosed
+	}
+	if f.f != nil && f.shouldRotate(len(p)) {
+		if err := f.rotate(); err != nil {
+			return 0, err
+		}
+	}
+	if f.f == nil {
+		if err := f.open(); err != nil {
+			return 0, err
+		}
+	}
+	n, err := f.f.Write(p)
+	f.size += int64(n)
+	return n, err
+}
+
+// open opens the file for appending, a file of a previous run is continued
+func (f *rotatingFile) open() error {
+	if err := os.MkdirAll(filepath.Dir(f.path), 0o755); err != nil {
+		return err
+	}
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	stat, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+	f.f = file
+	f.size = stat.Size()
+	f.opened = f.dir.now()
+	return nil
+}
+
+// shouldRotate returns true if the file is to be rotated before writing n bytes. A line larger than the maximum size
+// is written to an empty file rather than being dropped.
+func (f *rotatingFile) shouldRotate(n int) bool {
+	if f.size == 0 {
+		return false
+	}
+	if f.dir.maxSize > 0 && f.size+int64(n) > f.dir.maxSize {
+		return true
+	}
+	return f.dir.maxAge > 0 && f.dir.now().Sub(f.opened) >= f.dir.maxAge
+}
+
+// rotate closes the file and renames it with the time of the rotation. A sequence number is added when the file was
+// already rotated at the same time, e.g. web-1-2024-05-01T10-00-00.000-1.log.
+func (f *rotatingFile) rotate() error {
+	if err := f.f.Close(); err != nil {
+		return err
+	}
+	f.f = nil
+
+	ext := filepath.Ext(f.path)
+	base := strings.TrimSuffix(f.path, ext) + "-" + f.dir.now().Format(rotatedOutputTimeFormat)
+	rotated := base + ext
+	for i := 1; exists(rotated) || exists(rotated+".gz"); i++ {
+		rotated = base + "-" + strconv.Itoa(i) + ext
+	}
+	if err := os.Rename(f.path, rotated); err != nil {
+		return err
+	}
+	if f.dir.compress {
+		f.dir.compressing.Add(1)
+		go func() {
+			defer f.dir.compressing.Done()
+			if err := compressFile(rotated); err != nil {
+				fmt.Fprintf(f.dir.errOut, "failed to compress %s: %v\n", rotated, err)
+			}
+		}()
+	}
+	return nil
+}
+
+// Close releases the file of a tail
+func (f *rotatingFile) Close() error {
+	f.dir.release(f)
+	return nil
+}
+
+func (f *rotatingFile) closeFile() error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.closed = true
+	if f.f == nil {
+		return nil
+	}
+	err := f.f.Close()
+	f.f = nil
+	return err
+}
+
+func exists(path string) bool {
+	_, err := os.Lstat(path)
+	return err == nil
+}
+
+// stripColors removes the SGR escape sequences of colors from the rendered line, so that the output files are plain
+// text whether the terminal is colored or not
+func stripColors(line []byte) []byte {
+	if bytes.IndexByte(line, 0x1b) < 0 {
+		return line
+	}
+	return colorEscape.ReplaceAll(line, nil)
+}
+
+// compressFile replaces the file by a gzip compressed file with the .gz suffix. The file is compressed to a temporary
+// file renamed when complete, so that an interrupted compression never leaves a truncated .gz file behind.
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".gz.*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+	zw := gzip.NewWriter(dst)
+	_, err = io.Copy(zw, src)
+	if err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(dst.Name(), 0o644)
+	}
+	if err == nil {
+		err = os.Rename(dst.Name(), path+".gz")
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
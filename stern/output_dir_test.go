@@ -0,0 +1,241 @@
+package stern
+
+import (
+	"compress/gzip"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestOutputFileName(t *testing.T) {
+	tests := []struct {
+		target   DockerTarget
+		expected string
+	}{
+		{DockerTarget{Name: "nginx", ServiceName: "nginx"}, "nginx.log"},
+		{DockerTarget{Name: "shop-web-1", ServiceName: "web", ComposeProject: "shop", ContainerNumber: "1"}, "shop/web-1.log"},
+		{DockerTarget{Name: "shop_web", ServiceName: "web", ComposeProject: "shop", Swarm: true}, "shop/web.log"},
+		{DockerTarget{Name: "nginx", ServiceName: "nginx", Context: "staging/1"}, "staging_1/nginx.log"},
+	}
+
+	for _, tt := range tests {
+		if actual := outputFileName(&tt.target); actual != filepath.FromSlash(tt.expected) {
+			t.Errorf("%v: expected %q, but actual %q", tt.target, tt.expected, actual)
+		}
+	}
+}
+
+func TestOutputDirRotation(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	outputs, err := newOutputDir(&DockerConfig{
+		OutputDir:      dir,
+		OutputMaxSize:  10,
+		OutputCompress: true,
+		ErrOut:         io.Discard,
+	})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	outputs.now = func() time.Time { return now }
+
+	target := &DockerTarget{Name: "shop-web-1", ServiceName: "web", ComposeProject: "shop", ContainerNumber: "1"}
+	f := outputs.file(target)
+	if outputs.file(target) != f {
+		t.Errorf("expected the tails of a container to share the file")
+	}
+
+	write := func(line string) {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	write("line 1\n")
+	write("line 2\n")
+	now = now.Add(time.Minute)
+	write("a line longer than the maximum size\n") // written to the empty file
+	now = now.Add(time.Minute)
+	write("line 4\n")
+	outputs.close()
+
+	if _, err := f.Write([]byte("line 5\n")); err == nil {
+		t.Errorf("expected an error writing to a closed file")
+	}
+
+	expected := map[string]string{
+		"shop/web-1.log": "line 4\n",
+		"shop/web-1-2024-05-01T10-00-00.000.log.gz": "line 1\n",
+		"shop/web-1-2024-05-01T10-01-00.000.log.gz": "line 2\n",
+		"shop/web-1-2024-05-01T10-02-00.000.log.gz": "a line longer than the maximum size\n",
+	}
+	entries, _ := filepath.Glob(filepath.Join(dir, "shop", "*"))
+	if len(entries) != len(expected) {
+		t.Errorf("expected %d files, but actual %v", len(expected), entries)
+	}
+	for name, content := range expected {
+		r, err := os.Open(filepath.Join(dir, filepath.FromSlash(name)))
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			continue
+		}
+		var reader io.Reader = r
+		if filepath.Ext(name) == ".gz" {
+			if reader, err = gzip.NewReader(r); err != nil {
+				t.Fatalf("%s: unexpected error: %v", name, err)
+			}
+		}
+		data, err := io.ReadAll(reader)
+		r.Close()
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", name, err)
+		}
+		if string(data) != content {
+			t.Errorf("%s: expected %q, but actual %q", name, content, data)
+		}
+	}
+}
+
+func TestOutputDirAppendAndRotateByAge(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "nginx.log"), []byte("previous run\n"), 0o644); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	outputs, err := newOutputDir(&DockerConfig{OutputDir: dir, OutputMaxAge: time.Hour, ErrOut: io.Discard})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	outputs.now = func() time.Time { return now }
+
+	f := outputs.file(&DockerTarget{Name: "nginx", ServiceName: "nginx"})
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+		now = now.Add(30 * time.Minute)
+	}
+	outputs.close()
+
+	data, _ := os.ReadFile(filepath.Join(dir, "nginx.log"))
+	if expected := "line 3\n"; string(data) != expected {
+		t.Errorf("expected %q, but actual %q", expected, data)
+	}
+	data, _ = os.ReadFile(filepath.Join(dir, "nginx-2024-05-01T11-00-00.000.log"))
+	if expected := "previous run\nline 1\nline 2\n"; string(data) != expected {
+		t.Errorf("expected %q, but actual %q", expected, data)
+	}
+}
+
+func TestOutputDirRotationSameTime(t *testing.T) {
+	dir := t.TempDir()
+	outputs, err := newOutputDir(&DockerConfig{OutputDir: dir, OutputMaxSize: 10, ErrOut: io.Discard})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	outputs.now = func() time.Time { return now }
+
+	f := outputs.file(&DockerTarget{Name: "nginx", ServiceName: "nginx"})
+	for _, line := range []string{"line 1\n", "line 2\n", "line 3\n"} {
+		if _, err := f.Write([]byte(line)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	outputs.close()
+
+	expected := map[string]string{
+		"nginx.log":                           "line 3\n",
+		"nginx-2024-05-01T10-00-00.000.log":   "line 1\n",
+		"nginx-2024-05-01T10-00-00.000-1.log": "line 2\n",
+	}
+	for name, content := range expected {
+		data, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Errorf("unexpected error: %v", err)
+			continue
+		}
+		if string(data) != content {
+			t.Errorf("%s: expected %q, but actual %q", name, content, data)
+		}
+	}
+}
+
+func TestOutputDirRelease(t *testing.T) {
+	dir := t.TempDir()
+	outputs, err := newOutputDir(&DockerConfig{OutputDir: dir, ErrOut: io.Discard})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer outputs.close()
+
+	target := &DockerTarget{Name: "nginx", ServiceName: "nginx"}
+	f1 := outputs.file(target)
+	f2 := outputs.file(target)
+	if _, err := f1.Write([]byte("line 1\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f1.Close()
+	if _, err := f2.Write([]byte("line 2\n")); err != nil {
+		t.Errorf("expected the file to stay open for the other tail, but actual %v", err)
+	}
+	f2.Close()
+	if len(outputs.files) != 0 || f2.f != nil {
+		t.Errorf("expected the file to be closed when the last tail is closed")
+	}
+
+	// A restarted container continues the file
+	f3 := outputs.file(target)
+	if _, err := f3.Write([]byte("line 3\n")); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	f3.Close()
+	data, _ := os.ReadFile(filepath.Join(dir, "nginx.log"))
+	if expected := "line 1\nline 2\nline 3\n"; string(data) != expected {
+		t.Errorf("expected %q, but actual %q", expected, data)
+	}
+}
+
+func TestStripColors(t *testing.T) {
+	line := "\x1b[32mshop\x1b[0m \x1b[1;31mweb\x1b[0m line 1\n"
+	if actual := string(stripColors([]byte(line))); actual != "shop web line 1\n" {
+		t.Errorf("expected %q, but actual %q", "shop web line 1\n", actual)
+	}
+}
+
+func TestCompressFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "web-1-2024-05-01T10-00-00.000.log")
+	if err := os.WriteFile(path, []byte("line 1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := compressFile(path); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// Only the complete .gz file is left, without the original or the temporary file
+	entries, _ := filepath.Glob(filepath.Join(dir, "*"))
+	if len(entries) != 1 || entries[0] != path+".gz" {
+		t.Fatalf("expected only %s, but actual %v", path+".gz", entries)
+	}
+	stat, err := os.Stat(path + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if mode := stat.Mode().Perm(); mode != 0o644 {
+		t.Errorf("expected mode 0644, but actual %o", mode)
+	}
+	r, err := os.Open(path + ".gz")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	zr, err := gzip.NewReader(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := io.ReadAll(zr); err != nil || string(data) != "line 1\n" {
+		t.Errorf("expected %q, but actual %q (%v)", "line 1\n", data, err)
+	}
+}
@@ -0,0 +1,49 @@
+package stern
+
+import (
+	"github.com/docker/docker/api/types/events"
+)
+
+// Podman's Docker compatible API differs in a few details which are smoothed over here. podman-compose labels the
+// containers with io.podman.compose.* labels, and the events carry Podman's own action names and attributes.
+
+// Podman event actions differing from Docker
+const (
+	podmanActionDied   events.Action = "died"
+	podmanActionRemove events.Action = "remove"
+)
+
+// composeLabel returns the value of the Docker Compose label, or of the corresponding podman-compose label
+func composeLabel(labels map[string]string, name string) (string, bool) {
+	if v, ok := labels["com.docker.compose."+name]; ok {
+		return v, true
+	}
+	v, ok := labels["io.podman.compose."+name]
+	return v, ok
+}
+
+// normalizeEvent translates a Podman container event to its Docker equivalent
+func normalizeEvent(e events.Message) events.Message {
+	switch e.Action {
+	case podmanActionDied:
+		e.Action = events.ActionDie
+	case podmanActionRemove:
+		e.Action = events.ActionDestroy
+	case events.ActionHealthStatus:
+		// Podman sends the status as an attribute instead of as part of the action
+		if status, ok := e.Actor.Attributes["health_status"]; ok {
+			e.Action = events.Action(string(events.ActionHealthStatus) + ": " + status)
+		}
+	}
+	if _, ok := e.Actor.Attributes["exitCode"]; !ok {
+		if exitCode, ok := e.Actor.Attributes["containerExitCode"]; ok {
+			attrs := make(map[string]string, len(e.Actor.Attributes)+1)
+			for k, v := range e.Actor.Attributes {
+				attrs[k] = v
+			}
+			attrs["exitCode"] = exitCode
+			e.Actor.Attributes = attrs
+		}
+	}
+	return e
+}
@@ -0,0 +1,221 @@
+package stern
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/api/types/events"
+	dockerclient "github.com/docker/docker/client"
+)
+
+// The fixtures in testdata/podman are the list, inspect and event responses of the Docker compatible API of Podman for
+// a container created by podman-compose, with both the com.docker.compose.* and io.podman.compose.* labels that
+// podman-compose sets. They are written by hack/record-podman-fixtures.sh, which is to be rerun against a Podman
+// socket to replace the current handwritten ones with recorded responses.
+
+func readPodmanInspect(t *testing.T) container.InspectResponse {
+	t.Helper()
+	b, err := os.ReadFile("testdata/podman/container_inspect.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var c container.InspectResponse
+	if err := json.Unmarshal(b, &c); err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+func readPodmanEvents(t *testing.T) []events.Message {
+	t.Helper()
+	f, err := os.Open("testdata/podman/events.jsonl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var messages []events.Message
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var e events.Message
+		if err := json.Unmarshal(scanner.Bytes(), &e); err != nil {
+			t.Fatal(err)
+		}
+		messages = append(messages, e)
+	}
+	return messages
+}
+
+func TestPodmanTargetFilter(t *testing.T) {
+	re := regexp.MustCompile
+	tests := []struct {
+		name    string
+		config  dockerTargetFilterConfig
+		matches bool
+	}{
+		{
+			"compose and container query",
+			dockerTargetFilterConfig{
+				composeProjectFilter: []*regexp.Regexp{re(`^shop$`)},
+				containerFilter:      []*regexp.Regexp{re(`^web$`)},
+				healthFilter:         []string{"healthy"},
+				volumeFilter:         []string{"shop_static"},
+				publishFilter:        []string{"8080/tcp"},
+			},
+			true,
+		},
+		{
+			"other compose project",
+			dockerTargetFilterConfig{composeProjectFilter: []*regexp.Regexp{re(`^blog$`)}},
+			false,
+		},
+		{
+			"other service",
+			dockerTargetFilterConfig{containerFilter: []*regexp.Regexp{re(`^db$`)}},
+			false,
+		},
+		{
+			"excluded service",
+			dockerTargetFilterConfig{containerExcludeFilter: []*regexp.Regexp{re(`^web$`)}},
+			false,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			filter := newDockerTargetFilter(tt.config, 10)
+			var actual []*DockerTarget
+			filter.visit(readPodmanInspect(t), func(t *DockerTarget) {
+				actual = append(actual, t)
+			})
+
+			if !tt.matches {
+				if len(actual) != 0 {
+					t.Errorf("expected no targets, but actual %+v", actual[0])
+				}
+				return
+			}
+			if len(actual) != 1 {
+				t.Fatalf("expected 1 target, but actual %d", len(actual))
+			}
+			target := actual[0]
+			if target.Name != "shop_web_1" || target.ComposeProject != "shop" || target.ServiceName != "web" ||
+				target.ContainerNumber != "1" {
+				t.Errorf("unexpected target %+v", target)
+			}
+		})
+	}
+}
+
+func TestPodmanContainerList(t *testing.T) {
+	list, err := os.ReadFile("testdata/podman/containers_list.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	inspect, err := os.ReadFile("testdata/podman/container_inspect.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /v1.41/containers/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(list)
+	})
+	mux.HandleFunc("GET /v1.41/containers/{id}/json", func(w http.ResponseWriter, r *http.Request) {
+		w.Write(inspect)
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+	client, err := dockerclient.NewClientWithOpts(
+		dockerclient.WithHost("tcp://"+strings.TrimPrefix(server.URL, "http://")),
+		dockerclient.WithVersion("1.41"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	filter := newDockerTargetFilter(dockerTargetFilterConfig{
+		composeProjectFilter: []*regexp.Regexp{regexp.MustCompile(`^shop$`)},
+		containerFilter:      []*regexp.Regexp{regexp.MustCompile(`^web$`)},
+	}, 10)
+	targets, err := FilteredContainerGenerator(context.Background(), &DockerConfig{ErrOut: io.Discard}, client, filter)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var actual []*DockerTarget
+	for target := range targets {
+		actual = append(actual, target)
+	}
+	if len(actual) != 1 {
+		t.Fatalf("expected 1 target, but actual %d", len(actual))
+	}
+	if target := actual[0]; target.ComposeProject != "shop" || target.ServiceName != "web" || target.ContainerNumber != "1" {
+		t.Errorf("unexpected target %+v", target)
+	}
+}
+
+func TestPodmanEvents(t *testing.T) {
+	messages := readPodmanEvents(t)
+	if len(messages) == 0 {
+		t.Fatal("expected events")
+	}
+
+	// Podman sends a health_status event for every health check, the recorded events are looked up by their action
+	var start, unhealthy, die, destroy *events.Message
+	for i, e := range messages {
+		original := e.Action
+		n := normalizeEvent(e)
+		if e.Action != original {
+			t.Errorf("%d: expected the original event to be unchanged", i)
+		}
+		if _, ok := e.Actor.Attributes["exitCode"]; ok {
+			t.Errorf("%d: expected the original attributes to be unchanged", i)
+		}
+		switch n.Action {
+		case events.ActionStart:
+			start = &n
+		case events.ActionHealthStatusUnhealthy:
+			unhealthy = &n
+		case events.ActionDie:
+			die = &n
+		case events.ActionDestroy:
+			destroy = &n
+		case events.ActionHealthStatusHealthy:
+		default:
+			t.Errorf("%d: unexpected action %q", i, n.Action)
+		}
+	}
+	if start == nil || unhealthy == nil || die == nil || destroy == nil {
+		t.Fatalf("expected start, unhealthy, die and destroy events, but actual %+v", messages)
+	}
+
+	dieEvent := newDieEvent(*die, nil)
+	if dieEvent.ExitCode != 137 || dieEvent.ServiceName != "web" || dieEvent.Namespace != "shop" {
+		t.Errorf("unexpected die event %+v", dieEvent)
+	}
+	health := newHealthEvent(*unhealthy)
+	if health.Health != "unhealthy" {
+		t.Errorf("expected health unhealthy, but actual %q", health.Health)
+	}
+}
+
+func TestComposeLabelPodmanFallback(t *testing.T) {
+	labels := map[string]string{"io.podman.compose.project": "shop", "com.docker.compose.service": "web"}
+	if v, ok := composeLabel(labels, "project"); !ok || v != "shop" {
+		t.Errorf("expected the podman-compose project, but actual %q", v)
+	}
+	if v, ok := composeLabel(labels, "service"); !ok || v != "web" {
+		t.Errorf("expected the Compose service, but actual %q", v)
+	}
+	if _, ok := composeLabel(labels, "container-number"); ok {
+		t.Errorf("expected no container number")
+	}
+}
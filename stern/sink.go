@@ -0,0 +1,217 @@
+package stern
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Types of the sinks that logs are forwarded to
+const (
+	SinkLoki   = "loki"
+	SinkSyslog = "syslog"
+	SinkGELF   = "gelf"
+)
+
+// SinkTypes are the types of sinks accepted by ParseSink
+var SinkTypes = []string{SinkLoki, SinkSyslog, SinkGELF}
+
+const (
+	sinkBufferSize = 10000 // lines waiting to be sent, further lines are dropped
+	sinkMaxRetries = 10
+)
+
+var (
+	sinkMinBackoff = 500 * time.Millisecond
+	sinkMaxBackoff = 30 * time.Second
+)
+
+// SinkConfig is a sink that the log lines are forwarded to, in addition to being printed
+type SinkConfig struct {
+	Type string
+	URL  *url.URL
+}
+
+// ParseSink parses a sink like loki=http://localhost:3100, syslog=tcp://localhost:514, or gelf=udp://localhost:12201.
+// The default port is added to syslog and GELF URLs without one.
+func ParseSink(spec string) (SinkConfig, error) {
+	typ, rawURL, ok := strings.Cut(spec, "=")
+	if !ok || rawURL == "" {
+		return SinkConfig{}, fmt.Errorf("sink %q should be like <type>=<url>", spec)
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return SinkConfig{}, fmt.Errorf("sink %q: %w", spec, err)
+	}
+
+	var schemes []string
+	var example, defaultPort string
+	switch typ {
+	case SinkLoki:
+		schemes, example = []string{"http", "https"}, "http://localhost:3100"
+	case SinkSyslog:
+		schemes, example = []string{"udp", "tcp", "tls"}, "udp://localhost:514, tcp://... or tls://..."
+		defaultPort = "514"
+		if u.Scheme == "tls" {
+			defaultPort = "6514"
+		}
+	case SinkGELF:
+		schemes, example = []string{"udp", "tcp"}, "udp://localhost:12201 or tcp://..."
+		defaultPort = "12201"
+	default:
+		return SinkConfig{}, fmt.Errorf("sink %q: type should be one of %s", spec, strings.Join(SinkTypes, ", "))
+	}
+	if !slices.Contains(schemes, u.Scheme) || u.Hostname() == "" {
+		return SinkConfig{}, fmt.Errorf("sink %q: the URL of %s should be like %s", spec, typ, example)
+	}
+	if defaultPort != "" && u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), defaultPort)
+	}
+	return SinkConfig{Type: typ, URL: u}, nil
+}
+
+// sink forwards log lines. Sending must not block the tails, lines are dropped if the sink cannot keep up.
+type sink interface {
+	send(vm Log)
+	// close sends the pending lines and stops the sink
+	close()
+}
+
+func newSink(config SinkConfig, errOut io.Writer) (sink, error) {
+	switch config.Type {
+	case SinkLoki:
+		return newLokiSink(config.URL, errOut), nil
+	case SinkSyslog:
+		return newSyslogSink(config.URL, errOut), nil
+	case SinkGELF:
+		return newGELFSink(config.URL, errOut), nil
+	}
+	return nil, fmt.Errorf("unknown sink type %q", config.Type)
+}
+
+type sinks []sink
+
+func (s sinks) send(vm Log) {
+	for _, sink := range s {
+		sink.send(vm)
+	}
+}
+
+func (s sinks) close() {
+	for _, sink := range s {
+		sink.close()
+	}
+}
+
+// sinkQueue is the bounded queue of a sink, whose lines are sent by a goroutine of the sink that closes done when the
+// queue is drained after closing
+type sinkQueue struct {
+	name    string
+	entries chan Log
+	dropped atomic.Int64
+	closing chan struct{}
+	closed  bool
+	done    chan struct{}
+	errOut  io.Writer
+	mu      sync.RWMutex
+}
+
+func newSinkQueue(name string, errOut io.Writer) *sinkQueue {
+	return &sinkQueue{
+		name:    name,
+		entries: make(chan Log, sinkBufferSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+		errOut:  errOut,
+	}
+}
+
+func (q *sinkQueue) send(vm Log) {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+	if q.closed {
+		return
+	}
+	select {
+	case q.entries <- vm:
+	default:
+		if q.dropped.Add(1) == 1 {
+			fmt.Fprintf(q.errOut, "%s: buffer full, dropping log lines\n", q.name)
+		}
+	}
+}
+
+// close stops accepting lines and waits for the pending lines to be sent, without retrying
+func (q *sinkQueue) close() {
+	q.mu.Lock()
+	if q.closed {
+		q.mu.Unlock()
+		return
+	}
+	q.closed = true
+	close(q.closing)
+	close(q.entries)
+	q.mu.Unlock()
+
+	<-q.done
+	if dropped := q.dropped.Load(); dropped > 0 {
+		fmt.Fprintf(q.errOut, "%s: dropped %d log lines\n", q.name, dropped)
+	}
+}
+
+// isClosing returns true once the sink is being closed
+func (q *sinkQueue) isClosing() bool {
+	select {
+	case <-q.closing:
+		return true
+	default:
+		return false
+	}
+}
+
+// retry calls f until it succeeds, with exponential backoff, and returns the last error if it still fails after
+// sinkMaxRetries or when the sink is closed
+func (q *sinkQueue) retry(f func() (retry bool, err error)) error {
+	backoff := sinkMinBackoff
+	for attempt := 0; ; attempt++ {
+		retry, err := f()
+		if err == nil || !retry || attempt == sinkMaxRetries {
+			return err
+		}
+		select {
+		case <-time.After(backoff):
+		case <-q.closing:
+			return err
+		}
+		backoff = min(backoff*2, sinkMaxBackoff)
+	}
+}
+
+// sinkHostname is the host reported by the syslog and GELF sinks
+func sinkHostname() string {
+	hostname, err := os.Hostname()
+	if err != nil || hostname == "" {
+		return "-"
+	}
+	return hostname
+}
+
+// sinkLabels returns the container labels to forward, without the labels set by Docker or taken from image metadata
+// as they duplicate the other fields or would only add noise
+func sinkLabels(labels map[string]string) map[string]string {
+	forwarded := make(map[string]string, len(labels))
+	for k, v := range labels {
+		if strings.HasPrefix(k, "com.docker.") || strings.HasPrefix(k, "org.opencontainers.") {
+			continue
+		}
+		forwarded[k] = v
+	}
+	return forwarded
+}
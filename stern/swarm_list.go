@@ -0,0 +1,42 @@
+package stern
+
+import (
+	"context"
+	"iter"
+
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	dockerclient "github.com/docker/docker/client"
+)
+
+func ServiceGenerator(ctx context.Context, config *DockerConfig, client *dockerclient.Client) (iter.Seq[swarm.Service], error) {
+	args := filters.NewArgs()
+	addLabelFilters(args, config.Label)
+	services, err := client.ServiceList(ctx, swarm.ServiceListOptions{Filters: args})
+	if err != nil {
+		return nil, err
+	}
+	return func(yield func(swarm.Service) bool) {
+		for _, s := range services {
+			if !yield(s) {
+				return
+			}
+		}
+	}, nil
+}
+
+func FilteredServiceGenerator(ctx context.Context, config *DockerConfig, client *dockerclient.Client, filter *dockerTargetFilter) (iter.Seq[*DockerTarget], error) {
+	services, err := ServiceGenerator(ctx, config, client)
+	if err != nil {
+		return nil, err
+	}
+
+	return func(yield func(*DockerTarget) bool) {
+		visitor := func(t *DockerTarget) {
+			yield(t)
+		}
+		for service := range services {
+			filter.visitService(service, visitor)
+		}
+	}, nil
+}
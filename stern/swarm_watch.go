@@ -0,0 +1,96 @@
+package stern
+
+import (
+	"context"
+	"time"
+
+	"github.com/containerd/log"
+	"github.com/docker/docker/api/types/events"
+	"github.com/docker/docker/api/types/filters"
+	"github.com/docker/docker/api/types/swarm"
+	dockerclient "github.com/docker/docker/client"
+)
+
+// WatchServices is the Swarm equivalent of WatchDockers. Task replacements (updates, rollbacks, restarts) are followed
+// by the service logs stream itself, so only service creation and removal need to be watched.
+func WatchServices(ctx context.Context, config *DockerConfig, filter *dockerTargetFilter, client *dockerclient.Client) (chan *DockerTarget, error) {
+	added := make(chan *DockerTarget)
+	go func() {
+		defer close(added)
+		visitor := func(t *DockerTarget) {
+			log.L.WithFields(log.Fields{"id": t.Id, "name": t.Name}).Info("Active service")
+			added <- t
+		}
+
+		var since time.Time
+		reconnect(ctx, config.ErrOut, func() error {
+			return watchServices(ctx, config, filter, client, &since, visitor)
+		})
+	}()
+	return added, nil
+}
+
+func watchServices(
+	ctx context.Context,
+	config *DockerConfig,
+	filter *dockerTargetFilter,
+	client *dockerclient.Client,
+	since *time.Time,
+	visitor func(t *DockerTarget),
+) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	// Service events only carry the service name so labels are matched after inspecting the service
+	args := filters.NewArgs()
+	args.Add("type", string(events.ServiceEventType))
+	args.Add("event", string(events.ActionCreate))
+	args.Add("event", string(events.ActionUpdate))
+	args.Add("event", string(events.ActionRemove))
+	opts := events.ListOptions{Filters: args}
+	reconnecting := !since.IsZero()
+	if reconnecting {
+		opts.Since = formatEventTime(*since)
+	} else {
+		*since = time.Now()
+	}
+	watcher, errc := client.Events(ctx, opts)
+
+	services, err := ServiceGenerator(ctx, config, client)
+	if err != nil {
+		return err
+	}
+	listed := make(map[string]bool)
+	for service := range services {
+		listed[service.ID] = true
+		filter.visitService(service, visitor)
+	}
+	if reconnecting {
+		// Services removed while disconnected
+		filter.retain(listed)
+	}
+
+	for {
+		select {
+		case e := <-watcher:
+			*since = time.Unix(0, e.TimeNano)
+			switch e.Action {
+			case events.ActionCreate, events.ActionUpdate:
+				log.L.WithField("id", e.Actor.ID).Info("Inspect service")
+				service, _, err := client.ServiceInspectWithRaw(ctx, e.Actor.ID, swarm.ServiceInspectOptions{})
+				if err != nil {
+					log.L.WithField("id", e.Actor.ID).Error(err, ": failed to inspect service")
+					continue
+				}
+				filter.visitService(service, visitor)
+			case events.ActionRemove:
+				filter.inactive(e.Actor.ID)
+				filter.forget(e.Actor.ID)
+			}
+		case <-ctx.Done():
+			return nil
+		case err := <-errc:
+			return err
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package stern
+
+import (
+	"io"
+	"maps"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+const (
+	// syslogFacility is the daemon facility, like the syslog logging driver of Docker
+	syslogFacility = 3
+	// syslogEnterpriseID is the example enterprise number of RFC 5424, used for the IDs of the structured data
+	syslogEnterpriseID = "32473"
+	// syslogTimeFormat is RFC 3339 with the microseconds allowed by RFC 5424
+	syslogTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+	// syslogMaxUDPSize is the size UDP messages are truncated to, the default maximum message size of rsyslog
+	syslogMaxUDPSize = 8192
+)
+
+// Syslog severities
+const (
+	severityCritical = 2
+	severityError    = 3
+	severityWarning  = 4
+	severityInfo     = 6
+	severityDebug    = 7
+)
+
+// newSyslogSink returns a started sink sending RFC 5424 messages to the syslog server at u. Messages sent over UDP
+// are truncated to syslogMaxUDPSize, and messages sent over TCP and TLS are framed by octet counting as in RFC 6587.
+func newSyslogSink(u *url.URL, errOut io.Writer) *connSink {
+	hostname := sinkHostname()
+	udp := u.Scheme == "udp"
+	return newConnSink(SinkSyslog, u, func(vm Log) ([][]byte, error) {
+		msg := syslogMessage(vm, hostname, time.Now())
+		if udp {
+			return [][]byte{truncateUTF8(msg, syslogMaxUDPSize)}, nil
+		}
+		return [][]byte{append([]byte(strconv.Itoa(len(msg))+" "), msg...)}, nil
+	}, errOut)
+}
+
+// truncateUTF8 truncates b to at most n bytes without splitting a character
+func truncateUTF8(b []byte, n int) []byte {
+	if len(b) <= n {
+		return b
+	}
+	for n > 0 && !utf8.RuneStart(b[n]) {
+		n--
+	}
+	return b[:n]
+}
+
+// syslogMessage formats the line as an RFC 5424 message, like
+//
+//	<27>1 2024-05-01T10:00:00.000000Z myhost web - stderr [tailfin@32473 container="shop-web-1" ...] message
+//
+// The container metadata is sent as the tailfin structured data, and the labels not set by Docker as the labels
+// structured data. Lines without a timestamp get now.
+func syslogMessage(vm Log, hostname string, now time.Time) []byte {
+	timestamp := vm.Timestamp
+	if timestamp.IsZero() {
+		timestamp = now
+	}
+	appName := vm.ServiceName
+	if appName == "" {
+		appName = vm.ContainerName
+	}
+
+	var b strings.Builder
+	b.WriteByte('<')
+	b.WriteString(strconv.Itoa(syslogFacility*8 + syslogSeverity(vm)))
+	b.WriteString(">1 ")
+	b.WriteString(timestamp.Format(syslogTimeFormat))
+	b.WriteByte(' ')
+	b.WriteString(syslogHeaderField(hostname, 255))
+	b.WriteByte(' ')
+	b.WriteString(syslogHeaderField(appName, 48))
+	b.WriteString(" - ")
+	b.WriteString(syslogHeaderField(vm.Stream, 32))
+	b.WriteByte(' ')
+
+	b.WriteString("[tailfin@" + syslogEnterpriseID)
+	for _, param := range [][2]string{
+		{"container", vm.ContainerName},
+		{"id", vm.ContainerID},
+		{"image", vm.Image},
+		{"service", vm.ServiceName},
+		{"namespace", vm.Namespace},
+		{"number", vm.ContainerNumber},
+		{"context", vm.Context},
+	} {
+		writeSyslogParam(&b, param[0], param[1])
+	}
+	b.WriteByte(']')
+	if labels := sinkLabels(vm.Labels); len(labels) > 0 {
+		b.WriteString("[labels@" + syslogEnterpriseID)
+		for _, k := range slices.Sorted(maps.Keys(labels)) {
+			writeSyslogParam(&b, k, labels[k])
+		}
+		b.WriteByte(']')
+	}
+
+	if vm.Message != "" {
+		b.WriteByte(' ')
+		b.WriteString(vm.Message)
+	}
+	return []byte(b.String())
+}
+
+// syslogSeverity returns the severity of the level of JSON and logfmt lines. Other lines are errors on stderr and
+// informational on stdout, like with the syslog logging driver of Docker.
+func syslogSeverity(vm Log) int {
+	level, ok := lineLevel(vm.Message)
+	switch {
+	case !ok && vm.Stream == StreamStderr:
+		return severityError
+	case !ok:
+		return severityInfo
+	case level >= LevelFatal:
+		return severityCritical
+	case level >= LevelError:
+		return severityError
+	case level >= LevelWarn:
+		return severityWarning
+	case level >= LevelInfo:
+		return severityInfo
+	}
+	return severityDebug
+}
+
+// syslogHeaderField returns the value as a header field of at most n printable ASCII characters, or the nil value -
+func syslogHeaderField(value string, n int) string {
+	value = syslogPrintable(value, n)
+	if value == "" {
+		return "-"
+	}
+	return value
+}
+
+// syslogPrintable replaces the characters that are not printable ASCII by _, and truncates the value to n characters
+func syslogPrintable(value string, n int) string {
+	value = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' {
+			return '_'
+		}
+		return r
+	}, value)
+	if len(value) > n {
+		value = value[:n]
+	}
+	return value
+}
+
+func writeSyslogParam(b *strings.Builder, name, value string) {
+	if value == "" {
+		return
+	}
+	name = strings.Map(func(r rune) rune {
+		if r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, syslogPrintable(name, 32))
+	b.WriteByte(' ')
+	b.WriteString(name)
+	b.WriteString(`="`)
+	for _, r := range value {
+		if r == '"' || r == '\\' || r == ']' {
+			b.WriteByte('\\')
+		}
+		b.WriteRune(r)
+	}
+	b.WriteByte('"')
+}
@@ -0,0 +1,250 @@
+package stern
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestSyslogMessage(t *testing.T) {
+	now := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	tests := []struct {
+		vm       Log
+		expected string
+	}{
+		{
+			Log{
+				Message:         `{"level":"warn","msg":"slow"}`,
+				ContainerName:   "shop-web-1",
+				ServiceName:     "web",
+				Namespace:       "shop",
+				ContainerNumber: "1",
+				Stream:          StreamStdout,
+				Timestamp:       time.Date(2024, 5, 1, 9, 59, 59, 123456789, time.UTC),
+				ContainerID:     "8f7a1c",
+				Image:           "shop/web:1.2",
+				Labels: map[string]string{
+					"com.docker.compose.service": "web",
+					"team":                       `check"out]`,
+					"app name":                   "shop",
+				},
+			},
+			`<28>1 2024-05-01T09:59:59.123456Z myhost web - stdout [tailfin@32473 container="shop-web-1" id="8f7a1c" ` +
+				`image="shop/web:1.2" service="web" namespace="shop" number="1"][labels@32473 app_name="shop" team="check\"out\]"] ` +
+				`{"level":"warn","msg":"slow"}`,
+		},
+		{
+			Log{Message: "error 1", ContainerName: "nginx", Stream: StreamStderr, Context: "staging"},
+			`<27>1 2024-05-01T10:00:00.000000Z myhost nginx - stderr [tailfin@32473 container="nginx" context="staging"] error 1`,
+		},
+	}
+
+	for _, tt := range tests {
+		if actual := string(syslogMessage(tt.vm, "myhost", now)); actual != tt.expected {
+			t.Errorf("expected %q, but actual %q", tt.expected, actual)
+		}
+	}
+}
+
+func TestSyslogSeverity(t *testing.T) {
+	tests := []struct {
+		vm       Log
+		expected int
+	}{
+		{Log{Message: "plain", Stream: StreamStdout}, severityInfo},
+		{Log{Message: "plain", Stream: StreamStderr}, severityError},
+		{Log{Message: `{"level":"debug"}`, Stream: StreamStderr}, severityDebug},
+		{Log{Message: `{"level":"info"}`, Stream: StreamStderr}, severityInfo},
+		{Log{Message: `level=warning msg=slow`}, severityWarning},
+		{Log{Message: `{"level":50}`}, severityError},
+		{Log{Message: `{"severity":"FATAL"}`}, severityCritical},
+	}
+
+	for _, tt := range tests {
+		if actual := syslogSeverity(tt.vm); actual != tt.expected {
+			t.Errorf("%q: expected %d, but actual %d", tt.vm.Message, tt.expected, actual)
+		}
+	}
+}
+
+func TestSyslogSinkFraming(t *testing.T) {
+	server := httptest.NewTLSServer(http.NotFoundHandler())
+	defer server.Close()
+	roots := x509.NewCertPool()
+	roots.AddCert(server.Certificate())
+	rootCAs := sinkRootCAs
+	sinkRootCAs = roots
+	t.Cleanup(func() { sinkRootCAs = rootCAs })
+
+	tests := []struct {
+		scheme string
+		listen func() (net.Listener, error)
+	}{
+		{"tcp", func() (net.Listener, error) { return net.Listen("tcp", "127.0.0.1:0") }},
+		{"tls", func() (net.Listener, error) { return tls.Listen("tcp", "127.0.0.1:0", server.TLS) }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.scheme, func(t *testing.T) {
+			l, err := tt.listen()
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			defer l.Close()
+			received := make(chan []string, 1)
+			go func() {
+				conn, err := l.Accept()
+				if err != nil {
+					received <- nil
+					return
+				}
+				defer conn.Close()
+				received <- readOctetCounted(conn)
+			}()
+
+			errOut := new(bytes.Buffer)
+			s := newSyslogSink(&url.URL{Scheme: tt.scheme, Host: l.Addr().String()}, errOut)
+			s.send(Log{Message: "line 1", ContainerName: "nginx", Stream: StreamStdout})
+			s.send(Log{Message: "line 2\n  continued", ContainerName: "nginx", Stream: StreamStdout})
+			s.close()
+
+			messages := <-received
+			if len(messages) != 2 {
+				t.Fatalf("expected 2 messages, but actual %q", messages)
+			}
+			for i, suffix := range []string{" line 1", " line 2\n  continued"} {
+				if !strings.HasPrefix(messages[i], "<30>1 ") || !strings.HasSuffix(messages[i], suffix) {
+					t.Errorf("expected a message ending with %q, but actual %q", suffix, messages[i])
+				}
+			}
+			if errOut.Len() != 0 {
+				t.Errorf("expected no errors, but actual %q", errOut)
+			}
+		})
+	}
+}
+
+// readOctetCounted reads the messages framed by octet counting until EOF
+func readOctetCounted(r io.Reader) []string {
+	var messages []string
+	br := bufio.NewReader(r)
+	for {
+		prefix, err := br.ReadString(' ')
+		if err != nil {
+			return messages
+		}
+		n, err := strconv.Atoi(strings.TrimSuffix(prefix, " "))
+		if err != nil {
+			return messages
+		}
+		msg := make([]byte, n)
+		if _, err := io.ReadFull(br, msg); err != nil {
+			return messages
+		}
+		messages = append(messages, string(msg))
+	}
+}
+
+func TestConnSinkFailure(t *testing.T) {
+	setSinkBackoff(t)
+	// Nothing listens on the address of the closed listener
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	u := &url.URL{Scheme: "tcp", Host: l.Addr().String()}
+	l.Close()
+
+	errOut := new(bytes.Buffer)
+	var formatted []string
+	s := newConnSink("test", u, func(vm Log) ([][]byte, error) {
+		formatted = append(formatted, vm.Message)
+		return [][]byte{[]byte(vm.Message)}, nil
+	}, errOut)
+	for _, msg := range []string{"line 1", "line 2", "line 3"} {
+		s.send(Log{Message: msg})
+	}
+	s.close()
+
+	if expected := []string{"line 1", "line 2", "line 3"}; !reflect.DeepEqual(expected, formatted) {
+		t.Errorf("expected %v, but actual %v", expected, formatted)
+	}
+	lines := strings.Split(strings.TrimSuffix(errOut.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "test: failed to send to "+u.Host+": ") || lines[1] != "test: dropped 3 log lines" {
+		t.Errorf("expected a single failure and 3 dropped lines, but actual %q", errOut)
+	}
+}
+
+func TestSyslogSinkUDP(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+
+	errOut := new(bytes.Buffer)
+	s := newSyslogSink(&url.URL{Scheme: "udp", Host: conn.LocalAddr().String()}, errOut)
+	s.send(Log{Message: strings.Repeat("é", 100000), ContainerName: "nginx", Stream: StreamStdout})
+	s.close()
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 65536)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if n > syslogMaxUDPSize || n < syslogMaxUDPSize-1 || !utf8.Valid(buf[:n]) {
+		t.Errorf("expected a valid message truncated to %d bytes, but actual %d bytes", syslogMaxUDPSize, n)
+	}
+	if errOut.Len() != 0 {
+		t.Errorf("expected no errors, but actual %q", errOut)
+	}
+}
+
+func TestConnSinkMessageTooLarge(t *testing.T) {
+	// A retry would wait until the sink is closed
+	minBackoff := sinkMinBackoff
+	sinkMinBackoff = time.Hour
+	t.Cleanup(func() { sinkMinBackoff = minBackoff })
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	defer conn.Close()
+
+	errOut := new(bytes.Buffer)
+	s := newConnSink("test", &url.URL{Scheme: "udp", Host: conn.LocalAddr().String()}, func(vm Log) ([][]byte, error) {
+		return [][]byte{[]byte(vm.Message)}, nil
+	}, errOut)
+	s.send(Log{Message: strings.Repeat("x", 70000), ContainerName: "nginx"})
+	s.send(Log{Message: "line 2", ContainerName: "nginx"})
+
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	buf := make([]byte, 65536)
+	n, _, err := conn.ReadFrom(buf)
+	if err != nil {
+		t.Fatalf("expected the next line to be sent without retrying the large one, but actual %v", err)
+	}
+	s.close()
+
+	if string(buf[:n]) != "line 2" {
+		t.Errorf("expected %q, but actual %q", "line 2", buf[:n])
+	}
+	lines := strings.Split(strings.TrimSuffix(errOut.String(), "\n"), "\n")
+	if len(lines) != 2 || !strings.HasPrefix(lines[0], "test: dropping a line of nginx: ") || lines[1] != "test: dropped 1 log lines" {
+		t.Errorf("expected the large line to be dropped, but actual %q", errOut)
+	}
+}
@@ -17,6 +17,13 @@ const TimestampFormatDefault = "2006-01-02T15:04:05.000000000Z07:00"
 // time.DateTime without year
 const TimestampFormatShort = "01-02 15:04:05"
 
+// Log streams as reported by the Docker daemon
+const (
+	StreamStdin  = "stdin"
+	StreamStdout = "stdout"
+	StreamStderr = "stderr"
+)
+
 // Log is the object which will be used together with the template to generate
 // the output.
 type Log struct {
@@ -29,6 +36,22 @@ type Log struct {
 	Namespace       string `json:"namespace"`
 	ContainerNumber string `json:"number"`
 
+	// Stream is the stream the line was written to, stdout or stderr
+	Stream string `json:"stream"`
+
+	// Timestamp is the time of the line in the --timezone, zero when unknown
+	Timestamp time.Time `json:"timestamp,omitzero"`
+
+	// ContainerID is the ID of the container, or of the service when tailing Swarm services
+	ContainerID string `json:"id,omitempty"`
+
+	Image  string            `json:"image,omitempty"`
+	Labels map[string]string `json:"labels,omitempty"`
+
+	// Context is the Docker context of the host when tailing multiple hosts
+	Context string `json:"context,omitempty"`
+
+	ContextColor   *color.Color `json:"-"`
 	NamespaceColor *color.Color `json:"-"`
 	ContainerColor *color.Color `json:"-"`
 }
@@ -39,13 +62,23 @@ type TailOptions struct {
 	Location        *time.Location
 
 	DockerSinceTime string
+	SinceTime       time.Time // no lower bound if zero, used where the logs are not filtered by dockerd
+	UntilTime       time.Time // no upper bound if zero
 	Exclude         []*regexp.Regexp
 	Include         []*regexp.Regexp
 	Highlight       []*regexp.Regexp
+	MinLevel        int // lines of JSON and logfmt logs below the level are excluded, 0 for all lines
 	DockerTailLines string
+	Stream          string // stdout or stderr, empty for both
+	MaxLineSize     int    // lines joined from partial messages are truncated at this size, 0 for no limit
 	Follow          bool
 	OnlyLogLines    bool
 
+	// Lines matching MultilinePattern, or not matching MultilineStart, continue the previous line
+	MultilinePattern *regexp.Regexp
+	MultilineStart   *regexp.Regexp
+	MultilineTimeout time.Duration
+
 	// regexp for highlighting the matched string
 	reHightlight *regexp.Regexp
 }
@@ -60,6 +93,16 @@ func (o TailOptions) IsExclude(msg string) bool {
 	return false
 }
 
+// IsBelowLevel returns true if the message has a level lower than the minimum level. Messages without a level are
+// never below it.
+func (o TailOptions) IsBelowLevel(msg string) bool {
+	if o.MinLevel == 0 {
+		return false
+	}
+	level, ok := lineLevel(msg)
+	return ok && level < o.MinLevel
+}
+
 func (o TailOptions) IsInclude(msg string) bool {
 	if len(o.Include) == 0 {
 		return true
@@ -74,6 +117,41 @@ func (o TailOptions) IsInclude(msg string) bool {
 	return false
 }
 
+// IsBeforeSince returns true if the RFC3339Nano timestamp is before the since bound
+func (o TailOptions) IsBeforeSince(timestamp string) bool {
+	if o.SinceTime.IsZero() {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return false
+	}
+	return t.Before(o.SinceTime)
+}
+
+// IsAfterUntil returns true if the RFC3339Nano timestamp is after the until bound
+func (o TailOptions) IsAfterUntil(timestamp string) bool {
+	if o.UntilTime.IsZero() {
+		return false
+	}
+	t, err := time.Parse(time.RFC3339Nano, timestamp)
+	if err != nil {
+		return false
+	}
+	return t.After(o.UntilTime)
+}
+
+func (o TailOptions) IsMultiline() bool {
+	return o.MultilinePattern != nil || o.MultilineStart != nil
+}
+
+func (o TailOptions) IsMultilineContinuation(msg string) bool {
+	if o.MultilinePattern != nil && o.MultilinePattern.MatchString(msg) {
+		return true
+	}
+	return o.MultilineStart != nil && !o.MultilineStart.MatchString(msg)
+}
+
 var colorHighlight = color.New(color.FgRed, color.Bold).SprintFunc()
 
 func (o TailOptions) HighlightMatchedString(msg string) string {
@@ -115,6 +193,14 @@ func (o TailOptions) UpdateTimezoneAndFormat(timestamp string) (string, error) {
 	return t.In(o.Location).Format(format), nil
 }
 
+// logTimestamp returns the timestamp in the location of the options, for the Timestamp of the Log
+func (o TailOptions) logTimestamp(t time.Time) time.Time {
+	if t.IsZero() || o.Location == nil {
+		return t
+	}
+	return t.In(o.Location)
+}
+
 func splitLogLine(line string) (timestamp string, content string, err error) {
 	idx := strings.IndexRune(line, ' ')
 	if idx == -1 {
@@ -143,8 +229,8 @@ func removeSubsecond(timestamp string) string {
 }
 
 type ResumeRequest struct {
-	Timestamp   string // RFC3339 timestamp (not RFC3339Nano)
-	LinesToSkip int    // the number of lines to skip during this timestamp
+	Timestamp   string `json:"timestamp"`   // RFC3339 timestamp (not RFC3339Nano)
+	LinesToSkip int    `json:"linesToSkip"` // the number of lines to skip during this timestamp
 }
 
 func (r *ResumeRequest) shouldSkip(timestamp string) bool {
@@ -316,3 +316,27 @@ func TestHighlightMatchedString(t *testing.T) {
 		}
 	}
 }
+
+func TestIsAfterUntil(t *testing.T) {
+	until := time.Date(2023, 2, 13, 21, 20, 30, 0, time.UTC)
+
+	tests := []struct {
+		until     time.Time
+		timestamp string
+		expected  bool
+	}{
+		{time.Time{}, "2023-02-13T21:20:31Z", false},
+		{until, "2023-02-13T21:20:29.999999999Z", false},
+		{until, "2023-02-13T21:20:30Z", false},
+		{until, "2023-02-13T21:20:30.000000001Z", true},
+		{until, "2023-02-13T22:20:30+01:00", false},
+		{until, "invalid", false},
+	}
+
+	for i, tt := range tests {
+		o := &TailOptions{UntilTime: tt.until}
+		if actual := o.IsAfterUntil(tt.timestamp); actual != tt.expected {
+			t.Errorf("%d: expected %v, but actual %v", i, tt.expected, actual)
+		}
+	}
+}
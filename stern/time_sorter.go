@@ -0,0 +1,109 @@
+package stern
+
+import (
+	"container/heap"
+	"context"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// timeSorter prints the log lines of all tails ordered by their Docker timestamp. In follow mode every line is held back
+// for the reorder window so that lines arriving late from other containers can be printed before it. Without a window
+// the lines are held until flushed, merging the complete streams.
+type timeSorter struct {
+	out    io.Writer
+	window time.Duration
+	lines  sortedLines
+	seq    uint64
+	mu     sync.Mutex
+}
+
+type sortedLine struct {
+	timestamp time.Time
+	received  time.Time
+	seq       uint64 // keeps the order of lines with the same timestamp
+	text      string
+}
+
+func newTimeSorter(out io.Writer, window time.Duration) *timeSorter {
+	return &timeSorter{
+		out:    out,
+		window: window,
+	}
+}
+
+func (s *timeSorter) add(timestamp time.Time, text string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.seq++
+	heap.Push(&s.lines, sortedLine{
+		timestamp: timestamp,
+		received:  time.Now(),
+		seq:       s.seq,
+		text:      text,
+	})
+}
+
+// run prints the lines whose reorder window has passed until the context is done
+func (s *timeSorter) run(ctx context.Context) {
+	if s.window == 0 {
+		return
+	}
+	ticker := time.NewTicker(max(s.window/10, 10*time.Millisecond))
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			s.release(now)
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// release prints the lines received more than the reorder window before now, as long as they are the oldest ones
+func (s *timeSorter) release(now time.Time) {
+	if s.window == 0 {
+		return
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.lines) > 0 && !s.lines[0].received.Add(s.window).After(now) {
+		fmt.Fprint(s.out, heap.Pop(&s.lines).(sortedLine).text)
+	}
+}
+
+// flush prints all lines
+func (s *timeSorter) flush() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for len(s.lines) > 0 {
+		fmt.Fprint(s.out, heap.Pop(&s.lines).(sortedLine).text)
+	}
+}
+
+// sortedLines implements heap.Interface
+type sortedLines []sortedLine
+
+func (l sortedLines) Len() int { return len(l) }
+
+func (l sortedLines) Less(i, j int) bool {
+	if l[i].timestamp.Equal(l[j].timestamp) {
+		return l[i].seq < l[j].seq
+	}
+	return l[i].timestamp.Before(l[j].timestamp)
+}
+
+func (l sortedLines) Swap(i, j int) { l[i], l[j] = l[j], l[i] }
+
+func (l *sortedLines) Push(x any) { *l = append(*l, x.(sortedLine)) }
+
+func (l *sortedLines) Pop() any {
+	old := *l
+	n := len(old)
+	line := old[n-1]
+	*l = old[:n-1]
+	return line
+}
@@ -0,0 +1,62 @@
+package stern
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestTimeSorterFlush(t *testing.T) {
+	ts := func(sec int) time.Time {
+		return time.Date(2023, 2, 13, 21, 20, sec, 0, time.UTC)
+	}
+
+	out := new(bytes.Buffer)
+	sorter := newTimeSorter(out, 0)
+	// Two containers with interleaved timestamps
+	sorter.add(ts(1), "c1 line 1\n")
+	sorter.add(ts(3), "c1 line 3\n")
+	sorter.add(ts(3), "c1 line 4\n")
+	sorter.add(ts(0), "c2 line 0\n")
+	sorter.add(ts(2), "c2 line 2\n")
+	sorter.add(ts(3), "c2 line 5\n")
+
+	sorter.release(time.Now())
+	if out.Len() != 0 {
+		t.Fatalf("expected no output before flush, but actual %s", out)
+	}
+
+	sorter.flush()
+	expected := `c2 line 0
+c1 line 1
+c2 line 2
+c1 line 3
+c1 line 4
+c2 line 5
+`
+	if expected != out.String() {
+		t.Errorf("expected %s, but actual %s", expected, out)
+	}
+}
+
+func TestTimeSorterRelease(t *testing.T) {
+	ts := func(sec int) time.Time {
+		return time.Date(2023, 2, 13, 21, 20, sec, 0, time.UTC)
+	}
+
+	out := new(bytes.Buffer)
+	sorter := newTimeSorter(out, time.Minute)
+	sorter.add(ts(2), "line 2\n")
+	sorter.add(ts(1), "line 1\n")
+
+	sorter.release(time.Now())
+	if out.Len() != 0 {
+		t.Fatalf("expected no output within the window, but actual %s", out)
+	}
+
+	sorter.release(time.Now().Add(time.Minute))
+	expected := "line 1\nline 2\n"
+	if expected != out.String() {
+		t.Errorf("expected %s, but actual %s", expected, out)
+	}
+}